@@ -1,20 +1,94 @@
 package main
 
 import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
 	clientset "builder/pkg/client/generated/clientset/versioned"
 	informer "builder/pkg/client/generated/informers/externalversions"
 	"builder/pkg/controller"
+	_ "builder/pkg/downloader"
+	"builder/pkg/downloader/archive"
+	"builder/pkg/downloader/cache"
+	"builder/pkg/executor"
+	_ "builder/pkg/executor/plugins"
 	"builder/pkg/signals"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 )
 
+var (
+	config             controller.Config
+	cacheDir           string
+	cacheMaxSize       int64
+	insecureRegistries string
+	registryCAFile     string
+)
+
+func init() {
+	flag.StringVar(&config.Namespace, "namespace", "default", "Namespace in which build jobs run and referenced secrets are looked up.")
+	flag.StringVar(&config.WorkspaceRoot, "workspace-root", "/var/lib/builder", "Directory holding the workspace of every builder.")
+	flag.DurationVar(&config.DownloadTimeout, "download-timeout", 5*time.Minute, "Timeout of a single attempt to download a build context.")
+	flag.DurationVar(&config.DefaultBuildTimeout, "default-build-timeout", 10*time.Minute, "Timeout of builders that don't set spec.buildTimeout, 0 disables it.")
+	flag.IntVar(&config.ContextLimits.MaxFiles, "max-context-files", archive.DefaultLimits.MaxFiles, "Maximum number of entries a build context archive may contain.")
+	flag.Int64Var(&config.ContextLimits.MaxSize, "max-context-size", archive.DefaultLimits.MaxSize, "Maximum number of bytes a build context archive may expand to.")
+	flag.StringVar(&cacheDir, "cache-dir", "/var/cache/builder", "Directory caching downloaded build contexts, empty disables the cache.")
+	flag.Int64Var(&cacheMaxSize, "cache-max-size", 10<<30, "Maximum number of bytes the build context cache may hold, least recently used contexts are evicted first.")
+	flag.StringVar(&config.WorkspaceClaim, "workspace-claim", "", "PersistentVolumeClaim in the controller namespace holding the workspace root, build jobs mount the prepared context from it. Without it build jobs fetch remote contexts themselves and builders verifying or authenticating their context are rejected.")
+	flag.StringVar(&config.DefaultExecutor, "default-executor", "kaniko", "Executor building the images of builders that don't choose one.")
+	flag.BoolVar(&config.EnableFakeExecutor, "enable-fake-executor", false, "Offer the fake executor, which builds nothing and only suits test clusters.")
+	flag.StringVar(&config.KanikoImage, "kaniko-image", "gcr.io/kaniko-project/executor:v1.23.2", "Image of the kaniko executor used by build jobs.")
+	flag.StringVar(&config.BuildKitImage, "buildkit-image", "moby/buildkit:v0.16.0", "Image providing buildctl for build jobs of the buildkit executor.")
+	flag.StringVar(&config.BuildKitAddress, "buildkit-address", "tcp://buildkitd:1234", "Address of the buildkitd used by the buildkit executor.")
+	flag.DurationVar(&config.ImageVerifyInterval, "image-verify-interval", 10*time.Minute, "How often images are looked up in their registry again.")
+	flag.StringVar(&insecureRegistries, "insecure-registries", "", "Comma separated registries reached without TLS verification, over plain http if they don't speak https.")
+	flag.DurationVar(&config.RegistryTimeout, "registry-timeout", time.Minute, "Timeout of a single request to a registry.")
+	flag.IntVar(&config.RegistryRetries, "registry-retries", 3, "How often a failed request to a registry is retried.")
+	flag.StringVar(&config.DefaultCache.Type, "default-build-cache", "None", "Layer cache of builders that don't choose one: Registry, Volume, Inline or None.")
+	flag.StringVar(&config.DefaultCache.Mode, "default-build-cache-mode", "max", "Layers exported to the cache of builders that don't choose: min for the final stage, max for every stage.")
+	flag.StringVar(&config.CacheRepository, "build-cache-repository", "", "Repository Registry caches are exported to by default, tagged with the cache key.")
+	flag.StringVar(&config.CacheClaim, "build-cache-claim", "", "PersistentVolumeClaim in the controller namespace holding Volume caches.")
+	flag.StringVar(&registryCAFile, "registry-ca-file", "", "PEM file with the CA certificates of registries using self-signed certificates, added to the system pool.")
+}
+
 func main() {
 	klog.InitFlags(nil)
+	flag.Parse()
 	ctx := signals.SetupSignalHandler()
 	logger := klog.FromContext(ctx)
 
+	if config.ImageVerifyInterval <= 0 {
+		logger.Error(nil, "--image-verify-interval must be positive", "interval", config.ImageVerifyInterval)
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	if insecureRegistries != "" {
+		config.InsecureRegistries = strings.Split(insecureRegistries, ",")
+	}
+	if registryCAFile != "" {
+		pool, err := registryCAs(registryCAFile)
+		if err != nil {
+			logger.Error(err, "Error loading registry CA certificates")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+		config.RegistryCAs = pool
+	}
+
+	if cacheDir != "" {
+		contextCache, err := cache.New(cacheDir, cacheMaxSize)
+		if err != nil {
+			logger.Error(err, "Error opening build context cache")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+		config.ContextCache = contextCache
+	}
+
 	cfg, err := clientcmd.BuildConfigFromFlags("", "/root/.kube/config")
 	if err != nil {
 		logger.Error(err, "Error building kubeconfig")
@@ -35,15 +109,50 @@ func main() {
 	}
 
 	factory := informer.NewSharedInformerFactory(client, 0)
+	// build Jobs and their Pods carry the builder label
+	kubeFactory := kubeinformers.NewSharedInformerFactoryWithOptions(k8sClient, 0,
+		kubeinformers.WithNamespace(config.Namespace),
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = executor.BuilderLabel
+		}))
 
-	controller := controller.NewController(ctx, k8sClient, client,
+	imageController := controller.NewImageController(ctx, config, k8sClient, client,
+		factory.Image().V1().Images())
+	controller := controller.NewController(ctx, config, k8sClient, client,
 		factory.Image().V1().Images(),
-		factory.Builder().V1().Builders())
+		factory.Builder().V1().Builders(),
+		kubeFactory.Batch().V1().Jobs(),
+		kubeFactory.Core().V1().Pods())
 
 	factory.Start(ctx.Done())
+	kubeFactory.Start(ctx.Done())
+
+	go func() {
+		if err := imageController.Run(ctx, 2); err != nil {
+			logger.Error(err, "Error running image controller")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	}()
 
 	if err = controller.Run(ctx, 2); err != nil {
 		logger.Error(err, "Error running controller")
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 }
+
+// registryCAs returns the system certificate pool extended by the
+// certificates in file.
+func registryCAs(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	_ "builder/pkg/downloader"
+	"builder/pkg/downloader/downloaderPlugin"
 	"fmt"
-	_ "test/pkg/downloader"
-	"test/pkg/downloader/downloaderPlugin"
 )
 
 func main() {
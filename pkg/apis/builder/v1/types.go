@@ -1,27 +1,321 @@
 package v1
 
 import (
+	imagev1 "builder/pkg/apis/image/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // BuilderSpec defines the desired state of Builder
 type BuilderSpec struct {
-	DockerFileBase64 string        `json:"dockerFileBase64"`
-	DockerFileString string        `json:"dockerFileString"`
-	RemoteContext    RemoteContext `json:"remoteContext"`
+	// DockerFileBase64 is a base64 encoded Dockerfile built instead of the one
+	// in the remote context, mutually exclusive with DockerFileString.
+	DockerFileBase64 string `json:"dockerFileBase64"`
+	// DockerFileString is a Dockerfile built instead of the one in the remote
+	// context, mutually exclusive with DockerFileBase64.
+	DockerFileString string `json:"dockerFileString"`
+	// RemoteContext is the build context, it may be left empty when the
+	// Dockerfile is given inline and needs no context. Its authConfigMap,
+	// digests and signature are checked by the controller, Builders setting
+	// them are rejected unless the controller runs with a workspace claim.
+	RemoteContext RemoteContext `json:"remoteContext"`
 
-	// +kubebuilder:validation:Minimum=1
+	// BuildTimeout is the number of minutes the Builder may take from entering
+	// the Getting state until its image is published. The controller default
+	// applies when it is 0.
+	// +kubebuilder:validation:Minimum=0
 	// +kubebuilder:validation:Maximum=10
 	BuildTimeout int `json:"buildTimeout"`
 
 	BuildName string `json:"buildName"`
+
+	// Executor is the build tool building the image, kaniko or buildkit.
+	// The controller default is used when it is empty.
+	Executor string `json:"executor,omitempty"`
+
+	// BuildArgs set the ARG instructions of the Dockerfile, like --build-arg.
+	// +listType=map
+	// +listMapKey=name
+	BuildArgs []BuildArg `json:"buildArgs,omitempty"`
+	// Target is the stage of a multi-stage Dockerfile that is built, the last
+	// stage by default.
+	Target string `json:"target,omitempty"`
+	// Labels are added to the config of the built image.
+	Labels map[string]string `json:"labels,omitempty"`
+	// NoCache builds every instruction again instead of reusing cached
+	// layers, a configured cache is still exported.
+	NoCache bool `json:"noCache,omitempty"`
+	// Cache keeps the layers of the build for later builds to reuse. The
+	// controller defaults apply to what is left empty.
+	Cache *BuildCache `json:"cache,omitempty"`
+	// Platforms the image is built for, e.g. linux/amd64 and linux/arm64.
+	// More than one platform pushes an image index, which needs the buildkit
+	// executor and either nodes or emulation for every platform. The
+	// platform of the build node is used when it is empty.
+	// +listType=set
+	Platforms []string `json:"platforms,omitempty"`
+
+	// Secrets are mounted by RUN --mount=type=secret,id=<id> instructions.
+	// They need the buildkit executor and are only handed to the build,
+	// their values are never copied into the Builder or the controller log.
+	// +listType=map
+	// +listMapKey=id
+	Secrets []BuildSecret `json:"secrets,omitempty"`
+	// SSH forwards a private key to RUN --mount=type=ssh instructions, it
+	// needs the buildkit executor.
+	SSH *BuildSSH `json:"ssh,omitempty"`
+
+	// Image is where the built image is pushed to. Once the push succeeded
+	// an Image resource with the same spec is created for the Builder.
+	Image imagev1.ImageSpec `json:"image"`
+	// Outputs are further destinations of the image, each reported on its
+	// own in the status. The controller pushes them, which needs its
+	// workspace claim.
+	// +listType=map
+	// +listMapKey=name
+	Outputs []BuildOutput `json:"outputs,omitempty"`
+
+	// DeletionPolicy decides what happens to the Image resource when the
+	// Builder is deleted: Delete removes it along with the Builder, Orphan
+	// keeps it. Delete by default.
+	// +kubebuilder:validation:Enum=Delete;Orphan
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+}
+
+// BuildArg is a build argument of the Dockerfile. Its value is either given
+// inline or taken from a key of a ConfigMap or Secret in the controller
+// namespace, the build can't start until that key exists and fails once its
+// build timeout passes.
+type BuildArg struct {
+	// Name of the ARG instruction.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Value of the argument, must not be set along with ValueFrom.
+	Value string `json:"value,omitempty"`
+	// ValueFrom takes the value from a ConfigMap or Secret.
+	ValueFrom *BuildArgSource `json:"valueFrom,omitempty"`
+}
+
+// BuildArgSource names the ConfigMap or Secret key a build argument is read
+// from, exactly one of its fields must be set.
+type BuildArgSource struct {
+	// ConfigMapKeyRef selects a key of a ConfigMap.
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// SecretKeyRef selects a key of a Secret.
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// BuildOutput is a destination of the built image, exactly one of Registry
+// and Tarball is set.
+//
+// Tags and the tarball url are templates expanded with the metadata of the
+// build: {{.Name}} is the Builder name, {{.Commit}} and {{.ShortCommit}} the
+// git commit the remote context resolved to, only git contexts may use them,
+// and {{.Timestamp}} the time the Builder started as 20060102150405 in UTC.
+type BuildOutput struct {
+	// Name identifies the output in the status.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+	// Registry pushes the image to a repository under several tags.
+	Registry *RegistryOutput `json:"registry,omitempty"`
+	// Tarball uploads the image as an OCI image layout tar archive.
+	Tarball *TarballOutput `json:"tarball,omitempty"`
+}
+
+// RegistryOutput pushes the image to a repository.
+type RegistryOutput struct {
+	// Repository the image is pushed to, e.g. registry.example.com/team/app.
+	Repository string `json:"repository"`
+	// Tags the image is pushed under, e.g. latest, v1.2.3 or {{.ShortCommit}}.
+	// +kubebuilder:validation:MinItems=1
+	Tags []string `json:"tags"`
+	// RegisterSecret names a kubernetes.io/dockerconfigjson Secret in the
+	// controller namespace with the credentials of the registry,
+	// spec.image.registerSecret by default.
+	RegisterSecret string `json:"registerSecret,omitempty"`
+}
+
+// TarballOutput uploads the image to S3 or an S3 compatible store like
+// MinIO.
+type TarballOutput struct {
+	// Url of the archive, s3://bucket/key.
+	// +kubebuilder:validation:Pattern=`^s3://`
+	Url string `json:"url"`
+	// AuthConfigMap names a ConfigMap and a Secret of the same name in the
+	// controller namespace holding the endpoint and credentials, with the
+	// keys of an s3 remote context.
+	AuthConfigMap string `json:"authConfigMap,omitempty"`
+}
+
+// BuildCache configures where the layers of a build are cached.
+type BuildCache struct {
+	// Type of the cache: Registry imports it from the From images and
+	// exports it to the To image, Volume keeps it in the shared cache volume
+	// of the controller, Inline writes it into the pushed image and imports
+	// it from the From images, None disables caching.
+	// +kubebuilder:validation:Enum=Registry;Volume;Inline;None
+	Type string `json:"type,omitempty"`
+	// Key names the cache, the Builder name by default. It is the tag of the
+	// default cache image, which Builders with the same key share, and the
+	// directory of the cache of the Builder in the cache volume.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`
+	Key string `json:"key,omitempty"`
+	// From are the images the cache is imported from, the To image of a
+	// Registry cache and the pushed image of an Inline cache by default.
+	From []string `json:"from,omitempty"`
+	// To is the image a Registry cache is exported to, the controller cache
+	// repository tagged with Key by default.
+	To string `json:"to,omitempty"`
+	// Mode of the exported cache, min keeps the layers of the final stage,
+	// max those of every stage.
+	// +kubebuilder:validation:Enum=min;max
+	Mode string `json:"mode,omitempty"`
+}
+
+// BuildSecret hands the key of a Secret in the controller namespace to the
+// build as a build secret.
+type BuildSecret struct {
+	// ID the Dockerfile mounts the secret with.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.-]+$`
+	ID string `json:"id"`
+	// SecretKeyRef selects the key holding the value of the secret.
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef"`
+}
+
+// BuildSSH hands a private key from a Secret in the controller namespace to
+// the build, like ssh-agent forwarding.
+type BuildSSH struct {
+	// ID the Dockerfile mounts the key with, default by default.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.-]+$`
+	ID string `json:"id,omitempty"`
+	// SecretName names the Secret holding the key, usually of type
+	// kubernetes.io/ssh-auth.
+	SecretName string `json:"secretName"`
+	// Key of the private key inside the Secret, ssh-privatekey by default.
+	Key string `json:"key,omitempty"`
 }
 
 // BuilderStatus defines the observed state of Builder.
 // It should always be reconstructable from the state of the cluster and/or outside world.
 type BuilderStatus struct {
+	// State is the phase the Builder is in: Getting, Building, Pushing,
+	// Creating, Finished or Failed.
+	State string `json:"state"`
+
+	// ObservedGeneration is the generation of the spec the status was
+	// written for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Reason is a CamelCase word explaining why the Builder entered its
+	// current state, e.g. BuildFailed.
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the last transition.
+	Message string `json:"message,omitempty"`
+
+	// ImageDigest is the digest of the manifest pushed to the registry.
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// Image refers to the Image resource created for the pushed image.
+	Image *ImageReference `json:"image,omitempty"`
+	// Platforms lists the image pushed for each platform, a multi-platform
+	// image has one entry per platform in the index ImageDigest points to.
+	Platforms []PlatformImage `json:"platforms,omitempty"`
+
+	// Progress reports how much of the build context has been downloaded,
+	// e.g. 12.0MiB/40.5MiB, while the Builder is in the Getting state.
+	Progress string `json:"progress,omitempty"`
+
+	// Context describes the build context downloaded for the Builder.
+	Context *ContextStatus `json:"context,omitempty"`
+
+	// StartTime is when the Builder entered the Getting state, BuildTimeout
+	// counts from it.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is when the Builder reached Finished or Failed.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Duration is the time from StartTime to CompletionTime, e.g. 1m30s.
+	Duration string `json:"duration,omitempty"`
+	// Phases records when the Builder entered and left each phase, oldest
+	// first.
+	Phases []PhaseStatus `json:"phases,omitempty"`
+
+	// Cache reports how the build used the layer cache.
+	Cache *CacheStatus `json:"cache,omitempty"`
+	// Outputs reports the result of each of spec.outputs.
+	// +listType=map
+	// +listMapKey=name
+	Outputs []OutputStatus `json:"outputs,omitempty"`
+
+	// Conditions describe the latest observations of the Builder.
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// PhaseStatus records one phase a Builder went through.
+type PhaseStatus struct {
+	// Phase is the state of the Builder during the phase, e.g. Building.
+	Phase string `json:"phase"`
+	// StartTime is when the Builder entered the phase.
+	StartTime metav1.Time `json:"startTime"`
+	// CompletionTime is when the Builder left the phase, it is unset while
+	// the phase runs.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// ImageReference refers to the Image resource of a Builder.
+type ImageReference struct {
+	// Name of the Image resource.
+	Name string `json:"name"`
+	// PullPath is the digest reference of the image, e.g.
+	// registry.example.com/app:v1@sha256:...
+	PullPath string `json:"pullPath"`
+}
+
+// PlatformImage is the image pushed for one platform.
+type PlatformImage struct {
+	// Platform of the image, e.g. linux/arm64.
+	Platform string `json:"platform"`
+	// Digest of the manifest of the image.
+	Digest string `json:"digest"`
+}
+
+// OutputStatus is the result of one output of the Builder.
+type OutputStatus struct {
+	// Name of the output in spec.outputs.
+	Name string `json:"name"`
+	// State is Pushed once the image reached the output, or Failed.
 	State string `json:"state"`
-	// INSERT ADDITIONAL STATUS FIELDS -- observed state of cluster
+	// References the image was pushed as, e.g. registry.example.com/app:v1.
+	References []string `json:"references,omitempty"`
+	// Url the tarball was uploaded to.
+	Url string `json:"url,omitempty"`
+	// Digest of the pushed manifest, or the sha256 digest of the tarball.
+	Digest string `json:"digest,omitempty"`
+	// Message explains why the output failed.
+	Message string `json:"message,omitempty"`
+}
+
+// CacheStatus counts the build steps that were reused from the cache.
+type CacheStatus struct {
+	// Type of the cache the build used.
+	Type string `json:"type"`
+	// Hits is the number of steps reused from the cache.
+	Hits int32 `json:"hits"`
+	// Misses is the number of steps that ran.
+	Misses int32 `json:"misses"`
+}
+
+// ContextStatus describes a build context stored in the workspace of a Builder.
+type ContextStatus struct {
+	// Path is the location of the build context on the controller.
+	Path string `json:"path"`
+	// Size is the number of bytes downloaded.
+	Size int64 `json:"size"`
+	// Digest is the sha256 digest of the downloaded content.
+	Digest string `json:"digest"`
+	// Revision is the revision of the source that was downloaded, e.g. the
+	// git commit a branch resolved to.
+	Revision string `json:"revision,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -32,6 +326,11 @@ type BuilderStatus struct {
 // +k8s:openapi-gen=true
 // +kubebuilder:resource:scope=Cluster
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.state`
+// +kubebuilder:printcolumn:name="Progress",type=string,JSONPath=`.status.progress`
+// +kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.status.image.pullPath`
+// +kubebuilder:printcolumn:name="Duration",type=string,JSONPath=`.status.duration`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 type Builder struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -55,11 +354,47 @@ type RemoteContext struct {
 	ContentUrl string `json:"contentUrl"`
 	Type       string `json:"type"`
 
+	// DockerFileName is the Dockerfile inside the context, Dockerfile by
+	// default. It must not be set along with an inline Dockerfile.
 	// +kubebuilder:validation:MaxLength=20
 	// +kubebuilder:validation:MinLength=1
 	DockerFileName string `json:"dockerFileName"`
 
+	// AuthConfigMap names a ConfigMap and a Secret of the same name in the
+	// controller namespace holding the credentials of the remote context.
 	// +kubebuilder:validation:MaxLength=20
 	// +kubebuilder:validation:MinLength=1
 	AuthConfigMap string `json:"authConfigMap"`
+
+	// Sha256 is the expected hex encoded sha256 digest of the downloaded
+	// content, checked before archives are extracted.
+	// +kubebuilder:validation:Pattern=`^[a-fA-F0-9]{64}$`
+	Sha256 string `json:"sha256,omitempty"`
+	// Sha512 is the expected hex encoded sha512 digest of the downloaded
+	// content, checked before archives are extracted.
+	// +kubebuilder:validation:Pattern=`^[a-fA-F0-9]{128}$`
+	Sha512 string `json:"sha512,omitempty"`
+
+	// Signature verifies a detached signature of the downloaded content.
+	Signature *SignatureVerification `json:"signature,omitempty"`
+}
+
+// SignatureVerification describes a detached signature of a remote context.
+type SignatureVerification struct {
+	// Url of the signature file, it is fetched by the downloader matching
+	// its scheme with the same credentials as the remote context.
+	// +kubebuilder:validation:MaxLength=200
+	Url string `json:"url"`
+
+	// Format of the signature, a base64 cosign blob signature or a minisign
+	// signature file.
+	// +kubebuilder:validation:Enum=cosign;minisign
+	Format string `json:"format"`
+
+	// PublicKeySecret names the Secret in the controller namespace holding
+	// the public key.
+	PublicKeySecret string `json:"publicKeySecret"`
+	// PublicKeyKey is the key of the public key inside the Secret, defaults
+	// to cosign.pub or minisign.pub depending on the format.
+	PublicKeyKey string `json:"publicKeyKey,omitempty"`
 }
@@ -16,10 +16,33 @@ type ImageSpec struct {
 // ImageStatus defines the observed state of Image.
 // It should always be reconstructable from the state of the cluster and/or outside world.
 type ImageStatus struct {
-	// INSERT ADDITIONAL STATUS FIELDS -- observed state of cluster
-	ImageSize     string `json:"imageSize"`
-	ImagePullPath string `json:"imagePullPath"`
-	State         string `json:"state"`
+	// ImageSize is the size of the image in the registry, manifests, config
+	// and layers of every platform added up, e.g. 12.3MiB.
+	ImageSize string `json:"imageSize,omitempty"`
+	// ImagePullPath is the digest reference of the image, e.g.
+	// registry.example.com/app:v1@sha256:...
+	ImagePullPath string `json:"imagePullPath,omitempty"`
+	// State is Available while the registry serves the image, NotFound once
+	// it is gone and Unknown when the registry can't be asked.
+	State string `json:"state,omitempty"`
+
+	// Digest is the digest of the manifest or index the tag points to.
+	Digest string `json:"digest,omitempty"`
+	// Size is ImageSize in bytes.
+	Size int64 `json:"size,omitempty"`
+	// Architectures lists the platforms of the image, e.g. linux/amd64.
+	Architectures []string `json:"architectures,omitempty"`
+	// Created is the creation time recorded in the image config, the latest
+	// one for multi-platform images.
+	Created *metav1.Time `json:"created,omitempty"`
+	// LastVerified is when the controller last found the image in the
+	// registry.
+	LastVerified *metav1.Time `json:"lastVerified,omitempty"`
+	// Message explains the State, e.g. the error returned by the registry.
+	Message string `json:"message,omitempty"`
+	// ObservedGeneration is the generation of the spec the status was
+	// written for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -28,6 +51,12 @@ type ImageStatus struct {
 // +genclient
 // +genclient:nonNamespaced
 // +k8s:openapi-gen=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+// +kubebuilder:printcolumn:name="Pull Path",type=string,JSONPath=`.status.imagePullPath`
+// +kubebuilder:printcolumn:name="Size",type=string,JSONPath=`.status.imageSize`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 type Image struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	"builder/pkg/downloader"
+	"builder/pkg/downloader/downloaderPlugin"
+	"builder/pkg/downloader/signature"
+)
+
+// resolveAuth collects the authentication data named by an AuthConfigMap of
+// the spec, e.g. that of the remote context. It names a ConfigMap and a
+// Secret in the controller namespace, either of them may be missing, values
+// of the Secret win.
+func (c *Controller) resolveAuth(ctx context.Context, name string) (downloaderPlugin.Auth, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	auth := downloaderPlugin.Auth{}
+	found := false
+	configMap, err := c.kubeclientset.CoreV1().ConfigMaps(c.config.Namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		found = true
+		for key, value := range configMap.Data {
+			auth[key] = value
+		}
+	case !errors.IsNotFound(err):
+		return nil, err
+	}
+
+	secret, err := c.kubeclientset.CoreV1().Secrets(c.config.Namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		found = true
+		for key, value := range secret.Data {
+			auth[key] = string(value)
+		}
+	case !errors.IsNotFound(err):
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("neither configmap nor secret %s/%s found", c.config.Namespace, name)
+	}
+	return auth, nil
+}
+
+// resolveSignature reads the public key of the signature verification
+// configured for the remote context.
+func (c *Controller) resolveSignature(ctx context.Context, remote builderv1.RemoteContext) (*downloader.Signature, error) {
+	if remote.Signature == nil {
+		return nil, nil
+	}
+
+	format := signature.Format(remote.Signature.Format)
+	key := remote.Signature.PublicKeyKey
+	if key == "" {
+		key = string(format) + ".pub"
+	}
+	secret, err := c.kubeclientset.CoreV1().Secrets(c.config.Namespace).Get(ctx, remote.Signature.PublicKeySecret, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no key %q", c.config.Namespace, remote.Signature.PublicKeySecret, key)
+	}
+
+	return &downloader.Signature{
+		URL:       remote.Signature.Url,
+		Format:    format,
+		PublicKey: publicKey,
+	}, nil
+}
+
+// expectedDigests returns the digests the remote context must match.
+func expectedDigests(remote builderv1.RemoteContext) []string {
+	var digests []string
+	if remote.Sha256 != "" {
+		digests = append(digests, "sha256:"+strings.ToLower(remote.Sha256))
+	}
+	if remote.Sha512 != "" {
+		digests = append(digests, "sha512:"+strings.ToLower(remote.Sha512))
+	}
+	return digests
+}
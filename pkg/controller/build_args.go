@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+)
+
+// buildSecretIDPattern matches the id of a build secret or SSH key.
+var buildSecretIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// platformPattern matches a platform like linux/amd64 or linux/arm/v7.
+var platformPattern = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+
+// validateBuildOptions checks the build arguments, labels, platforms and
+// secrets of the spec, the build tools would either reject them or silently
+// build something else.
+func validateBuildOptions(spec builderv1.BuilderSpec) *specError {
+	names := make(map[string]bool, len(spec.BuildArgs))
+	for i, arg := range spec.BuildArgs {
+		field := fmt.Sprintf("spec.buildArgs[%d]", i)
+		if arg.Name == "" || strings.ContainsAny(arg.Name, "= \t\n") {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.name %q is not a valid build argument name", field, arg.Name)}
+		}
+		if names[arg.Name] {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.name %q is given more than once", field, arg.Name)}
+		}
+		names[arg.Name] = true
+
+		source := arg.ValueFrom
+		if source == nil {
+			continue
+		}
+		if arg.Value != "" {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.value and %s.valueFrom are mutually exclusive", field, field)}
+		}
+		switch {
+		case (source.ConfigMapKeyRef == nil) == (source.SecretKeyRef == nil):
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.valueFrom must set exactly one of configMapKeyRef and secretKeyRef", field)}
+		case source.ConfigMapKeyRef != nil && (source.ConfigMapKeyRef.Name == "" || source.ConfigMapKeyRef.Key == ""):
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.valueFrom.configMapKeyRef must set name and key", field)}
+		case source.SecretKeyRef != nil && (source.SecretKeyRef.Name == "" || source.SecretKeyRef.Key == ""):
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.valueFrom.secretKeyRef must set name and key", field)}
+		}
+	}
+
+	for key := range spec.Labels {
+		if key == "" || strings.Contains(key, "=") {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("spec.labels key %q is not a valid label", key)}
+		}
+	}
+
+	platforms := make(map[string]bool, len(spec.Platforms))
+	for i, platform := range spec.Platforms {
+		if !platformPattern.MatchString(platform) {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("spec.platforms[%d] %q is not a platform like linux/amd64", i, platform)}
+		}
+		if platforms[platform] {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("spec.platforms[%d] %q is given more than once", i, platform)}
+		}
+		platforms[platform] = true
+	}
+
+	ids := make(map[string]bool, len(spec.Secrets))
+	for i, secret := range spec.Secrets {
+		field := fmt.Sprintf("spec.secrets[%d]", i)
+		if !validBuildSecretID(secret.ID) {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.id %q is not a valid secret id", field, secret.ID)}
+		}
+		if ids[secret.ID] {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.id %q is given more than once", field, secret.ID)}
+		}
+		ids[secret.ID] = true
+		if secret.SecretKeyRef.Name == "" || secret.SecretKeyRef.Key == "" {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.secretKeyRef must set name and key", field)}
+		}
+	}
+	if ssh := spec.SSH; ssh != nil {
+		if ssh.SecretName == "" {
+			return &specError{ReasonInvalidSpec, "spec.ssh.secretName must be set"}
+		}
+		if ssh.ID != "" && !validBuildSecretID(ssh.ID) {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("spec.ssh.id %q is not a valid ssh id", ssh.ID)}
+		}
+	}
+	return nil
+}
+
+// validBuildSecretID tells whether id can name a build secret, it becomes a
+// file name inside the build container.
+func validBuildSecretID(id string) bool {
+	return buildSecretIDPattern.MatchString(id) && id != "." && id != ".."
+}
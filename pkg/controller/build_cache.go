@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	"builder/pkg/executor"
+	"builder/pkg/registry"
+)
+
+// defaultCacheMode exports the layers of every stage, multi-stage builds
+// reuse little with less.
+const defaultCacheMode = "max"
+
+// cacheKeyPattern matches cache keys, they are image tags and a single
+// directory in the cache volume.
+var cacheKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+
+// buildCache returns the cache the build of the Builder uses, spec.cache
+// completed with the controller defaults, nil when it uses none.
+func (c *Controller) buildCache(builder *builderv1.Builder) (*executor.Cache, error) {
+	spec := c.config.DefaultCache
+	if override := builder.Spec.Cache; override != nil {
+		if override.Type != "" {
+			spec.Type = override.Type
+		}
+		if override.Key != "" {
+			spec.Key = override.Key
+		}
+		if len(override.From) > 0 {
+			spec.From = override.From
+		}
+		if override.To != "" {
+			spec.To = override.To
+		}
+		if override.Mode != "" {
+			spec.Mode = override.Mode
+		}
+	}
+	if spec.Type == "" || spec.Type == executor.CacheNone {
+		return nil, nil
+	}
+
+	cache := &executor.Cache{
+		Type: spec.Type,
+		Key:  spec.Key,
+		From: append([]string(nil), spec.From...),
+		To:   spec.To,
+		Mode: spec.Mode,
+	}
+	if cache.Key == "" {
+		cache.Key = builder.Name
+	} else if !cacheKeyPattern.MatchString(cache.Key) {
+		return nil, fmt.Errorf("spec.cache.key %q must be a single path segment of letters, digits, _, . and -, like an image tag", cache.Key)
+	}
+	if cache.Mode == "" {
+		cache.Mode = defaultCacheMode
+	}
+	switch cache.Type {
+	case executor.CacheRegistry:
+		if cache.To == "" {
+			if c.config.CacheRepository == "" {
+				return nil, fmt.Errorf("spec.cache.to must be set, the controller has no cache repository")
+			}
+			cache.To = c.config.CacheRepository + ":" + cache.Key
+		}
+		if len(cache.From) == 0 {
+			cache.From = []string{cache.To}
+		}
+	case executor.CacheInline:
+		if len(cache.From) == 0 {
+			cache.From = []string{imageReference(builder.Spec.Image)}
+		}
+	case executor.CacheVolume:
+		if c.config.CacheClaim == "" {
+			return nil, fmt.Errorf("spec.cache.type %s needs a cache claim, the controller has none", cache.Type)
+		}
+	default:
+		return nil, fmt.Errorf("spec.cache.type %q is not one of Registry, Volume, Inline or None", cache.Type)
+	}
+
+	for _, ref := range append([]string{cache.To}, cache.From...) {
+		if ref == "" {
+			continue
+		}
+		if _, err := registry.ParseReference(ref); err != nil {
+			return nil, fmt.Errorf("cache image %q: %w", ref, err)
+		}
+	}
+	if builder.Spec.NoCache {
+		// nothing is reused, the cache is only refreshed
+		cache.From = nil
+	}
+	return cache, nil
+}
+
+// cacheStatus reports how the build used its cache, nil when the executor
+// can't tell.
+func cacheStatus(cache *executor.Cache, stats *executor.CacheStats) *builderv1.CacheStatus {
+	if stats == nil {
+		return nil
+	}
+	status := &builderv1.CacheStatus{
+		Type:   executor.CacheNone,
+		Hits:   int32(stats.Hits),
+		Misses: int32(stats.Misses),
+	}
+	if cache != nil {
+		status.Type = cache.Type
+	}
+	return status
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	imagev1 "builder/pkg/apis/image/v1"
+	"builder/pkg/executor"
+)
+
+func TestBuildCache(t *testing.T) {
+	image := imagev1.ImageSpec{ImageUrl: "registry.example.com/app", ImageTag: "v1"}
+
+	tests := []struct {
+		name     string
+		defaults builderv1.BuildCache
+		spec     *builderv1.BuildCache
+		noCache  bool
+		want     *executor.Cache
+		wantErr  bool
+	}{
+		{"no cache by default", builderv1.BuildCache{}, nil, false, nil, false},
+		{"none overrides the default", builderv1.BuildCache{Type: executor.CacheRegistry}, &builderv1.BuildCache{Type: executor.CacheNone}, false, nil, false},
+		{"registry cache in the cache repository", builderv1.BuildCache{Type: executor.CacheRegistry}, nil, false,
+			&executor.Cache{Type: executor.CacheRegistry, Key: "app", From: []string{"cache.example.com/cache:app"}, To: "cache.example.com/cache:app", Mode: "max"}, false},
+		{"registry cache with a key", builderv1.BuildCache{Type: executor.CacheRegistry, Mode: "min"}, &builderv1.BuildCache{Key: "shared"}, false,
+			&executor.Cache{Type: executor.CacheRegistry, Key: "shared", From: []string{"cache.example.com/cache:shared"}, To: "cache.example.com/cache:shared", Mode: "min"}, false},
+		{"registry cache with its own images", builderv1.BuildCache{}, &builderv1.BuildCache{Type: executor.CacheRegistry, From: []string{"registry.example.com/base:cache"}, To: "registry.example.com/app:cache"}, false,
+			&executor.Cache{Type: executor.CacheRegistry, Key: "app", From: []string{"registry.example.com/base:cache"}, To: "registry.example.com/app:cache", Mode: "max"}, false},
+		{"inline cache from the pushed image", builderv1.BuildCache{}, &builderv1.BuildCache{Type: executor.CacheInline}, false,
+			&executor.Cache{Type: executor.CacheInline, Key: "app", From: []string{"registry.example.com/app:v1"}, Mode: "max"}, false},
+		{"volume cache", builderv1.BuildCache{Type: executor.CacheVolume}, &builderv1.BuildCache{Key: "go-mod"}, false,
+			&executor.Cache{Type: executor.CacheVolume, Key: "go-mod", Mode: "max"}, false},
+		{"no cache only refreshes it", builderv1.BuildCache{Type: executor.CacheRegistry}, nil, true,
+			&executor.Cache{Type: executor.CacheRegistry, Key: "app", To: "cache.example.com/cache:app", Mode: "max"}, false},
+		{"unknown type", builderv1.BuildCache{}, &builderv1.BuildCache{Type: "Disk"}, false, nil, true},
+		{"invalid cache image", builderv1.BuildCache{}, &builderv1.BuildCache{Type: executor.CacheRegistry, To: "Registry.example.com/App"}, false, nil, true},
+		{"key climbing out of the cache volume", builderv1.BuildCache{Type: executor.CacheVolume}, &builderv1.BuildCache{Key: ".."}, false, nil, true},
+		{"key with a path", builderv1.BuildCache{Type: executor.CacheVolume}, &builderv1.BuildCache{Key: "other/cache"}, false, nil, true},
+		{"key of the defaults", builderv1.BuildCache{Type: executor.CacheVolume, Key: "../shared"}, nil, false, nil, true},
+		{"key too long for a tag", builderv1.BuildCache{Type: executor.CacheRegistry}, &builderv1.BuildCache{Key: strings.Repeat("a", 129)}, false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{config: Config{DefaultCache: tt.defaults, CacheRepository: "cache.example.com/cache", CacheClaim: "cache"}}
+			builder := newTestBuilder()
+			builder.Spec.Image = image
+			builder.Spec.Cache = tt.spec
+			builder.Spec.NoCache = tt.noCache
+
+			got, err := c.buildCache(builder)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildCache() = %+v, %v, want error %v", got, err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("buildCache() = %+v, want %+v", got, tt.want)
+			}
+			if got == nil {
+				return
+			}
+			if got.Type != tt.want.Type || got.Key != tt.want.Key || got.To != tt.want.To || got.Mode != tt.want.Mode ||
+				len(got.From) != len(tt.want.From) || (len(got.From) > 0 && got.From[0] != tt.want.From[0]) {
+				t.Errorf("buildCache() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// the Volume cache needs a claim and the Registry cache a repository
+	c := &Controller{config: Config{DefaultCache: builderv1.BuildCache{Type: executor.CacheVolume}}}
+	if _, err := c.buildCache(newTestBuilder()); err == nil {
+		t.Error("Volume cache without a cache claim is accepted")
+	}
+	c.config.DefaultCache.Type = executor.CacheRegistry
+	if _, err := c.buildCache(newTestBuilder()); err == nil {
+		t.Error("Registry cache without a cache repository is accepted")
+	}
+}
+
+func TestCacheStatus(t *testing.T) {
+	stats := &executor.CacheStats{Hits: 3, Misses: 1}
+	if status := cacheStatus(&executor.Cache{Type: executor.CacheVolume}, nil); status != nil {
+		t.Errorf("cacheStatus() = %+v without stats", status)
+	}
+	if status := cacheStatus(&executor.Cache{Type: executor.CacheVolume}, stats); status.Type != executor.CacheVolume || status.Hits != 3 || status.Misses != 1 {
+		t.Errorf("cacheStatus() = %+v", status)
+	}
+	if status := cacheStatus(nil, stats); status.Type != executor.CacheNone {
+		t.Errorf("cacheStatus() without a cache = %+v", status)
+	}
+}
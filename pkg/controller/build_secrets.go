@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+)
+
+// redacted replaces secret values in build logs copied by the controller.
+const redacted = "[redacted]"
+
+// secretKeyRefs returns the Secret keys the build of the Builder reads: its
+// build secrets, its SSH key and build arguments taken from Secrets.
+func secretKeyRefs(spec builderv1.BuilderSpec) []corev1.SecretKeySelector {
+	var refs []corev1.SecretKeySelector
+	for _, secret := range spec.Secrets {
+		refs = append(refs, secret.SecretKeyRef)
+	}
+	if spec.SSH != nil {
+		key := spec.SSH.Key
+		if key == "" {
+			key = corev1.SSHAuthPrivateKey
+		}
+		refs = append(refs, corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: spec.SSH.SecretName},
+			Key:                  key,
+		})
+	}
+	for _, arg := range spec.BuildArgs {
+		if arg.ValueFrom != nil && arg.ValueFrom.SecretKeyRef != nil {
+			refs = append(refs, *arg.ValueFrom.SecretKeyRef)
+		}
+	}
+	return refs
+}
+
+// base64WrapWidths are the line widths base64 tools wrap their output at, 64
+// for PEM and openssl and 76 for MIME and coreutils.
+var base64WrapWidths = []int{64, 76}
+
+// minDerivedLength is the shortest piece of an encoded secret value that is
+// redacted, shorter ones like trailing padding would hide unrelated output.
+const minDerivedLength = 8
+
+// secretRedactor returns a replacer hiding the values of the Secret keys the
+// build of the Builder reads, a build may print them by accident. Values are
+// redacted line by line since logs are, and so are their base64 encodings,
+// whole or wrapped across lines. Missing Secrets are skipped, the build
+// couldn't have read them either.
+func (c *Controller) secretRedactor(ctx context.Context, builder *builderv1.Builder) (*strings.Replacer, error) {
+	secrets := make(map[string]*corev1.Secret)
+	var values []string
+	for _, ref := range secretKeyRefs(builder.Spec) {
+		secret, ok := secrets[ref.Name]
+		if !ok {
+			var err error
+			secret, err = c.kubeclientset.CoreV1().Secrets(c.config.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to get secret %s: %w", ref.Name, err)
+			}
+			secrets[ref.Name] = secret
+		}
+		if secret == nil {
+			continue
+		}
+		value := secret.Data[ref.Key]
+		for _, line := range strings.Split(string(value), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				values = append(values, line)
+			}
+		}
+		if len(value) > 0 {
+			values = append(values, encodedSecretLines(value)...)
+		}
+	}
+
+	// the longest value wins where values overlap
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, redacted)
+	}
+	return strings.NewReplacer(pairs...), nil
+}
+
+// encodedSecretLines returns the lines a build printing the base64 encoding
+// of value may log.
+func encodedSecretLines(value []byte) []string {
+	var lines []string
+	seen := make(map[string]bool)
+	add := func(line string) {
+		if len(line) >= minDerivedLength && !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	trimmed := bytes.TrimSpace(value)
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		for _, v := range [][]byte{value, trimmed} {
+			encoded := encoding.EncodeToString(v)
+			add(encoded)
+			for _, width := range base64WrapWidths {
+				for i := 0; i < len(encoded) && len(encoded) > width; i += width {
+					add(encoded[i:min(i+width, len(encoded))])
+				}
+			}
+		}
+	}
+	return lines
+}
@@ -2,28 +2,45 @@ package controller
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	batchInformers "k8s.io/client-go/informers/batch/v1"
+	coreInformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	batchListers "k8s.io/client-go/listers/batch/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
 	builderv1 "builder/pkg/apis/builder/v1"
+	imagev1 "builder/pkg/apis/image/v1"
 	clientset "builder/pkg/client/generated/clientset/versioned"
 	samplescheme "builder/pkg/client/generated/clientset/versioned/scheme"
 	builderInformers "builder/pkg/client/generated/informers/externalversions/builder/v1"
 	imageInformers "builder/pkg/client/generated/informers/externalversions/image/v1"
+	"builder/pkg/downloader"
+	"builder/pkg/downloader/archive"
+	contextcache "builder/pkg/downloader/cache"
+	"builder/pkg/downloader/downloaderPlugin"
+	"builder/pkg/executor"
+	"builder/pkg/registry"
 
 	buildListers "builder/pkg/client/generated/listers/builder/v1"
 	imageListers "builder/pkg/client/generated/listers/image/v1"
@@ -53,10 +70,146 @@ const (
 	ImageSourceCreating = "Creating"
 	Finished            = "Finished"
 	Failed              = "Failed"
+
+	// ImageAvailable is the state of an Image created for a finished Builder.
+	ImageAvailable = "Available"
+
+	// OutputPushed and OutputFailed are the states of an output of a Builder.
+	OutputPushed = "Pushed"
+	OutputFailed = "Failed"
+
+	// ConditionSpecValid tells whether the spec of a Builder can be built.
+	ConditionSpecValid = "SpecValid"
 )
 
+const (
+	// ReasonStarted is used when a new Builder enters the Getting state
+	ReasonStarted = "Started"
+	// ReasonInvalidSpec is used when the Builder spec can't be built.
+	ReasonInvalidSpec = "InvalidSpec"
+	// ReasonDockerfileConflict is used when the spec names more than one
+	// Dockerfile
+	ReasonDockerfileConflict = "DockerfileConflict"
+	// ReasonInvalidDockerfile is used when the inline Dockerfile can't be used
+	ReasonInvalidDockerfile = "InvalidDockerfile"
+	// ReasonSpecAccepted is used when the Builder spec passed validation
+	ReasonSpecAccepted = "Accepted"
+	// ReasonContextReady is used when the build context has been downloaded
+	ReasonContextReady = "ContextReady"
+	// ReasonContextFailed is used when the build context can't be used
+	ReasonContextFailed = "ContextFailed"
+	// ReasonDigestMismatch is used when the build context doesn't match the
+	// digest from the spec
+	ReasonDigestMismatch = "DigestMismatch"
+	// ReasonSignatureInvalid is used when the signature of the build context
+	// can't be verified
+	ReasonSignatureInvalid = "SignatureInvalid"
+	// ReasonBuildStarted is used as part of the Event 'reason' when the build
+	// Job of a Builder is created
+	ReasonBuildStarted = "BuildStarted"
+	// ReasonBuildSucceeded is used when the build Job completed
+	ReasonBuildSucceeded = "BuildSucceeded"
+	// ReasonBuildFailed is used when the build Job failed
+	ReasonBuildFailed = "BuildFailed"
+	// ReasonPushSucceeded is used when the image reached the registry
+	ReasonPushSucceeded = "PushSucceeded"
+	// ReasonPushFailed is used when the image could not be pushed
+	ReasonPushFailed = "PushFailed"
+	// ReasonOutputPushed is used when the image reached one of spec.outputs
+	ReasonOutputPushed = "OutputPushed"
+	// ReasonOutputFailed is used when the image can't reach one of
+	// spec.outputs
+	ReasonOutputFailed = "OutputFailed"
+	// ReasonImageConflict is used when the Image resource of a Builder is
+	// controlled by another object
+	ReasonImageConflict = "ImageConflict"
+	// ReasonFinished is used when the Image resource of a Builder is created
+	ReasonFinished = "Finished"
+	// ReasonSyncFailed is used when a Builder is given up on after syncing it
+	// failed repeatedly
+	ReasonSyncFailed = "SyncFailed"
+	// ReasonTimeout is used when a Builder did not finish within its
+	// BuildTimeout
+	ReasonTimeout = "Timeout"
+
+	MessageStarted        = "Builder started"
+	MessageContextReady   = "Build context downloaded, %d bytes with digest %s"
+	MessageBuildStarted   = "Build started with executor %s"
+	MessageBuildSucceeded = "Build with executor %s succeeded"
+	MessagePushSucceeded  = "Pushed %s with digest %s"
+	MessageOutputPushed   = "Output %s pushed to %s"
+	MessageOutputFailed   = "Output %s failed: %s"
+	MessageOutputsFailed  = "Image pushed, but outputs %s failed"
+	MessageTimeout        = "Builder did not finish within %s, it was %s"
+	MessageFinished       = "Image %s created"
+	MessageImageConflict  = "Image %s is controlled by %s %s"
+	MessageImageUnowned   = "Image %s already exists and is not controlled by the Builder"
+)
+
+// Config holds the settings shared by every Builder the controller handles.
+type Config struct {
+	// Namespace is where build Jobs run and where the Secrets referenced by
+	// Builders are looked up, Builders themselves are cluster scoped.
+	Namespace string
+	// WorkspaceRoot is the directory holding one workspace per Builder, the
+	// build context is downloaded into it.
+	WorkspaceRoot string
+	// DownloadTimeout limits a single attempt to download a build context.
+	DownloadTimeout time.Duration
+	// DefaultBuildTimeout limits Builders without spec.buildTimeout, 0 means
+	// no limit.
+	DefaultBuildTimeout time.Duration
+	// ContextLimits restricts what a build context archive may expand to.
+	ContextLimits archive.Limits
+	// DefaultExecutor builds the images of Builders that don't choose an
+	// executor.
+	DefaultExecutor string
+	// EnableFakeExecutor offers the fake executor, which builds nothing and
+	// only suits test clusters.
+	EnableFakeExecutor bool
+	// KanikoImage is the image running the build inside the build Job of the
+	// kaniko executor.
+	KanikoImage string
+	// BuildKitImage is the image running buildctl inside the build Job of the
+	// buildkit executor.
+	BuildKitImage string
+	// BuildKitAddress is where the buildkit executor reaches buildkitd.
+	BuildKitAddress string
+	// ContextCache stores downloaded build contexts for reuse, nil disables
+	// caching.
+	ContextCache *contextcache.Cache
+	// ImageVerifyInterval is how often Image resources are looked up in the
+	// registry again.
+	ImageVerifyInterval time.Duration
+	// InsecureRegistries are registries reached without TLS verification,
+	// over plain http if they don't speak https.
+	InsecureRegistries []string
+	// RegistryTimeout limits a single request to a registry.
+	RegistryTimeout time.Duration
+	// RegistryRetries is how often a failed request to a registry is retried.
+	RegistryRetries int
+	// RegistryCAs verify the certificates of registries, the system pool is
+	// used when nil.
+	RegistryCAs *x509.CertPool
+	// WorkspaceClaim is the PersistentVolumeClaim in Namespace WorkspaceRoot
+	// is stored on. Build Jobs mount the prepared context from it, without it
+	// they fetch the remote context themselves.
+	WorkspaceClaim string
+	// DefaultCache supplies the cache settings Builders leave empty, its
+	// Type None disables caching unless Builders ask for it.
+	DefaultCache builderv1.BuildCache
+	// CacheRepository is where Registry caches are exported to by default,
+	// each tagged with its key.
+	CacheRepository string
+	// CacheClaim is the PersistentVolumeClaim in Namespace holding Volume
+	// caches, one directory per key.
+	CacheClaim string
+}
+
 // Controller is the controller implementation for Foo resources
 type Controller struct {
+	config Config
+
 	// kubeclientset is a standard kubernetes clientset
 	kubeclientset kubernetes.Interface
 	// sampleclientset is a clientset for our own API group
@@ -66,6 +219,16 @@ type Controller struct {
 	imageSynced   cache.InformerSynced
 	builderLister buildListers.BuilderLister
 	builderSynced cache.InformerSynced
+	jobLister     batchListers.JobLister
+	jobSynced     cache.InformerSynced
+	podSynced     cache.InformerSynced
+
+	// executors build images, one per registered executor type
+	executors map[executor.ExecutorType]executor.BuildExecutor
+
+	// syncCancel stops the running sync of a Builder, see trackSync
+	syncMu     sync.Mutex
+	syncCancel map[string]context.CancelFunc
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
@@ -81,10 +244,13 @@ type Controller struct {
 // NewController returns a new sample controller
 func NewController(
 	ctx context.Context,
+	config Config,
 	kubeclientset kubernetes.Interface,
 	sampleclientset clientset.Interface,
 	ImageInformer imageInformers.ImageInformer,
-	BuilderInformer builderInformers.BuilderInformer) *Controller {
+	BuilderInformer builderInformers.BuilderInformer,
+	jobInformer batchInformers.JobInformer,
+	podInformer coreInformers.PodInformer) *Controller {
 	logger := klog.FromContext(ctx)
 
 	// Create event broadcaster
@@ -103,40 +269,68 @@ func NewController(
 	)
 
 	controller := &Controller{
+		config:        config,
 		kubeclientset: kubeclientset,
 		client:        sampleclientset,
 		builderLister: BuilderInformer.Lister(),
 		builderSynced: BuilderInformer.Informer().HasSynced,
 		imageList:     ImageInformer.Lister(),
 		imageSynced:   ImageInformer.Informer().HasSynced,
+		jobLister:     jobInformer.Lister(),
+		jobSynced:     jobInformer.Informer().HasSynced,
+		podSynced:     podInformer.Informer().HasSynced,
+		syncCancel:    make(map[string]context.CancelFunc),
 		workqueue:     workqueue.NewTypedRateLimitingQueue(ratelimiter),
 		recorder:      recorder,
 	}
 
+	controller.executors = executor.NewExecutors(executor.Env{
+		KubeClient:      kubeclientset,
+		JobLister:       jobInformer.Lister(),
+		PodLister:       podInformer.Lister(),
+		Namespace:       config.Namespace,
+		WorkspaceClaim:  config.WorkspaceClaim,
+		WorkspaceRoot:   config.WorkspaceRoot,
+		CacheClaim:      config.CacheClaim,
+		KanikoImage:     config.KanikoImage,
+		BuildKitImage:   config.BuildKitImage,
+		BuildKitAddress: config.BuildKitAddress,
+		Notify: func(name string) {
+			controller.workqueue.Add(cache.ObjectName{Name: name})
+		},
+		EnableFake: config.EnableFakeExecutor,
+	})
+
 	logger.Info("Setting up event handlers")
 	// Set up an event handler for when Foo resources change
 	BuilderInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.enqueueFoo,
 		UpdateFunc: func(old, new interface{}) {
+			if new.(*builderv1.Builder).DeletionTimestamp != nil {
+				// nothing the running sync does is of use anymore
+				controller.cancelSync(new.(*builderv1.Builder).Name)
+			}
 			controller.enqueueFoo(new)
 		},
 		DeleteFunc: controller.enqueueFoo,
 	})
 
-	//deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-	//	AddFunc: controller.handleObject,
-	//	UpdateFunc: func(old, new interface{}) {
-	//		newDepl := new.(*appsv1.Deployment)
-	//		oldDepl := old.(*appsv1.Deployment)
-	//		if newDepl.ResourceVersion == oldDepl.ResourceVersion {
-	//			// Periodic resync will send update events for all known Deployments.
-	//			// Two different versions of the same Deployment will always have different RVs.
-	//			return
-	//		}
-	//		controller.handleObject(new)
-	//	},
-	//	DeleteFunc: controller.handleObject,
-	//})
+	// Set up event handlers for the build Jobs and their Pods, so the Builder
+	// owning them moves on as soon as the build finished
+	for _, informer := range []cache.SharedIndexInformer{jobInformer.Informer(), podInformer.Informer()} {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: controller.handleObject,
+			UpdateFunc: func(old, new interface{}) {
+				if new.(metav1.Object).GetResourceVersion() == old.(metav1.Object).GetResourceVersion() {
+					// Periodic resync will send update events for all known objects.
+					// Two different versions of the same object will always have different RVs.
+					return
+				}
+				controller.handleObject(new)
+			},
+			DeleteFunc: controller.handleObject,
+		})
+	}
 
 	return controller
 }
@@ -152,7 +346,7 @@ func (c *Controller) Run(ctx context.Context, workers int) error {
 	// Wait for the caches to be synced before starting workers
 	logger.Info("Waiting for informer caches to sync")
 
-	if ok := cache.WaitForCacheSync(ctx.Done(), c.builderSynced, c.imageSynced); !ok {
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.builderSynced, c.imageSynced, c.jobSynced, c.podSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -216,9 +410,12 @@ func (c *Controller) processNextWorkItem(ctx context.Context) bool {
 	}
 
 	utilruntime.HandleErrorWithContext(ctx, err, "Error syncing; dropping", "objectReference", objRef)
-	get, _ := c.builderLister.Get(objRef.Name)
-	get.Status.State = Failed
-	c.client.BuilderV1().Builders().UpdateStatus(ctx, get, metav1.UpdateOptions{})
+	// the Builder may have been deleted meanwhile
+	if builder, getErr := c.builderLister.Get(objRef.Name); getErr == nil {
+		if failErr := c.failBuilder(ctx, builder, ReasonSyncFailed, err.Error()); failErr != nil {
+			utilruntime.HandleErrorWithContext(ctx, failErr, "Error failing builder", "objectReference", objRef)
+		}
+	}
 	c.workqueue.Forget(objRef)
 	return true
 }
@@ -230,93 +427,381 @@ func (c *Controller) syncHandler(ctx context.Context, obj cache.ObjectName) erro
 	logger := klog.LoggerWithValues(klog.FromContext(ctx), "objectRef", obj)
 
 	builder, err := c.client.BuilderV1().Builders().Get(ctx, obj.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		// the finalizer made sure everything was cleaned up before
+		logger.V(4).Info("builder is gone", "builder", obj.Name)
+		return nil
+	}
 	if err != nil {
+		return err
+	}
+
+	if builder.DeletionTimestamp != nil {
 		logger.Info("start delete builder", "builder", obj.Name)
-		return c.handlerDeleteBuilder(ctx, obj.Name)
+		return c.handlerDeleteBuilder(ctx, builder, logger)
+	}
+	if !hasFinalizer(builder) {
+		// the update brings the Builder back to the workqueue
+		return c.addFinalizer(ctx, builder)
 	}
 
 	logger.Info("start sync builder", "builder", obj.Name)
 
+	if builder.Status.State == Finished || builder.Status.State == Failed {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer c.trackSync(builder.Name, cancel)()
+
+	// every phase runs with the deadline of the Builder, so downloads stop and
+	// build pods are limited once it passed
+	parent := ctx
+	if deadline, ok := c.buildDeadline(builder); ok {
+		if !time.Now().Before(deadline) {
+			return c.timeoutBuilder(ctx, builder, logger)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+		c.enqueueAfter(builder, time.Until(deadline))
+	}
+
 	switch builder.Status.State {
 	case ContextGetting:
 		err = c.handlerContextGetting(ctx, builder, logger)
-	//case ImageBuilding:
-	//	err = handerImageBuilding(ctx, c.client, builder)
-	//case ImagePushing:
-	//	err = handerImagePushing(ctx, c.client, builder)
-	//case ImageSourceCreating:
-	//	err = handerImageSourceCreating(ctx, c.client, builder)
-	//case Finished:
-	//	err = handerFinished(ctx, c.client, builder)
-	case Failed:
-		return nil
+	case ImageBuilding:
+		err = c.handlerImageBuilding(ctx, builder, logger)
+	case ImagePushing:
+		err = c.handlerImagePushing(ctx, builder, logger)
+	case ImageSourceCreating:
+		err = c.handlerImageSourceCreating(ctx, builder, logger)
 	default:
-		err = c.handlerContextGetting(ctx, builder, logger)
+		err = c.startBuilder(ctx, builder)
 	}
 
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return c.timeoutBuilder(parent, builder, logger)
+	}
 	return err
 }
 
+// handlerContextGetting makes sure everything the build needs is available
+// and hands the Builder over to the build phase.
 func (c *Controller) handlerContextGetting(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) error {
-	err := c.updateBuilderStatus(ctx, builder, ContextGetting)
+	dockerfile, invalid := validateSpec(builder.Spec)
+	if invalid == nil {
+		if _, err := c.executorFor(builder); err != nil {
+			invalid = &specError{ReasonInvalidSpec, err.Error()}
+		} else {
+			invalid = c.validateContextSource(builder.Spec)
+		}
+	}
+	if invalid != nil {
+		c.recorder.Event(builder, corev1.EventTypeWarning, invalid.reason, invalid.message)
+		_, err := c.patchStatus(ctx, builder, func(builder *builderv1.Builder) {
+			meta.SetStatusCondition(&builder.Status.Conditions, metav1.Condition{
+				Type:               ConditionSpecValid,
+				Status:             metav1.ConditionFalse,
+				ObservedGeneration: builder.Generation,
+				Reason:             invalid.reason,
+				Message:            invalid.message,
+			})
+			setState(builder, Failed, invalid.reason, invalid.message)
+		})
+		return err
+	}
+	workspace, err := c.prepareWorkspace(builder)
 	if err != nil {
-		logger.Error(err, "update builder status failed")
 		return err
 	}
-	// get downloader
 
-	return nil
+	destination := filepath.Join(workspace, executor.ContextDirName)
+	var result *downloaderPlugin.Result
+	if builder.Spec.RemoteContext.ContentUrl != "" {
+		result, err = c.fetchContext(ctx, builder, destination, logger)
+		if err != nil || result == nil {
+			return err
+		}
+	} else if err := os.MkdirAll(destination, 0o755); err != nil {
+		return err
+	}
+
+	if dockerfile != nil {
+		if err := writeDockerfile(destination, dockerfile); err != nil {
+			return err
+		}
+		if result == nil {
+			// the context consists of nothing but the Dockerfile
+			size, digest, err := downloaderPlugin.DigestPath(destination)
+			if err != nil {
+				return err
+			}
+			result = &downloaderPlugin.Result{Size: size, Digest: digest}
+		}
+	}
+
+	logger.Info("build context is ready", "builder", builder.Name, "path", destination, "size", result.Size, "digest", result.Digest, "revision", result.Revision)
+	message := fmt.Sprintf(MessageContextReady, result.Size, result.Digest)
+	c.recorder.Event(builder, corev1.EventTypeNormal, ReasonContextReady, message)
+	_, err = c.patchStatus(ctx, builder, func(builder *builderv1.Builder) {
+		setState(builder, ImageBuilding, ReasonContextReady, message)
+		builder.Status.Progress = ""
+		builder.Status.Context = &builderv1.ContextStatus{
+			Path:     destination,
+			Size:     result.Size,
+			Digest:   result.Digest,
+			Revision: result.Revision,
+		}
+		meta.SetStatusCondition(&builder.Status.Conditions, metav1.Condition{
+			Type:               ConditionSpecValid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: builder.Generation,
+			Reason:             ReasonSpecAccepted,
+		})
+	})
+	return err
 }
 
-func (c *Controller) handlerDeleteBuilder(ctx context.Context, name string) error {
+// fetchContext downloads the remote context of the Builder to destination.
+// A nil result without error means the Builder has been failed because the
+// download can't succeed.
+func (c *Controller) fetchContext(ctx context.Context, builder *builderv1.Builder, destination string, logger klog.Logger) (*downloaderPlugin.Result, error) {
+	auth, err := c.resolveAuth(ctx, builder.Spec.RemoteContext.AuthConfigMap)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := c.resolveSignature(ctx, builder.Spec.RemoteContext)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	logger.Info("downloading build context", "builder", builder.Name, "url", builder.Spec.RemoteContext.ContentUrl)
+	result, err := downloader.Fetch(ctx, downloader.Request{
+		URL:         builder.Spec.RemoteContext.ContentUrl,
+		Type:        builder.Spec.RemoteContext.Type,
+		Destination: destination,
+		Options: downloaderPlugin.Options{
+			Auth:     auth,
+			Timeout:  c.config.DownloadTimeout,
+			Progress: c.downloadProgress(ctx, builder, logger),
+		},
+		Limits:    c.config.ContextLimits,
+		Digests:   expectedDigests(builder.Spec.RemoteContext),
+		Signature: sig,
+		Cache:     c.config.ContextCache,
+	})
+	if err != nil {
+		if reason, permanent := downloadFailureReason(err); permanent {
+			return nil, c.failBuilder(ctx, builder, reason, err.Error())
+		}
+		return nil, fmt.Errorf("failed to download build context: %w", err)
+	}
+	return result, nil
+}
+
+// handlerImageBuilding starts the build of the Builder with its executor and
+// waits for it to finish. The Builder moves on to ImagePushing once the build
+// succeeded.
+func (c *Controller) handlerImageBuilding(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) error {
+	exec, build, err := c.newBuild(builder)
+	if err != nil {
+		return c.failBuilder(ctx, builder, ReasonInvalidSpec, err.Error())
+	}
+	status, err := exec.Status(ctx, build)
+	if err != nil {
+		return c.buildError(ctx, builder, err)
+	}
+
+	switch status.Phase {
+	case executor.NotStarted:
+		if err := exec.Start(ctx, build); err != nil {
+			return c.buildError(ctx, builder, err)
+		}
+		logger.Info("build started", "builder", builder.Name, "executor", exec.GetType())
+		c.recorder.Event(builder, corev1.EventTypeNormal, ReasonBuildStarted, fmt.Sprintf(MessageBuildStarted, exec.GetType()))
+		return nil
+	case executor.Succeeded:
+		message := fmt.Sprintf(MessageBuildSucceeded, exec.GetType())
+		c.recorder.Event(builder, corev1.EventTypeNormal, ReasonBuildSucceeded, message)
+		_, err = c.patchStatus(ctx, builder, func(builder *builderv1.Builder) {
+			setState(builder, ImagePushing, ReasonBuildSucceeded, message)
+			builder.Status.ImageDigest = status.Digest
+			builder.Status.Cache = cacheStatus(build.Cache, status.Cache)
+		})
+		return err
+	case executor.Failed:
+		// the message usually ends with the build log, it may print secrets too
+		redactor, err := c.secretRedactor(ctx, builder)
+		if err != nil {
+			return err
+		}
+		c.logBuildTail(ctx, exec, build, redactor, logger)
+		return c.failBuilder(ctx, builder, ReasonBuildFailed, redactor.Replace(status.Message))
+	default:
+		logger.V(4).Info("build is still running", "builder", builder.Name, "executor", exec.GetType())
+		return nil
+	}
 }
 
-func (c *Controller) updateBuilderStatus(ctx context.Context, builder *builderv1.Builder, status string) error {
-	deepCopy := builder.DeepCopy()
-	deepCopy.Status.State = status
-	_, err := c.client.BuilderV1().Builders().UpdateStatus(ctx, builder, metav1.UpdateOptions{})
+// handlerImagePushing pushes the image the executor exported into the
+// workspace, then to each of spec.outputs. Without a workspace claim the
+// executor pushed it already and reported the digest of the pushed manifest
+// along with its status.
+func (c *Controller) handlerImagePushing(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) error {
+	digest := builder.Status.ImageDigest
+	if c.exportsImage() {
+		pushed, err := c.pushImage(ctx, builder, logger)
+		if err != nil {
+			if registry.IsPermanent(err) {
+				return c.failBuilder(ctx, builder, ReasonPushFailed, err.Error())
+			}
+			return fmt.Errorf("failed to push image: %w", err)
+		}
+		digest = pushed
+	}
+	if digest == "" {
+		return c.failBuilder(ctx, builder, ReasonPushFailed, "the executor did not report the digest of the pushed image")
+	}
+	logger.Info("image pushed", "builder", builder.Name, "digest", digest)
+
+	var outputs []builderv1.OutputStatus
+	if len(builder.Spec.Outputs) > 0 {
+		var err error
+		outputs, err = c.pushOutputs(ctx, builder, logger)
+		if err != nil {
+			// keep the outputs pushed so far, the retry skips them
+			if _, patchErr := c.patchStatus(ctx, builder, func(builder *builderv1.Builder) {
+				builder.Status.Outputs = outputs
+			}); patchErr != nil {
+				return patchErr
+			}
+			return fmt.Errorf("failed to push outputs: %w", err)
+		}
+		if failed := failedOutputs(outputs); len(failed) > 0 {
+			if _, err := c.patchStatus(ctx, builder, func(builder *builderv1.Builder) {
+				builder.Status.ImageDigest = digest
+				builder.Status.Outputs = outputs
+			}); err != nil {
+				return err
+			}
+			return c.failBuilder(ctx, builder, ReasonOutputFailed, fmt.Sprintf(MessageOutputsFailed, strings.Join(failed, ", ")))
+		}
+	}
+
+	message := fmt.Sprintf(MessagePushSucceeded, imageReference(builder.Spec.Image), digest)
+	c.recorder.Event(builder, corev1.EventTypeNormal, ReasonPushSucceeded, message)
+	_, err := c.patchStatus(ctx, builder, func(builder *builderv1.Builder) {
+		setState(builder, ImageSourceCreating, ReasonPushSucceeded, message)
+		builder.Status.ImageDigest = digest
+		if outputs != nil {
+			builder.Status.Outputs = outputs
+		}
+	})
 	return err
 }
 
-//func (c *Controller) handleObject(obj interface{}) {
-//	var object metav1.Object
-//	var ok bool
-//	logger := klog.FromContext(context.Background())
-//	if object, ok = obj.(metav1.Object); !ok {
-//		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
-//		if !ok {
-//			// If the object value is not too big and does not contain sensitive information then
-//			// it may be useful to include it.
-//			utilruntime.HandleErrorWithContext(context.Background(), nil, "Error decoding object, invalid type", "type", fmt.Sprintf("%T", obj))
-//			return
-//		}
-//		object, ok = tombstone.Obj.(metav1.Object)
-//		if !ok {
-//			// If the object value is not too big and does not contain sensitive information then
-//			// it may be useful to include it.
-//			utilruntime.HandleErrorWithContext(context.Background(), nil, "Error decoding object tombstone, invalid type", "type", fmt.Sprintf("%T", tombstone.Obj))
-//			return
-//		}
-//		logger.V(4).Info("Recovered deleted object", "resourceName", object.GetName())
-//	}
-//	logger.V(4).Info("Processing object", "object", klog.KObj(object))
-//	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
-//		// If this object is not owned by a Foo, we should not do anything more
-//		// with it.
-//		if ownerRef.Kind != "Foo" {
-//			return
-//		}
-//
-//		foo, err := c.foosLister.Foos(object.GetNamespace()).Get(ownerRef.Name)
-//		if err != nil {
-//			logger.V(4).Info("Ignore orphaned object", "object", klog.KObj(object), "foo", ownerRef.Name)
-//			return
-//		}
-//
-//		c.enqueueFoo(foo)
-//		return
-//	}
-//}
-//
+// handlerImageSourceCreating publishes the pushed image as an Image resource
+// owned by the Builder and finishes the Builder.
+func (c *Controller) handlerImageSourceCreating(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) error {
+	image, inspected, err := c.ensureImage(ctx, builder, logger)
+	if _, conflict := err.(*errImageConflict); conflict {
+		return c.failBuilder(ctx, builder, ReasonImageConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf(MessageFinished, image.Name)
+	c.recorder.Event(builder, corev1.EventTypeNormal, ReasonFinished, message)
+	_, err = c.patchStatus(ctx, builder, func(builder *builderv1.Builder) {
+		setState(builder, Finished, ReasonFinished, message)
+		builder.Status.Image = &builderv1.ImageReference{
+			Name:     image.Name,
+			PullPath: image.Status.ImagePullPath,
+		}
+		if inspected != nil {
+			builder.Status.Platforms = platformImages(inspected)
+		}
+	})
+	return err
+}
+
+// updateBuilderStatus moves the Builder to state, see setState.
+func (c *Controller) updateBuilderStatus(ctx context.Context, builder *builderv1.Builder, state, reason, message string) error {
+	_, err := c.patchStatus(ctx, builder, func(builder *builderv1.Builder) {
+		setState(builder, state, reason, message)
+	})
+	return err
+}
+
+// failBuilder moves the Builder to Failed and records why.
+func (c *Controller) failBuilder(ctx context.Context, builder *builderv1.Builder, reason, message string) error {
+	c.recorder.Event(builder, corev1.EventTypeWarning, reason, message)
+	_, err := c.patchStatus(ctx, builder, func(builder *builderv1.Builder) {
+		setState(builder, Failed, reason, message)
+		builder.Status.Progress = ""
+	})
+	return err
+}
+
+// enqueueAfter puts the Builder back on the workqueue once duration passed.
+func (c *Controller) enqueueAfter(builder *builderv1.Builder, duration time.Duration) {
+	c.workqueue.AddAfter(cache.ObjectName{Name: builder.Name}, duration)
+}
+
+// imageReference returns the tagged reference the image is pushed to.
+func imageReference(spec imagev1.ImageSpec) string {
+	if spec.ImageTag == "" {
+		return spec.ImageUrl
+	}
+	return spec.ImageUrl + ":" + spec.ImageTag
+}
+
+// handleObject enqueues the Builder owning a build Job, or owning the Job of a
+// build Pod, whenever the object changes.
+func (c *Controller) handleObject(obj interface{}) {
+	var object metav1.Object
+	var ok bool
+	logger := klog.FromContext(context.Background())
+	if object, ok = obj.(metav1.Object); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			// If the object value is not too big and does not contain sensitive information then
+			// it may be useful to include it.
+			utilruntime.HandleErrorWithContext(context.Background(), nil, "Error decoding object, invalid type", "type", fmt.Sprintf("%T", obj))
+			return
+		}
+		object, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			// If the object value is not too big and does not contain sensitive information then
+			// it may be useful to include it.
+			utilruntime.HandleErrorWithContext(context.Background(), nil, "Error decoding object tombstone, invalid type", "type", fmt.Sprintf("%T", tombstone.Obj))
+			return
+		}
+		logger.V(4).Info("Recovered deleted object", "resourceName", object.GetName())
+	}
+	logger.V(4).Info("Processing object", "object", klog.KObj(object))
+	ownerRef := metav1.GetControllerOf(object)
+	if ownerRef != nil && ownerRef.Kind == "Job" {
+		// Pods are owned by the build Job, which is owned by the Builder
+		job, err := c.jobLister.Jobs(object.GetNamespace()).Get(ownerRef.Name)
+		if err != nil {
+			logger.V(4).Info("Ignore orphaned object", "object", klog.KObj(object), "job", ownerRef.Name)
+			return
+		}
+		ownerRef = metav1.GetControllerOf(job)
+	}
+	// If this object is not owned by a Builder, we should not do anything more
+	// with it.
+	if ownerRef == nil || ownerRef.Kind != "Builder" {
+		return
+	}
+
+	builder, err := c.builderLister.Get(ownerRef.Name)
+	if err != nil {
+		logger.V(4).Info("Ignore orphaned object", "object", klog.KObj(object), "builder", ownerRef.Name)
+		return
+	}
+	c.enqueueFoo(builder)
+}
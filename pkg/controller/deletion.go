@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	"builder/pkg/executor"
+)
+
+const (
+	// BuilderFinalizer keeps a deleted Builder around until the controller
+	// cleaned up after it.
+	BuilderFinalizer = "builder.hjjzs.xyz/cleanup"
+
+	// DeletionPolicyDelete deletes the Image of a Builder along with it.
+	DeletionPolicyDelete = "Delete"
+	// DeletionPolicyOrphan keeps the Image of a deleted Builder.
+	DeletionPolicyOrphan = "Orphan"
+)
+
+// hasFinalizer tells whether the Builder carries BuilderFinalizer.
+func hasFinalizer(builder *builderv1.Builder) bool {
+	for _, finalizer := range builder.Finalizers {
+		if finalizer == BuilderFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// addFinalizer adds BuilderFinalizer to the Builder, so it is only removed
+// once handlerDeleteBuilder is done.
+func (c *Controller) addFinalizer(ctx context.Context, builder *builderv1.Builder) error {
+	deepCopy := builder.DeepCopy()
+	deepCopy.Finalizers = append(deepCopy.Finalizers, BuilderFinalizer)
+	_, err := c.client.BuilderV1().Builders().Update(ctx, deepCopy, metav1.UpdateOptions{})
+	return err
+}
+
+// removeFinalizer removes BuilderFinalizer, which lets the API server delete
+// the Builder.
+func (c *Controller) removeFinalizer(ctx context.Context, builder *builderv1.Builder) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := c.client.BuilderV1().Builders().Get(ctx, builder.Name, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		finalizers := make([]string, 0, len(latest.Finalizers))
+		for _, finalizer := range latest.Finalizers {
+			if finalizer != BuilderFinalizer {
+				finalizers = append(finalizers, finalizer)
+			}
+		}
+		if len(finalizers) == len(latest.Finalizers) {
+			return nil
+		}
+		latest.Finalizers = finalizers
+		_, err = c.client.BuilderV1().Builders().Update(ctx, latest, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// handlerDeleteBuilder cleans up after a deleted Builder: it stops its build,
+// removes its workspace, deletes or orphans its Image according to
+// spec.deletionPolicy and finally releases the Builder. A running download
+// was already cancelled when the deletion was observed.
+func (c *Controller) handlerDeleteBuilder(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) error {
+	if !hasFinalizer(builder) {
+		return nil
+	}
+	logger.Info("cleaning up deleted builder", "builder", builder.Name)
+
+	if exec, err := c.executorFor(builder); err == nil {
+		if err := exec.Cancel(ctx, &executor.Build{Builder: builder}); err != nil {
+			return fmt.Errorf("failed to cancel build: %w", err)
+		}
+	}
+	if err := os.RemoveAll(c.workspaceDir(builder)); err != nil {
+		return fmt.Errorf("failed to remove workspace: %w", err)
+	}
+	if err := c.releaseImage(ctx, builder, logger); err != nil {
+		return err
+	}
+	return c.removeFinalizer(ctx, builder)
+}
+
+// releaseImage deletes the Image created for the Builder, or with the Orphan
+// policy drops the reference of the Image to the Builder so the garbage
+// collector keeps it.
+func (c *Controller) releaseImage(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) error {
+	if builder.Status.Image == nil {
+		return nil
+	}
+	name := builder.Status.Image.Name
+
+	if builder.Spec.DeletionPolicy == DeletionPolicyOrphan {
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			image, err := c.client.ImageV1().Images().Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				if errors.IsNotFound(err) {
+					return nil
+				}
+				return err
+			}
+			owners := make([]metav1.OwnerReference, 0, len(image.OwnerReferences))
+			for _, owner := range image.OwnerReferences {
+				if owner.UID != builder.UID {
+					owners = append(owners, owner)
+				}
+			}
+			if len(owners) == len(image.OwnerReferences) {
+				return nil
+			}
+			image.OwnerReferences = owners
+			_, err = c.client.ImageV1().Images().Update(ctx, image, metav1.UpdateOptions{})
+			if err == nil {
+				logger.Info("orphaned image", "builder", builder.Name, "image", name)
+			}
+			return err
+		})
+	}
+
+	image, err := c.client.ImageV1().Images().Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// only an Image the Builder created is deleted along with it
+	if owner := metav1.GetControllerOf(image); owner == nil || owner.UID != builder.UID {
+		logger.Info("image is not controlled by the builder, keeping it", "builder", builder.Name, "image", name)
+		return nil
+	}
+	err = c.client.ImageV1().Images().Delete(ctx, name, metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(image.UID))})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete image %s: %w", name, err)
+	}
+	logger.Info("deleted image", "builder", builder.Name, "image", name)
+	return nil
+}
+
+// trackSync registers cancel as the way to stop the running sync of the
+// Builder, the returned func unregisters it.
+func (c *Controller) trackSync(name string, cancel context.CancelFunc) func() {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	c.syncCancel[name] = cancel
+	return func() {
+		c.syncMu.Lock()
+		defer c.syncMu.Unlock()
+		delete(c.syncCancel, name)
+	}
+}
+
+// cancelSync stops the running sync of the Builder, e.g. a download that is
+// no longer needed because the Builder is being deleted.
+func (c *Controller) cancelSync(name string) {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	if cancel, ok := c.syncCancel[name]; ok {
+		cancel()
+	}
+}
@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	imagev1 "builder/pkg/apis/image/v1"
+	"builder/pkg/client/generated/clientset/versioned/fake"
+	"builder/pkg/executor"
+)
+
+// newDeletedBuilder returns a finished Builder with its Image that is being
+// deleted with policy.
+func newDeletedBuilder(policy string) *builderv1.Builder {
+	builder := newTestBuilder()
+	builder.UID = types.UID("builder-uid")
+	builder.Finalizers = []string{"example.com/other", BuilderFinalizer}
+	now := metav1.Now()
+	builder.DeletionTimestamp = &now
+	builder.Spec.Executor = "running"
+	builder.Spec.DeletionPolicy = policy
+	builder.Spec.Image = imagev1.ImageSpec{ImageUrl: "registry.example.com/app", ImageTag: "v1"}
+	builder.Status.State = Finished
+	builder.Status.Image = &builderv1.ImageReference{Name: builder.Name}
+	return builder
+}
+
+// newDeletionController returns a controller cleaning up after Builders with
+// workspaces below a temporary directory.
+func newDeletionController(t *testing.T, exec *runningExecutor, objects ...runtime.Object) (*Controller, *fake.Clientset) {
+	t.Helper()
+	client := fake.NewSimpleClientset(objects...)
+	return &Controller{
+		client:     client,
+		recorder:   record.NewFakeRecorder(10),
+		config:     Config{WorkspaceRoot: t.TempDir()},
+		executors:  map[executor.ExecutorType]executor.BuildExecutor{"running": exec},
+		syncCancel: make(map[string]context.CancelFunc),
+	}, client
+}
+
+// finalizers returns the finalizers of the stored Builder, nil once it is gone.
+func finalizers(t *testing.T, client *fake.Clientset, name string) []string {
+	t.Helper()
+	stored, err := client.BuilderV1().Builders().Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored.Finalizers
+}
+
+func TestSyncHandlerAddsFinalizer(t *testing.T) {
+	builder := newTestBuilder()
+	builder.Finalizers = []string{"example.com/other"}
+	c, client := newDeletionController(t, &runningExecutor{}, builder)
+
+	if err := c.syncHandler(context.Background(), cache.ObjectName{Name: builder.Name}); err != nil {
+		t.Fatal(err)
+	}
+	if got := finalizers(t, client, builder.Name); len(got) != 2 || got[0] != "example.com/other" || got[1] != BuilderFinalizer {
+		t.Errorf("finalizers %v, want the other one and %s", got, BuilderFinalizer)
+	}
+	// the Builder is only started once the update brings it back
+	if patches := statusPatches(client); len(patches) != 0 {
+		t.Errorf("status was patched before the finalizer was in place: %v", patches)
+	}
+}
+
+func TestDeleteBuilderReleasesImage(t *testing.T) {
+	builder := newDeletedBuilder("")
+	owned := imageFor(builder)
+	owned.UID = "image-uid"
+	shared := owned.DeepCopy()
+	shared.OwnerReferences = append(shared.OwnerReferences, metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "other", UID: "other-uid"})
+	unowned := owned.DeepCopy()
+	unowned.OwnerReferences = nil
+
+	tests := []struct {
+		name   string
+		policy string
+		image  *imagev1.Image
+		// owners of the Image left behind, -1 when it is deleted
+		owners int
+	}{
+		{"default policy deletes", "", owned, -1},
+		{"delete policy deletes", DeletionPolicyDelete, owned, -1},
+		{"delete policy keeps an image the builder doesn't control", DeletionPolicyDelete, unowned, 0},
+		{"delete policy with the image already gone", DeletionPolicyDelete, nil, -1},
+		{"orphan policy drops the owner reference", DeletionPolicyOrphan, owned, 0},
+		{"orphan policy keeps other owners", DeletionPolicyOrphan, shared, 1},
+		{"orphan policy with the image already gone", DeletionPolicyOrphan, nil, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := newDeletedBuilder(tt.policy)
+			objects := []runtime.Object{builder}
+			if tt.image != nil {
+				objects = append(objects, tt.image.DeepCopy())
+			}
+			exec := &runningExecutor{}
+			c, client := newDeletionController(t, exec, objects...)
+			workspace := c.workspaceDir(builder)
+			if err := os.MkdirAll(filepath.Join(workspace, "context"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.syncHandler(context.Background(), cache.ObjectName{Name: builder.Name}); err != nil {
+				t.Fatal(err)
+			}
+			image, err := client.ImageV1().Images().Get(context.Background(), builder.Name, metav1.GetOptions{})
+			switch {
+			case tt.owners < 0 && !apierrors.IsNotFound(err):
+				t.Errorf("image is kept: %+v, %v", image, err)
+			case tt.owners >= 0 && err != nil:
+				t.Errorf("image is gone: %v", err)
+			case tt.owners >= 0 && len(image.OwnerReferences) != tt.owners:
+				t.Errorf("image has owners %v, want %d", image.OwnerReferences, tt.owners)
+			}
+			if got := finalizers(t, client, builder.Name); len(got) != 1 || got[0] != "example.com/other" {
+				t.Errorf("finalizers %v, want only the other one", got)
+			}
+			if exec.cancels != 1 {
+				t.Errorf("build was cancelled %d times, want 1", exec.cancels)
+			}
+			if _, err := os.Stat(workspace); !os.IsNotExist(err) {
+				t.Errorf("workspace is kept: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteBuilderRetriesCleanup(t *testing.T) {
+	tests := []struct {
+		name      string
+		cancelErr error
+		deleteErr error
+	}{
+		{"build can't be cancelled", errors.New("job can't be deleted"), nil},
+		{"image can't be deleted", nil, apierrors.NewServiceUnavailable("apiserver unavailable")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := newDeletedBuilder(DeletionPolicyDelete)
+			image := imageFor(builder)
+			image.UID = "image-uid"
+			exec := &runningExecutor{cancelErr: tt.cancelErr}
+			c, client := newDeletionController(t, exec, builder, image)
+			deleteErr := tt.deleteErr
+			client.PrependReactor("delete", "images", func(action core.Action) (bool, runtime.Object, error) {
+				return deleteErr != nil, nil, deleteErr
+			})
+
+			// the Builder stays until its cleanup succeeded
+			if err := c.syncHandler(context.Background(), cache.ObjectName{Name: builder.Name}); err == nil {
+				t.Fatal("syncHandler() succeeded although the cleanup failed")
+			}
+			if got := finalizers(t, client, builder.Name); len(got) != 2 {
+				t.Fatalf("finalizers %v after a failed cleanup, want %s kept", got, BuilderFinalizer)
+			}
+
+			exec.cancelErr, deleteErr = nil, nil
+			if err := c.syncHandler(context.Background(), cache.ObjectName{Name: builder.Name}); err != nil {
+				t.Fatal(err)
+			}
+			if got := finalizers(t, client, builder.Name); len(got) != 1 || got[0] != "example.com/other" {
+				t.Errorf("finalizers %v once the cleanup succeeded, want only the other one", got)
+			}
+			if _, err := client.ImageV1().Images().Get(context.Background(), builder.Name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+				t.Errorf("image is kept: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+)
+
+const (
+	// maxInlineDockerfileSize limits inline Dockerfiles, they may be handed
+	// to the build Job through a ConfigMap.
+	maxInlineDockerfileSize = 256 << 10
+	// defaultDockerFile is the name an inline Dockerfile is written to.
+	defaultDockerFile = "Dockerfile"
+)
+
+// specError explains why the spec of a Builder is rejected.
+type specError struct {
+	reason  string
+	message string
+}
+
+// validateSpec checks the spec of a Builder and returns the inline
+// Dockerfile, nil when the Dockerfile is taken from the remote context.
+//
+// The Dockerfile is chosen as follows:
+//   - dockerFileBase64 and dockerFileString are mutually exclusive.
+//   - An inline Dockerfile is written to the root of the build context as
+//     Dockerfile, replacing a file of that name in the remote context, so
+//     remoteContext.dockerFileName must not be set along with it.
+//   - Without remoteContext.contentUrl an inline Dockerfile is built with an
+//     otherwise empty context.
+//   - Without an inline Dockerfile remoteContext.dockerFileName, Dockerfile by
+//     default, is used from the remote context.
+func validateSpec(spec builderv1.BuilderSpec) ([]byte, *specError) {
+	if spec.Image.ImageUrl == "" {
+		return nil, &specError{ReasonInvalidSpec, "spec.image.imageUrl must be set"}
+	}
+	if invalid := validateBuildOptions(spec); invalid != nil {
+		return nil, invalid
+	}
+	if invalid := validateOutputs(spec); invalid != nil {
+		return nil, invalid
+	}
+
+	switch {
+	case spec.DockerFileBase64 != "" && spec.DockerFileString != "":
+		return nil, &specError{ReasonDockerfileConflict, "spec.dockerFileBase64 and spec.dockerFileString are mutually exclusive"}
+	case spec.DockerFileBase64 == "" && spec.DockerFileString == "":
+		if spec.RemoteContext.ContentUrl == "" {
+			return nil, &specError{ReasonInvalidSpec, "spec.remoteContext.contentUrl must be set unless the Dockerfile is given inline"}
+		}
+		return nil, nil
+	case spec.RemoteContext.DockerFileName != "":
+		return nil, &specError{ReasonDockerfileConflict, "spec.remoteContext.dockerFileName must not be set along with an inline Dockerfile"}
+	}
+
+	dockerfile := []byte(spec.DockerFileString)
+	if spec.DockerFileBase64 != "" {
+		// base64 tools wrap their output, line breaks are not part of the data
+		encoded := strings.Join(strings.Fields(spec.DockerFileBase64), "")
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, &specError{ReasonInvalidDockerfile, fmt.Sprintf("spec.dockerFileBase64 is not valid base64: %v", err)}
+		}
+		dockerfile = decoded
+	}
+	if err := checkDockerfile(dockerfile); err != nil {
+		return nil, &specError{ReasonInvalidDockerfile, fmt.Sprintf("inline Dockerfile is invalid: %v", err)}
+	}
+	return dockerfile, nil
+}
+
+// checkDockerfile makes sure an inline Dockerfile looks like one, the build
+// reports everything beyond that.
+func checkDockerfile(dockerfile []byte) error {
+	if len(dockerfile) > maxInlineDockerfileSize {
+		return fmt.Errorf("larger than %d bytes", maxInlineDockerfileSize)
+	}
+	if !utf8.Valid(dockerfile) {
+		return errors.New("not valid UTF-8")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(dockerfile))
+	scanner.Buffer(make([]byte, 0, 64<<10), maxInlineDockerfileSize)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && strings.EqualFold(fields[0], "FROM") {
+			return nil
+		}
+	}
+	return errors.New("no FROM instruction")
+}
+
+// writeDockerfile puts the inline Dockerfile into the build context.
+func writeDockerfile(contextDir string, dockerfile []byte) error {
+	target := filepath.Join(contextDir, defaultDockerFile)
+	// a symlink of the same name must not redirect the write
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.WriteFile(target, dockerfile, 0o644)
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	imagev1 "builder/pkg/apis/image/v1"
+)
+
+func TestValidateSpec(t *testing.T) {
+	const dockerfile = "# syntax=docker/dockerfile:1\nFROM scratch\nCOPY . /\n"
+	encoded := base64.StdEncoding.EncodeToString([]byte(dockerfile))
+	image := imagev1.ImageSpec{ImageUrl: "registry.example.com/app", ImageTag: "v1"}
+	remote := builderv1.RemoteContext{ContentUrl: "https://example.com/context.tar.gz"}
+
+	tests := []struct {
+		name       string
+		spec       builderv1.BuilderSpec
+		dockerfile string
+		reason     string
+	}{
+		{"inline string", builderv1.BuilderSpec{Image: image, DockerFileString: dockerfile}, dockerfile, ""},
+		{"inline base64", builderv1.BuilderSpec{Image: image, DockerFileBase64: encoded}, dockerfile, ""},
+		{"wrapped base64", builderv1.BuilderSpec{Image: image, DockerFileBase64: encoded[:20] + "\n" + encoded[20:] + "\n"}, dockerfile, ""},
+		{"inline with a remote context", builderv1.BuilderSpec{Image: image, DockerFileString: dockerfile, RemoteContext: remote}, dockerfile, ""},
+		{"lower case from", builderv1.BuilderSpec{Image: image, DockerFileString: "from scratch\n"}, "from scratch\n", ""},
+		{"dockerfile from the remote context", builderv1.BuilderSpec{Image: image, RemoteContext: remote}, "", ""},
+		{"dockerfile path in the remote context", builderv1.BuilderSpec{Image: image, RemoteContext: builderv1.RemoteContext{ContentUrl: remote.ContentUrl, DockerFileName: "build/Dockerfile"}}, "", ""},
+		{"no image", builderv1.BuilderSpec{DockerFileString: dockerfile}, "", ReasonInvalidSpec},
+		{"no dockerfile and no context", builderv1.BuilderSpec{Image: image}, "", ReasonInvalidSpec},
+		{"string and base64", builderv1.BuilderSpec{Image: image, DockerFileString: dockerfile, DockerFileBase64: encoded}, "", ReasonDockerfileConflict},
+		{"inline and a dockerfile path", builderv1.BuilderSpec{Image: image, DockerFileString: dockerfile, RemoteContext: builderv1.RemoteContext{ContentUrl: remote.ContentUrl, DockerFileName: "Dockerfile"}}, "", ReasonDockerfileConflict},
+		{"invalid base64", builderv1.BuilderSpec{Image: image, DockerFileBase64: "not base64!"}, "", ReasonInvalidDockerfile},
+		{"no FROM instruction", builderv1.BuilderSpec{Image: image, DockerFileString: "RUN true\n"}, "", ReasonInvalidDockerfile},
+		{"FROM in a comment only", builderv1.BuilderSpec{Image: image, DockerFileString: "# FROM scratch\n"}, "", ReasonInvalidDockerfile},
+		{"not UTF-8", builderv1.BuilderSpec{Image: image, DockerFileBase64: base64.StdEncoding.EncodeToString([]byte("FROM scratch\n\xff"))}, "", ReasonInvalidDockerfile},
+		{"too large", builderv1.BuilderSpec{Image: image, DockerFileString: "FROM scratch\n" + strings.Repeat("#", maxInlineDockerfileSize)}, "", ReasonInvalidDockerfile},
+		{"invalid build argument", builderv1.BuilderSpec{Image: image, DockerFileString: dockerfile, BuildArgs: []builderv1.BuildArg{{Name: "A=B"}}}, "", ReasonInvalidSpec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, invalid := validateSpec(tt.spec)
+			if tt.reason != "" {
+				if invalid == nil || invalid.reason != tt.reason {
+					t.Fatalf("validateSpec() = %+v, want reason %s", invalid, tt.reason)
+				}
+				if invalid.message == "" {
+					t.Error("the spec is rejected without a message")
+				}
+				return
+			}
+			if invalid != nil {
+				t.Fatalf("validateSpec() = %+v", invalid)
+			}
+			if string(got) != tt.dockerfile {
+				t.Errorf("inline Dockerfile %q, want %q", got, tt.dockerfile)
+			}
+			if tt.dockerfile == "" && got != nil {
+				t.Errorf("inline Dockerfile %q for a Dockerfile from the remote context", got)
+			}
+		})
+	}
+}
+
+func TestWriteDockerfile(t *testing.T) {
+	const dockerfile = "FROM scratch\n"
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+	}{
+		{"empty context", func(t *testing.T, dir string) {}},
+		{"replaces the Dockerfile of the remote context", func(t *testing.T, dir string) {
+			if err := os.WriteFile(filepath.Join(dir, defaultDockerFile), []byte("FROM busybox\nRUN evil\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"replaces a symlink instead of writing through it", func(t *testing.T, dir string) {
+			if err := os.Symlink(filepath.Join(dir, "..", "outside"), filepath.Join(dir, defaultDockerFile)); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "context")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			tt.setup(t, dir)
+
+			if err := writeDockerfile(dir, []byte(dockerfile)); err != nil {
+				t.Fatal(err)
+			}
+			target := filepath.Join(dir, defaultDockerFile)
+			info, err := os.Lstat(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.Mode().IsRegular() || info.Mode().Perm() != 0o644 {
+				t.Errorf("Dockerfile has mode %v, want a regular file with 0644", info.Mode())
+			}
+			if data, err := os.ReadFile(target); err != nil || string(data) != dockerfile {
+				t.Errorf("Dockerfile has %q, %v, want %q", data, err, dockerfile)
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "..", "outside")); !os.IsNotExist(err) {
+				t.Errorf("the Dockerfile was written outside the context: %v", err)
+			}
+		})
+	}
+
+	if err := writeDockerfile(filepath.Join(t.TempDir(), "missing"), []byte(dockerfile)); err == nil {
+		t.Error("writeDockerfile() succeeded without a context directory")
+	}
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	"builder/pkg/executor"
+)
+
+// buildLogTailLines is how many lines of the log of a failed build end up in
+// the controller log.
+const buildLogTailLines = 20
+
+// executorFor returns the executor building the image of the Builder.
+func (c *Controller) executorFor(builder *builderv1.Builder) (executor.BuildExecutor, error) {
+	t := builder.Spec.Executor
+	if t == "" {
+		t = c.config.DefaultExecutor
+	}
+	exec, ok := c.executors[executor.ExecutorType(t)]
+	if !ok {
+		return nil, fmt.Errorf("spec.executor %q: %w", t, executor.ErrUnsupportedExecutor)
+	}
+	return exec, nil
+}
+
+// newBuild describes the build of the Builder for its executor.
+func (c *Controller) newBuild(builder *builderv1.Builder) (executor.BuildExecutor, *executor.Build, error) {
+	exec, err := c.executorFor(builder)
+	if err != nil {
+		return nil, nil, err
+	}
+	dockerfile, invalid := validateSpec(builder.Spec)
+	if invalid != nil {
+		return nil, nil, errors.New(invalid.message)
+	}
+	if invalid := c.validateContextSource(builder.Spec); invalid != nil {
+		return nil, nil, errors.New(invalid.message)
+	}
+	if len(builder.Spec.Outputs) > 0 && !c.exportsImage() {
+		return nil, nil, errors.New("spec.outputs needs the controller to push the image, which needs a workspace claim")
+	}
+	cache, err := c.buildCache(builder)
+	if err != nil {
+		return nil, nil, err
+	}
+	build := &executor.Build{
+		Builder:     builder,
+		Dockerfile:  dockerfile,
+		Destination: imageReference(builder.Spec.Image),
+		Export:      c.exportsImage(),
+		Cache:       cache,
+	}
+	if deadline, ok := c.buildDeadline(builder); ok {
+		build.Deadline = deadline
+	}
+	return exec, build, nil
+}
+
+// buildError fails the Builder if the executor can't ever run its build and
+// returns err to retry otherwise.
+func (c *Controller) buildError(ctx context.Context, builder *builderv1.Builder, err error) error {
+	if errors.Is(err, executor.ErrUnsupportedBuild) {
+		return c.failBuilder(ctx, builder, ReasonBuildFailed, err.Error())
+	}
+	return err
+}
+
+// logBuildTail copies the end of the log of a failed build to the controller
+// log, the build itself may be gone by the time someone looks. Secret values
+// the build reads are redacted by redactor.
+func (c *Controller) logBuildTail(ctx context.Context, exec executor.BuildExecutor, build *executor.Build, redactor *strings.Replacer, logger klog.Logger) {
+	logs, err := exec.Logs(ctx, build)
+	if err != nil {
+		logger.V(2).Info("build log is not available", "builder", build.Builder.Name, "err", err)
+		return
+	}
+	defer logs.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(io.LimitReader(logs, 1<<20))
+	for scanner.Scan() {
+		lines = append(lines, redactor.Replace(scanner.Text()))
+		if len(lines) > buildLogTailLines {
+			lines = lines[1:]
+		}
+	}
+	logger.Info("build failed", "builder", build.Builder.Name, "executor", exec.GetType(), "log", lines)
+}
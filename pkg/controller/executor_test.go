@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	"builder/pkg/executor"
+	_ "builder/pkg/executor/plugins"
+)
+
+func TestExecutorFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		executor   string
+		enableFake bool
+		want       executor.ExecutorType
+	}{
+		{"default executor", "", false, "kaniko"},
+		{"chosen executor", "buildkit", false, "buildkit"},
+		{"fake executor when enabled", "fake", true, "fake"},
+		{"fake executor is off by default", "fake", false, ""},
+		{"unknown executor", "docker", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{
+				config:    Config{DefaultExecutor: "kaniko"},
+				executors: executor.NewExecutors(executor.Env{EnableFake: tt.enableFake}),
+			}
+			builder := &builderv1.Builder{Spec: builderv1.BuilderSpec{Executor: tt.executor}}
+			exec, err := c.executorFor(builder)
+			if tt.want == "" {
+				if !errors.Is(err, executor.ErrUnsupportedExecutor) {
+					t.Errorf("executorFor() = %v, want ErrUnsupportedExecutor", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exec.GetType() != tt.want {
+				t.Errorf("executorFor() = %s, want %s", exec.GetType(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	imagev1 "builder/pkg/apis/image/v1"
+	clientset "builder/pkg/client/generated/clientset/versioned"
+	samplescheme "builder/pkg/client/generated/clientset/versioned/scheme"
+	imageInformers "builder/pkg/client/generated/informers/externalversions/image/v1"
+	imageListers "builder/pkg/client/generated/listers/image/v1"
+	"builder/pkg/registry"
+)
+
+const imageControllerAgentName = "image-controller"
+
+const (
+	// ImageNotFound is the state of an Image the registry doesn't serve.
+	ImageNotFound = "NotFound"
+	// ImageUnknown is the state of an Image whose registry can't be asked.
+	ImageUnknown = "Unknown"
+	// ImageInvalid is the state of an Image whose spec is no valid reference.
+	ImageInvalid = "Invalid"
+
+	// ReasonImageAvailable is used when the registry serves an Image
+	ReasonImageAvailable = "ImageAvailable"
+	// ReasonImageNotFound is used when an Image is missing from the registry
+	ReasonImageNotFound = "ImageNotFound"
+	// ReasonImageInvalid is used when an Image can't be looked up
+	ReasonImageInvalid = "InvalidImage"
+
+	MessageImageAvailable = "Image %s is available with digest %s"
+	MessageImageNotFound  = "Image %s is not in the registry"
+)
+
+// ImageController keeps the status of Image resources in line with the
+// registry, it looks up every Image when it changes and again after
+// Config.ImageVerifyInterval.
+type ImageController struct {
+	config Config
+
+	kubeclientset kubernetes.Interface
+	client        clientset.Interface
+
+	imageLister imageListers.ImageLister
+	imageSynced cache.InformerSynced
+
+	workqueue workqueue.TypedRateLimitingInterface[cache.ObjectName]
+	recorder  record.EventRecorder
+}
+
+// NewImageController returns a new Image controller
+func NewImageController(
+	ctx context.Context,
+	config Config,
+	kubeclientset kubernetes.Interface,
+	client clientset.Interface,
+	imageInformer imageInformers.ImageInformer) *ImageController {
+	logger := klog.FromContext(ctx)
+
+	utilruntime.Must(samplescheme.AddToScheme(scheme.Scheme))
+	logger.V(4).Info("Creating event broadcaster")
+
+	eventBroadcaster := record.NewBroadcaster(record.WithContext(ctx))
+	eventBroadcaster.StartStructuredLogging(0)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: imageControllerAgentName})
+	ratelimiter := workqueue.NewTypedMaxOfRateLimiter(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[cache.ObjectName](time.Second, 5*time.Minute),
+		&workqueue.TypedBucketRateLimiter[cache.ObjectName]{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+
+	controller := &ImageController{
+		config:        config,
+		kubeclientset: kubeclientset,
+		client:        client,
+		imageLister:   imageInformer.Lister(),
+		imageSynced:   imageInformer.Informer().HasSynced,
+		workqueue:     workqueue.NewTypedRateLimitingQueue(ratelimiter),
+		recorder:      recorder,
+	}
+
+	logger.Info("Setting up image event handlers")
+	imageInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueImage,
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueueImage(new)
+		},
+		DeleteFunc: controller.enqueueImage,
+	})
+	return controller
+}
+
+func (c *ImageController) Run(ctx context.Context, workers int) error {
+	defer utilruntime.HandleCrash()
+	defer c.workqueue.ShutDown()
+	logger := klog.FromContext(ctx)
+
+	logger.Info("Starting Image controller")
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.imageSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	logger.Info("Starting image workers", "count", workers)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+
+	<-ctx.Done()
+	logger.Info("Shutting down image workers")
+	return nil
+}
+
+func (c *ImageController) enqueueImage(obj interface{}) {
+	objectRef, err := cache.ObjectToName(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(objectRef)
+}
+
+func (c *ImageController) runWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
+	}
+}
+
+func (c *ImageController) processNextWorkItem(ctx context.Context) bool {
+	objRef, shutdown := c.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.workqueue.Done(objRef)
+
+	if err := c.syncHandler(ctx, objRef); err != nil {
+		// registry errors are retried with backoff for as long as the Image exists
+		utilruntime.HandleErrorWithContext(ctx, err, "Error syncing image; requeuing for later retry", "objectReference", objRef)
+		c.workqueue.AddRateLimited(objRef)
+		return true
+	}
+	c.workqueue.Forget(objRef)
+	return true
+}
+
+// syncHandler looks up the Image in the registry and records what it found.
+// An Image verified less than ImageVerifyInterval ago is left alone, which
+// also keeps the status update from triggering another lookup.
+func (c *ImageController) syncHandler(ctx context.Context, obj cache.ObjectName) error {
+	logger := klog.LoggerWithValues(klog.FromContext(ctx), "objectRef", obj)
+
+	image, err := c.imageLister.Get(obj.Name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if next, ok := c.nextVerification(image); ok {
+		c.workqueue.AddAfter(obj, next)
+		return nil
+	}
+
+	status, _, syncErr := verifyImage(ctx, c.kubeclientset, c.config, image, logger)
+	if syncErr == nil {
+		c.workqueue.AddAfter(obj, c.config.ImageVerifyInterval)
+	}
+
+	if status.State != image.Status.State {
+		switch status.State {
+		case ImageAvailable:
+			c.recorder.Event(image, corev1.EventTypeNormal, ReasonImageAvailable, fmt.Sprintf(MessageImageAvailable, imageReference(image.Spec), status.Digest))
+		case ImageNotFound:
+			c.recorder.Event(image, corev1.EventTypeWarning, ReasonImageNotFound, fmt.Sprintf(MessageImageNotFound, imageReference(image.Spec)))
+		case ImageInvalid:
+			c.recorder.Event(image, corev1.EventTypeWarning, ReasonImageInvalid, status.Message)
+		}
+	}
+	if !equality.Semantic.DeepEqual(image.Status, *status) {
+		deepCopy := image.DeepCopy()
+		deepCopy.Status = *status
+		if _, err := c.client.ImageV1().Images().UpdateStatus(ctx, deepCopy, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+	return syncErr
+}
+
+// nextVerification returns how long an Image that was verified recently can
+// wait for the next lookup.
+func (c *ImageController) nextVerification(image *imagev1.Image) (time.Duration, bool) {
+	status := image.Status
+	if status.State != ImageAvailable || status.LastVerified == nil || status.ObservedGeneration != image.Generation {
+		return 0, false
+	}
+	next := time.Until(status.LastVerified.Add(c.config.ImageVerifyInterval))
+	return next, next > 0
+}
+
+// verifyImage looks the Image up in the registry and returns the status it
+// should have, along with what the registry serves when it is available.
+// The error is returned for lookups worth retrying.
+func verifyImage(ctx context.Context, kubeclientset kubernetes.Interface, config Config, image *imagev1.Image, logger klog.Logger) (*imagev1.ImageStatus, *registry.Image, error) {
+	status := image.Status.DeepCopy()
+	status.ObservedGeneration = image.Generation
+
+	ref, err := registry.ParseReference(imageReference(image.Spec))
+	if err != nil {
+		clearImageStatus(status, ImageInvalid, err.Error())
+		return status, nil, nil
+	}
+	client, err := newRegistryClient(ctx, kubeclientset, config, image.Spec.RegisterSecret)
+	if err != nil {
+		clearImageStatus(status, ImageUnknown, err.Error())
+		return status, nil, err
+	}
+
+	inspected, err := client.Inspect(ctx, ref)
+	if errors.Is(err, registry.ErrNotFound) {
+		logger.Info("image not found in registry", "image", image.Name, "reference", ref)
+		clearImageStatus(status, ImageNotFound, fmt.Sprintf(MessageImageNotFound, ref))
+		return status, nil, nil
+	}
+	if err != nil {
+		clearImageStatus(status, ImageUnknown, err.Error())
+		return status, nil, err
+	}
+
+	logger.V(4).Info("image verified", "image", image.Name, "digest", inspected.Digest)
+	now := metav1.Now()
+	status.State = ImageAvailable
+	status.Message = ""
+	status.Digest = inspected.Digest
+	status.Size = inspected.Size
+	status.ImageSize = formatBytes(inspected.Size)
+	status.ImagePullPath = imageReference(image.Spec) + "@" + inspected.Digest
+	status.Architectures = inspected.Architectures()
+	status.Created = nil
+	if !inspected.Created.IsZero() {
+		created := metav1.NewTime(inspected.Created)
+		status.Created = &created
+	}
+	status.LastVerified = &now
+	return status, inspected, nil
+}
+
+// clearImageStatus records an Image the registry does not serve, dropping
+// what an earlier lookup found so the status doesn't point at a stale digest.
+func clearImageStatus(status *imagev1.ImageStatus, state, message string) {
+	status.State = state
+	status.Message = message
+	status.Digest = ""
+	status.Size = 0
+	status.ImageSize = ""
+	status.ImagePullPath = ""
+	status.Architectures = nil
+	status.Created = nil
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2"
+
+	imagev1 "builder/pkg/apis/image/v1"
+	"builder/pkg/registry"
+)
+
+// imageRegistry serves a single-platform image as app:v1 while available is
+// set and answers 404 otherwise.
+type imageRegistry struct {
+	mu        sync.Mutex
+	available bool
+	manifest  []byte
+	config    []byte
+}
+
+func newImageRegistry(t *testing.T) (*imageRegistry, *httptest.Server) {
+	t.Helper()
+	config := []byte(`{"os":"linux","architecture":"arm64","created":"2024-01-02T00:00:00Z"}`)
+	manifest, err := json.Marshal(registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		Config:        &registry.Descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: sha256Digest(config), Size: int64(len(config))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &imageRegistry{available: true, manifest: manifest, config: config}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return r, srv
+}
+
+func (r *imageRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case !r.available:
+		w.WriteHeader(http.StatusNotFound)
+	case req.URL.Path == "/v2/app/manifests/v1":
+		w.Header().Set("Content-Type", registry.MediaTypeOCIManifest)
+		w.Write(r.manifest)
+	case req.URL.Path == "/v2/app/blobs/"+sha256Digest(r.config):
+		w.Write(r.config)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *imageRegistry) setAvailable(available bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.available = available
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestVerifyImage(t *testing.T) {
+	images, srv := newImageRegistry(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	config := Config{InsecureRegistries: []string{host}, RegistryTimeout: 5 * time.Second}
+	kubeclientset := kubefake.NewSimpleClientset()
+
+	image := &imagev1.Image{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Generation: 1},
+		Spec:       imagev1.ImageSpec{ImageUrl: host + "/app", ImageTag: "v1"},
+	}
+
+	tests := []struct {
+		name      string
+		available bool
+		imageUrl  string
+		state     string
+		wantErr   bool
+	}{
+		{"available", true, host + "/app", ImageAvailable, false},
+		{"deleted from the registry", false, host + "/app", ImageNotFound, false},
+		{"available again", true, host + "/app", ImageAvailable, false},
+		{"invalid reference", true, host + "/App", ImageInvalid, false},
+		{"available before the registry goes away", true, host + "/app", ImageAvailable, false},
+		{"registry unreachable", true, "127.0.0.1:1/app", ImageUnknown, true},
+	}
+	for _, tt := range tests {
+		images.setAvailable(tt.available)
+		image.Spec.ImageUrl = tt.imageUrl
+		status, _, err := verifyImage(context.Background(), kubeclientset, config, image, klog.Background())
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: verifyImage() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if status.State != tt.state {
+			t.Errorf("%s: state %s, want %s", tt.name, status.State, tt.state)
+		}
+
+		if tt.state == ImageAvailable {
+			if status.Digest != sha256Digest(images.manifest) || status.ImagePullPath == "" || status.Size == 0 || status.ImageSize == "" {
+				t.Errorf("%s: status does not describe the image: %+v", tt.name, status)
+			}
+			if len(status.Architectures) != 1 || status.Architectures[0] != "linux/arm64" || status.Created == nil {
+				t.Errorf("%s: platform is not recorded: %+v", tt.name, status)
+			}
+		} else if status.Digest != "" || status.ImagePullPath != "" || status.Size != 0 || status.ImageSize != "" ||
+			status.Architectures != nil || status.Created != nil {
+			t.Errorf("%s: what the registry served before is kept: %+v", tt.name, status)
+		}
+		image.Status = *status
+	}
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	imagev1 "builder/pkg/apis/image/v1"
+	"builder/pkg/registry"
+)
+
+// errImageConflict is returned by ensureImage when the Image resource of a
+// Builder exists but is not controlled by it, owner is nil when nothing
+// controls the Image.
+type errImageConflict struct {
+	name  string
+	owner *metav1.OwnerReference
+}
+
+func (e *errImageConflict) Error() string {
+	if e.owner == nil {
+		return fmt.Sprintf(MessageImageUnowned, e.name)
+	}
+	return fmt.Sprintf(MessageImageConflict, e.name, e.owner.Kind, e.owner.Name)
+}
+
+// imageFor returns the Image resource the Builder publishes its pushed image
+// as, it is named after and controlled by the Builder.
+func imageFor(builder *builderv1.Builder) *imagev1.Image {
+	return &imagev1.Image{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: builder.Name,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(builder, builderv1.SchemeGroupVersion.WithKind("Builder")),
+			},
+		},
+		Spec: builder.Spec.Image,
+	}
+}
+
+// ensureImage creates the Image resource of the Builder, or brings the one it
+// created earlier in line with the push target, and fills its status from the
+// registry. An Image the Builder doesn't control is left alone and
+// errImageConflict returned, even one nobody controls: it may belong to a
+// user, and deleting the Builder would delete it. It may be called any number
+// of times for the same Builder.
+//
+// The status describes the image the Builder pushed. What the registry serves
+// for it is returned too, nil when it couldn't be looked up or the tag has
+// moved on to another image since.
+func (c *Controller) ensureImage(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) (*imagev1.Image, *registry.Image, error) {
+	desired := imageFor(builder)
+
+	image, err := c.client.ImageV1().Images().Get(ctx, desired.Name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		image, err = c.client.ImageV1().Images().Create(ctx, desired, metav1.CreateOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.Info("image created", "builder", builder.Name, "image", image.Name)
+	case err != nil:
+		return nil, nil, err
+	default:
+		if owner := metav1.GetControllerOf(image); owner == nil || owner.UID != builder.UID {
+			return nil, nil, &errImageConflict{name: image.Name, owner: owner}
+		}
+		if !equality.Semantic.DeepEqual(image.Spec, desired.Spec) {
+			image = image.DeepCopy()
+			image.Spec = desired.Spec
+			if image, err = c.client.ImageV1().Images().Update(ctx, image, metav1.UpdateOptions{}); err != nil {
+				return nil, nil, err
+			}
+			logger.Info("image updated", "builder", builder.Name, "image", image.Name)
+		}
+	}
+
+	status, inspected, err := verifyImage(ctx, c.kubeclientset, c.config, image, logger)
+	if pushed := builder.Status.ImageDigest; pushed != "" {
+		switch {
+		case err != nil || status.State != ImageAvailable:
+			// the Image controller keeps looking it up, until then the status
+			// tells what the push reported
+			logger.Info("image can't be verified yet", "image", image.Name, "state", status.State, "err", err)
+		case status.Digest != pushed:
+			// the tag moved on since the push, what it points to now is not
+			// the image built by the Builder
+			logger.Info("image tag has moved on since the push", "image", image.Name, "pushed", pushed, "tagged", status.Digest)
+			clearImageStatus(status, status.State, fmt.Sprintf("the tag now points to %s", status.Digest))
+			inspected = nil
+		}
+		status.Digest = pushed
+		status.ImagePullPath = imageReference(image.Spec) + "@" + pushed
+	}
+	if !equality.Semantic.DeepEqual(image.Status, *status) {
+		image = image.DeepCopy()
+		image.Status = *status
+		if image, err = c.client.ImageV1().Images().UpdateStatus(ctx, image, metav1.UpdateOptions{}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return image, inspected, nil
+}
+
+// platformImages lists the image pushed for each platform.
+func platformImages(image *registry.Image) []builderv1.PlatformImage {
+	platforms := make([]builderv1.PlatformImage, 0, len(image.Platforms))
+	for _, platform := range image.Platforms {
+		platforms = append(platforms, builderv1.PlatformImage{
+			Platform: platform.String(),
+			Digest:   platform.Digest,
+		})
+	}
+	return platforms
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	imagev1 "builder/pkg/apis/image/v1"
+	"builder/pkg/client/generated/clientset/versioned/fake"
+)
+
+// newImageSourceController returns a controller publishing Builders pushed to
+// the registry at host.
+func newImageSourceController(host string, objects ...runtime.Object) (*Controller, *fake.Clientset) {
+	client := fake.NewSimpleClientset(objects...)
+	return &Controller{
+		client:        client,
+		kubeclientset: kubefake.NewSimpleClientset(),
+		recorder:      record.NewFakeRecorder(100),
+		config:        Config{InsecureRegistries: []string{host}, RegistryTimeout: 5 * time.Second},
+	}, client
+}
+
+func newPushedBuilder(host, digest string) *builderv1.Builder {
+	return &builderv1.Builder{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", UID: types.UID("builder-uid"), ResourceVersion: "1"},
+		Spec:       builderv1.BuilderSpec{Image: imagev1.ImageSpec{ImageUrl: host + "/app", ImageTag: "v1"}},
+		Status:     builderv1.BuilderStatus{ImageDigest: digest},
+	}
+}
+
+func TestEnsureImageOwnership(t *testing.T) {
+	images, srv := newImageRegistry(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	pushed := sha256Digest(images.manifest)
+	builder := newPushedBuilder(host, pushed)
+
+	owned := imageFor(builder)
+	owned.Spec.ImageTag = "old"
+	unowned := imageFor(builder)
+	unowned.OwnerReferences = nil
+	unowned.Spec.ImageTag = "user"
+	foreign := imageFor(builder)
+	foreign.OwnerReferences[0].UID = "other-uid"
+	foreign.OwnerReferences[0].Name = "other"
+
+	tests := []struct {
+		name     string
+		existing *imagev1.Image
+		conflict bool
+	}{
+		{"created", nil, false},
+		{"created earlier by the builder", owned, false},
+		{"not controlled by anything", unowned, true},
+		{"controlled by another builder", foreign, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []runtime.Object
+			if tt.existing != nil {
+				objects = append(objects, tt.existing.DeepCopy())
+			}
+			c, client := newImageSourceController(host, objects...)
+
+			image, _, err := c.ensureImage(context.Background(), builder, klog.Background())
+			var conflict *errImageConflict
+			if errors.As(err, &conflict) != tt.conflict {
+				t.Fatalf("ensureImage() = %v, want conflict %v", err, tt.conflict)
+			}
+			stored, getErr := client.ImageV1().Images().Get(context.Background(), builder.Name, metav1.GetOptions{})
+			if getErr != nil {
+				t.Fatal(getErr)
+			}
+			if tt.conflict {
+				if stored.Spec != tt.existing.Spec || len(stored.OwnerReferences) != len(tt.existing.OwnerReferences) {
+					t.Errorf("an Image the builder doesn't control was changed: %+v", stored)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Spec != builder.Spec.Image || image.Status.Digest != pushed {
+				t.Errorf("image does not describe the push: %+v", stored)
+			}
+		})
+	}
+}
+
+func TestEnsureImageStatus(t *testing.T) {
+	images, srv := newImageRegistry(t)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	tagged := sha256Digest(images.manifest)
+	other := "sha256:" + strings.Repeat("1", 64)
+
+	tests := []struct {
+		name      string
+		pushed    string
+		available bool
+		state     string
+		inspected bool
+		details   bool
+	}{
+		{"tag points to the pushed image", tagged, true, ImageAvailable, true, true},
+		{"tag has moved on", other, true, ImageAvailable, false, false},
+		{"tag not found yet", other, false, ImageNotFound, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images.setAvailable(tt.available)
+			builder := newPushedBuilder(host, tt.pushed)
+			c, _ := newImageSourceController(host)
+
+			image, inspected, err := c.ensureImage(context.Background(), builder, klog.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			status := image.Status
+			if status.Digest != tt.pushed || status.ImagePullPath != host+"/app:v1@"+tt.pushed {
+				t.Errorf("status points at %s, %s, want the pushed %s", status.Digest, status.ImagePullPath, tt.pushed)
+			}
+			if status.State != tt.state {
+				t.Errorf("state %s, want %s", status.State, tt.state)
+			}
+			if (inspected != nil) != tt.inspected {
+				t.Errorf("inspected = %+v, want %v", inspected, tt.inspected)
+			}
+			if details := status.Size != 0 || status.Architectures != nil || status.Created != nil; details != tt.details {
+				t.Errorf("status has details %v, want %v: %+v", details, tt.details, status)
+			}
+		})
+	}
+}
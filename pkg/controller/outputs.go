@@ -0,0 +1,366 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	"builder/pkg/downloader"
+	"builder/pkg/downloader/downloaderPlugin"
+	"builder/pkg/executor"
+	"builder/pkg/registry"
+)
+
+// tagPattern matches what registries accept as a tag.
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// outputTimestampLayout formats {{.Timestamp}} in the templates of
+// spec.outputs, it sorts and is a valid tag.
+const outputTimestampLayout = "20060102150405"
+
+// outputTarballName is the file in the workspace a tarball output is written
+// to before it is uploaded.
+const outputTarballName = "image.tar"
+
+// gitContextType is the downloader type of git remote contexts, the only ones
+// whose revision is a commit.
+const gitContextType = "git"
+
+// outputMetadata is what the tags and urls of spec.outputs are expanded with.
+type outputMetadata struct {
+	Name        string
+	Commit      string
+	ShortCommit string
+	Timestamp   string
+}
+
+// errOutputFailed is an error no retry of the output gets past.
+type errOutputFailed struct {
+	err error
+}
+
+func (e *errOutputFailed) Error() string { return e.err.Error() }
+
+func (e *errOutputFailed) Unwrap() error { return e.err }
+
+// gitContext tells whether the remote context of the Builder is a git
+// repository, its type is resolved like the download resolves it.
+func gitContext(spec builderv1.BuilderSpec) bool {
+	if spec.RemoteContext.ContentUrl == "" {
+		return false
+	}
+	d, err := downloader.Resolve(spec.RemoteContext.Type, spec.RemoteContext.ContentUrl)
+	return err == nil && d.GetType() == gitContextType
+}
+
+// outputMetadataFor returns the metadata of the build of the Builder. The
+// commit is only known for git contexts, other downloaders report revisions
+// like ETags.
+func outputMetadataFor(builder *builderv1.Builder) outputMetadata {
+	metadata := outputMetadata{Name: builder.Name}
+	if builder.Status.Context != nil && gitContext(builder.Spec) {
+		metadata.Commit = builder.Status.Context.Revision
+		metadata.ShortCommit = metadata.Commit
+		if len(metadata.ShortCommit) > 7 {
+			metadata.ShortCommit = metadata.ShortCommit[:7]
+		}
+	}
+	started := builder.CreationTimestamp.Time
+	if builder.Status.StartTime != nil {
+		started = builder.Status.StartTime.Time
+	}
+	metadata.Timestamp = started.UTC().Format(outputTimestampLayout)
+	return metadata
+}
+
+// expandOutputTemplate expands the template text of field with metadata.
+func expandOutputTemplate(field, text string, metadata outputMetadata) (string, error) {
+	tmpl, err := template.New(field).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a valid template: %v", field, err)
+	}
+	var expanded strings.Builder
+	if err := tmpl.Execute(&expanded, metadata); err != nil {
+		return "", fmt.Errorf("%s can't be expanded: %v", field, err)
+	}
+	return expanded.String(), nil
+}
+
+// outputTags expands the tags of a registry output.
+func outputTags(field string, output *builderv1.RegistryOutput, metadata outputMetadata) ([]string, error) {
+	tags := make([]string, 0, len(output.Tags))
+	for i, text := range output.Tags {
+		tagField := fmt.Sprintf("%s.tags[%d]", field, i)
+		tag, err := expandOutputTemplate(tagField, text, metadata)
+		if err != nil {
+			return nil, err
+		}
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("%s %q expands to %q, which is not a valid tag", tagField, text, tag)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// usesCommit tells whether the template text of field depends on the commit,
+// i.e. expands differently without one.
+func usesCommit(field, text string, metadata outputMetadata) (bool, error) {
+	with, err := expandOutputTemplate(field, text, metadata)
+	if err != nil {
+		return false, err
+	}
+	metadata.Commit, metadata.ShortCommit = "", ""
+	without, err := expandOutputTemplate(field, text, metadata)
+	if err != nil {
+		return false, err
+	}
+	return with != without, nil
+}
+
+// validateOutputs checks spec.outputs as far as it can be without the
+// metadata of the build, tags that depend on it are checked once expanded.
+// Only git contexts have a commit to use in the templates.
+func validateOutputs(spec builderv1.BuilderSpec) *specError {
+	sample := outputMetadata{
+		Name:        "builder",
+		Commit:      strings.Repeat("0", 40),
+		ShortCommit: strings.Repeat("0", 7),
+		Timestamp:   time.Unix(0, 0).UTC().Format(outputTimestampLayout),
+	}
+	git := gitContext(spec)
+	checkCommit := func(field, text string) *specError {
+		if git {
+			return nil
+		}
+		uses, err := usesCommit(field, text, sample)
+		if err != nil {
+			return &specError{ReasonInvalidSpec, err.Error()}
+		}
+		if uses {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s %q uses the commit, which only git remote contexts have", field, text)}
+		}
+		return nil
+	}
+	names := make(map[string]bool, len(spec.Outputs))
+	for i, output := range spec.Outputs {
+		field := fmt.Sprintf("spec.outputs[%d]", i)
+		if output.Name == "" {
+			return &specError{ReasonInvalidSpec, field + ".name must be set"}
+		}
+		if names[output.Name] {
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.name %q is given more than once", field, output.Name)}
+		}
+		names[output.Name] = true
+
+		switch {
+		case (output.Registry == nil) == (output.Tarball == nil):
+			return &specError{ReasonInvalidSpec, fmt.Sprintf("%s must set exactly one of registry and tarball", field)}
+		case output.Registry != nil:
+			repository := output.Registry.Repository
+			if ref, err := registry.ParseReference(repository); err != nil || ref.Digest != "" || strings.LastIndex(repository, ":") > strings.LastIndex(repository, "/") {
+				return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.registry.repository %q is not a repository without tag or digest", field, repository)}
+			}
+			if len(output.Registry.Tags) == 0 {
+				return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.registry.tags must not be empty", field)}
+			}
+			if _, err := outputTags(field+".registry", output.Registry, sample); err != nil {
+				return &specError{ReasonInvalidSpec, err.Error()}
+			}
+			for j, tag := range output.Registry.Tags {
+				if invalid := checkCommit(fmt.Sprintf("%s.registry.tags[%d]", field, j), tag); invalid != nil {
+					return invalid
+				}
+			}
+		default:
+			if _, err := expandOutputTemplate(field+".tarball.url", output.Tarball.Url, sample); err != nil {
+				return &specError{ReasonInvalidSpec, err.Error()}
+			}
+			if !strings.HasPrefix(output.Tarball.Url, "s3://") {
+				return &specError{ReasonInvalidSpec, fmt.Sprintf("%s.tarball.url %q is not an s3:// url", field, output.Tarball.Url)}
+			}
+			if invalid := checkCommit(field+".tarball.url", output.Tarball.Url); invalid != nil {
+				return invalid
+			}
+		}
+	}
+	return nil
+}
+
+// pushOutputs pushes the image exported into the workspace to each of
+// spec.outputs and returns their status. Outputs pushed by an earlier
+// attempt are left alone, those that can't ever succeed are reported as
+// Failed. The error tells that some output should be tried again, the
+// statuses returned along with it are worth keeping all the same.
+func (c *Controller) pushOutputs(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) ([]builderv1.OutputStatus, error) {
+	previous := make(map[string]builderv1.OutputStatus, len(builder.Status.Outputs))
+	for _, status := range builder.Status.Outputs {
+		previous[status.Name] = status
+	}
+	metadata := outputMetadataFor(builder)
+
+	var statuses []builderv1.OutputStatus
+	var retry error
+	for i, output := range builder.Spec.Outputs {
+		if status, ok := previous[output.Name]; ok && status.State == OutputPushed {
+			statuses = append(statuses, status)
+			continue
+		}
+
+		field := fmt.Sprintf("spec.outputs[%d]", i)
+		var status *builderv1.OutputStatus
+		var err error
+		if output.Registry != nil {
+			status, err = c.pushRegistryOutput(ctx, builder, field, output, metadata, logger)
+		} else {
+			status, err = c.pushTarballOutput(ctx, builder, field, output, metadata, logger)
+		}
+
+		var failed *errOutputFailed
+		switch {
+		case errors.As(err, &failed):
+			message := fmt.Sprintf(MessageOutputFailed, output.Name, err)
+			c.recorder.Event(builder, corev1.EventTypeWarning, ReasonOutputFailed, message)
+			statuses = append(statuses, builderv1.OutputStatus{Name: output.Name, State: OutputFailed, Message: err.Error()})
+		case err != nil:
+			logger.Info("output will be pushed again", "builder", builder.Name, "output", output.Name, "err", err)
+			if retry == nil {
+				retry = fmt.Errorf("output %s: %w", output.Name, err)
+			}
+		default:
+			destination := status.Url
+			if len(status.References) > 0 {
+				destination = strings.Join(status.References, ", ")
+			}
+			c.recorder.Event(builder, corev1.EventTypeNormal, ReasonOutputPushed, fmt.Sprintf(MessageOutputPushed, output.Name, destination))
+			statuses = append(statuses, *status)
+		}
+	}
+	return statuses, retry
+}
+
+// pushRegistryOutput pushes the image under each tag of a registry output.
+// Blobs are mounted from the repository of spec.image when it is on the same
+// registry.
+func (c *Controller) pushRegistryOutput(ctx context.Context, builder *builderv1.Builder, field string, output builderv1.BuildOutput, metadata outputMetadata, logger klog.Logger) (*builderv1.OutputStatus, error) {
+	tags, err := outputTags(field+".registry", output.Registry, metadata)
+	if err != nil {
+		return nil, &errOutputFailed{err}
+	}
+	repository, err := registry.ParseReference(output.Registry.Repository)
+	if err != nil {
+		return nil, &errOutputFailed{err}
+	}
+	layout, err := registry.ReadLayout(filepath.Join(c.workspaceDir(builder), executor.ImageDirName))
+	if err != nil {
+		return nil, err
+	}
+	secretName := output.Registry.RegisterSecret
+	if secretName == "" {
+		secretName = builder.Spec.Image.RegisterSecret
+	}
+	client, err := newRegistryClient(ctx, c.kubeclientset, c.config, secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts registry.PushOptions
+	if primary, err := registry.ParseReference(imageReference(builder.Spec.Image)); err == nil && primary.Registry == repository.Registry {
+		opts.MountFrom = []string{primary.Repository}
+	}
+	status := &builderv1.OutputStatus{Name: output.Name, State: OutputPushed}
+	for _, tag := range tags {
+		ref := repository
+		ref.Tag = tag
+		logger.Info("pushing output", "builder", builder.Name, "output", output.Name, "reference", ref)
+		digest, err := client.Push(ctx, ref, layout, opts)
+		if err != nil {
+			if registry.IsPermanent(err) {
+				return nil, &errOutputFailed{fmt.Errorf("push %s: %w", ref, err)}
+			}
+			return nil, err
+		}
+		status.References = append(status.References, ref.String())
+		status.Digest = digest
+	}
+	return status, nil
+}
+
+// pushTarballOutput uploads the image as an OCI image layout tar archive.
+// The archive is written to the workspace first, uploads need to know its
+// size.
+func (c *Controller) pushTarballOutput(ctx context.Context, builder *builderv1.Builder, field string, output builderv1.BuildOutput, metadata outputMetadata, logger klog.Logger) (*builderv1.OutputStatus, error) {
+	url, err := expandOutputTemplate(field+".tarball.url", output.Tarball.Url, metadata)
+	if err != nil {
+		return nil, &errOutputFailed{err}
+	}
+	auth, err := c.resolveAuth(ctx, output.Tarball.AuthConfigMap)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := registry.ReadLayout(filepath.Join(c.workspaceDir(builder), executor.ImageDirName))
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(c.workspaceDir(builder), outputTarballName)
+	defer os.Remove(path)
+	digest, err := writeLayoutTar(layout, path)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("uploading output", "builder", builder.Name, "output", output.Name, "url", url, "digest", digest)
+	err = downloader.Upload(ctx, url, path, downloaderPlugin.Options{Auth: auth, Timeout: c.config.DownloadTimeout})
+	var permanent *downloaderPlugin.PermanentError
+	if errors.As(err, &permanent) {
+		return nil, &errOutputFailed{fmt.Errorf("upload %s: %w", url, err)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &builderv1.OutputStatus{Name: output.Name, State: OutputPushed, Url: url, Digest: digest}, nil
+}
+
+// writeLayoutTar writes the layout as a tar archive to path and returns its
+// sha256 digest.
+func writeLayoutTar(layout *registry.Layout, path string) (string, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if err := layout.WriteTar(io.MultiWriter(f, h)); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// failedOutputs returns the names of the outputs reported as Failed.
+func failedOutputs(statuses []builderv1.OutputStatus) []string {
+	var names []string
+	for _, status := range statuses {
+		if status.State == OutputFailed {
+			names = append(names, status.Name)
+		}
+	}
+	return names
+}
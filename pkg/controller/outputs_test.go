@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+)
+
+const testCommit = "0123456789abcdef0123456789abcdef01234567"
+
+// newOutputBuilder returns a Builder whose remote context of type at url was
+// downloaded at testCommit as revision.
+func newOutputBuilder(contextType, url string, outputs ...builderv1.BuildOutput) *builderv1.Builder {
+	builder := newTestBuilder()
+	builder.Spec.RemoteContext = builderv1.RemoteContext{Type: contextType, ContentUrl: url}
+	builder.Spec.Outputs = outputs
+	start := metav1.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	builder.Status.StartTime = &start
+	builder.Status.Context = &builderv1.ContextStatus{Revision: testCommit}
+	return builder
+}
+
+func TestOutputMetadataFor(t *testing.T) {
+	tests := []struct {
+		name        string
+		contextType string
+		url         string
+		commit      string
+	}{
+		{"git url", "", "git://example.com/app.git#main", testCommit},
+		{"git type", "git", "https://example.com/app.git#main", testCommit},
+		{"http context with an etag", "", "https://example.com/context.tar.gz", ""},
+		{"s3 context with an etag", "", "s3://bucket/context.tar.gz", ""},
+		{"no remote context", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := outputMetadataFor(newOutputBuilder(tt.contextType, tt.url))
+			short := tt.commit
+			if short != "" {
+				short = short[:7]
+			}
+			want := outputMetadata{Name: "app", Commit: tt.commit, ShortCommit: short, Timestamp: "20240102030405"}
+			if metadata != want {
+				t.Errorf("outputMetadataFor() = %+v, want %+v", metadata, want)
+			}
+		})
+	}
+}
+
+func TestOutputTags(t *testing.T) {
+	metadata := outputMetadata{Name: "app", Commit: testCommit, ShortCommit: testCommit[:7], Timestamp: "20240102030405"}
+
+	tests := []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{"plain tag", []string{"latest"}, []string{"latest"}, false},
+		{"metadata", []string{"{{.Name}}-{{.ShortCommit}}", "{{.Timestamp}}", "{{.Commit}}"}, []string{"app-0123456", "20240102030405", testCommit}, false},
+		{"invalid template", []string{"{{.Name"}, nil, true},
+		{"unknown field", []string{"{{.Branch}}"}, nil, true},
+		{"expands to an invalid tag", []string{"{{.Name}}/{{.Commit}}"}, nil, true},
+		{"expands to nothing", []string{"{{if false}}x{{end}}"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := outputTags("spec.outputs[0].registry", &builderv1.RegistryOutput{Repository: "registry.example.com/app", Tags: tt.tags}, metadata)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("outputTags() = %v, want an error", got)
+				}
+				if !strings.Contains(err.Error(), "spec.outputs[0].registry.tags[0]") {
+					t.Errorf("error %q does not name the tag", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("outputTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOutputs(t *testing.T) {
+	registryOutput := func(tags ...string) builderv1.BuildOutput {
+		return builderv1.BuildOutput{Name: "mirror", Registry: &builderv1.RegistryOutput{Repository: "registry.example.com/mirror", Tags: tags}}
+	}
+	tarballOutput := func(url string) builderv1.BuildOutput {
+		return builderv1.BuildOutput{Name: "archive", Tarball: &builderv1.TarballOutput{Url: url}}
+	}
+	const (
+		git  = "git://example.com/app.git#main"
+		http = "https://example.com/context.tar.gz"
+		s3   = "s3://bucket/context.tar.gz"
+	)
+
+	tests := []struct {
+		name    string
+		url     string
+		outputs []builderv1.BuildOutput
+		valid   bool
+	}{
+		{"no outputs", http, nil, true},
+		{"commit tag of a git context", git, []builderv1.BuildOutput{registryOutput("{{.ShortCommit}}")}, true},
+		{"commit url of a git context", git, []builderv1.BuildOutput{tarballOutput("s3://bucket/{{.Commit}}.tar")}, true},
+		{"tags without the commit", s3, []builderv1.BuildOutput{registryOutput("{{.Name}}-{{.Timestamp}}", "latest")}, true},
+		{"commit tag of an http context", http, []builderv1.BuildOutput{registryOutput("latest", "{{.Commit}}")}, false},
+		{"short commit tag of an s3 context", s3, []builderv1.BuildOutput{registryOutput("v-{{.ShortCommit}}")}, false},
+		{"commit url of an s3 context", s3, []builderv1.BuildOutput{tarballOutput("s3://bucket/{{.Commit}}.tar")}, false},
+		{"commit tag without a remote context", "", []builderv1.BuildOutput{registryOutput("{{.Commit}}")}, false},
+		{"no name", http, []builderv1.BuildOutput{{Registry: registryOutput("latest").Registry}}, false},
+		{"duplicate name", http, []builderv1.BuildOutput{registryOutput("a"), registryOutput("b")}, false},
+		{"registry and tarball", http, []builderv1.BuildOutput{{Name: "both", Registry: registryOutput("latest").Registry, Tarball: tarballOutput("s3://bucket/app.tar").Tarball}}, false},
+		{"repository with a tag", http, []builderv1.BuildOutput{{Name: "mirror", Registry: &builderv1.RegistryOutput{Repository: "registry.example.com/mirror:v1", Tags: []string{"latest"}}}}, false},
+		{"no tags", http, []builderv1.BuildOutput{registryOutput()}, false},
+		{"invalid tag", http, []builderv1.BuildOutput{registryOutput("{{.Name}}:latest")}, false},
+		{"tarball not on s3", http, []builderv1.BuildOutput{tarballOutput("https://example.com/app.tar")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invalid := validateOutputs(newOutputBuilder("", tt.url, tt.outputs...).Spec)
+			if tt.valid {
+				if invalid != nil {
+					t.Errorf("validateOutputs() = %+v", invalid)
+				}
+				return
+			}
+			if invalid == nil || invalid.reason != ReasonInvalidSpec || invalid.message == "" {
+				t.Errorf("validateOutputs() = %+v, want reason %s", invalid, ReasonInvalidSpec)
+			}
+		})
+	}
+}
+
+func TestPushOutputsReportsFailedOutputs(t *testing.T) {
+	// the commit tag was accepted before the context turned out not to be git
+	builder := newOutputBuilder("", "https://example.com/context.tar.gz",
+		builderv1.BuildOutput{Name: "pushed", Registry: &builderv1.RegistryOutput{Repository: "registry.example.com/pushed", Tags: []string{"latest"}}},
+		builderv1.BuildOutput{Name: "commit", Registry: &builderv1.RegistryOutput{Repository: "registry.example.com/commit", Tags: []string{"{{.Commit}}"}}},
+	)
+	pushed := builderv1.OutputStatus{Name: "pushed", State: OutputPushed, References: []string{"registry.example.com/pushed:latest"}, Digest: sha256Digest(nil)}
+	builder.Status.Outputs = []builderv1.OutputStatus{pushed}
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{recorder: recorder, config: Config{WorkspaceRoot: t.TempDir()}}
+
+	statuses, err := c.pushOutputs(context.Background(), builder, funcr.New(func(prefix, args string) {}, funcr.Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("statuses %+v, want one per output", statuses)
+	}
+	if statuses[0].Name != pushed.Name || statuses[0].State != OutputPushed || statuses[0].Digest != pushed.Digest {
+		t.Errorf("status %+v of the pushed output, want it kept", statuses[0])
+	}
+	if statuses[1].Name != "commit" || statuses[1].State != OutputFailed || !strings.Contains(statuses[1].Message, "not a valid tag") {
+		t.Errorf("status %+v, want the commit output Failed for its tag", statuses[1])
+	}
+	if failed := failedOutputs(statuses); len(failed) != 1 || failed[0] != "commit" {
+		t.Errorf("failedOutputs() = %v, want the commit output", failed)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning "+ReasonOutputFailed) || !strings.Contains(event, "commit") {
+		t.Errorf("event %q, want a %s warning for the commit output", event, ReasonOutputFailed)
+	}
+}
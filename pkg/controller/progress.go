@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+)
+
+// progressInterval is the minimum time between two progress updates of the
+// same Builder.
+const progressInterval = 5 * time.Second
+
+// downloadProgress returns a progress callback for downloads, it patches the
+// progress into the status of the Builder at most once per progressInterval.
+func (c *Controller) downloadProgress(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) func(written, total int64) {
+	var (
+		mu   sync.Mutex
+		last time.Time
+	)
+	return func(written, total int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(last) < progressInterval {
+			return
+		}
+		last = time.Now()
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"status": map[string]interface{}{
+				"progress": formatProgress(written, total),
+			},
+		})
+		if err != nil {
+			return
+		}
+		_, err = c.client.BuilderV1().Builders().Patch(ctx, builder.Name, types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+		if err != nil {
+			logger.V(4).Info("failed to report download progress", "builder", builder.Name, "err", err)
+		}
+	}
+}
+
+// formatProgress renders the downloaded and the total bytes, total is
+// negative when unknown.
+func formatProgress(written, total int64) string {
+	if total < 0 {
+		return formatBytes(written)
+	}
+	return formatBytes(written) + "/" + formatBytes(total)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"context"
+	"path/filepath"
+
+	"k8s.io/klog/v2"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	"builder/pkg/executor"
+	"builder/pkg/registry"
+)
+
+// exportsImage tells whether executors hand the image over in the workspace
+// for the controller to push, which needs the workspace on a claim the build
+// Jobs can write to.
+func (c *Controller) exportsImage() bool {
+	return c.config.WorkspaceClaim != ""
+}
+
+// pushImage pushes the image exported into the workspace of the Builder with
+// the credentials of spec.image.registerSecret and returns the digest of the
+// pushed manifest.
+func (c *Controller) pushImage(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) (string, error) {
+	ref, err := registry.ParseReference(imageReference(builder.Spec.Image))
+	if err != nil {
+		return "", err
+	}
+	layout, err := registry.ReadLayout(filepath.Join(c.workspaceDir(builder), executor.ImageDirName))
+	if err != nil {
+		return "", err
+	}
+	client, err := newRegistryClient(ctx, c.kubeclientset, c.config, builder.Spec.Image.RegisterSecret)
+	if err != nil {
+		return "", err
+	}
+
+	logger.Info("pushing image", "builder", builder.Name, "reference", ref, "digest", layout.Root.Digest)
+	return client.Push(ctx, ref, layout, registry.PushOptions{})
+}
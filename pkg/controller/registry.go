@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"builder/pkg/registry"
+)
+
+// newRegistryClient returns a registry client logging in with the
+// kubernetes.io/dockerconfigjson Secret of the given name in the controller
+// namespace, anonymous when secretName is empty.
+func newRegistryClient(ctx context.Context, kubeclientset kubernetes.Interface, config Config, secretName string) (*registry.Client, error) {
+	keychain := registry.Anonymous
+	if secretName != "" {
+		secret, err := kubeclientset.CoreV1().Secrets(config.Namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if secret.Type != corev1.SecretTypeDockerConfigJson {
+			return nil, fmt.Errorf("secret %s/%s is of type %s, not %s", config.Namespace, secretName, secret.Type, corev1.SecretTypeDockerConfigJson)
+		}
+		keychain, err = registry.ParseDockerConfig(secret.Data[corev1.DockerConfigJsonKey])
+		if err != nil {
+			return nil, fmt.Errorf("secret %s/%s: %w", config.Namespace, secretName, err)
+		}
+	}
+	return registry.New(registry.Options{
+		Keychain: keychain,
+		Insecure: config.InsecureRegistries,
+		Timeout:  config.RegistryTimeout,
+		Retries:  config.RegistryRetries,
+		RootCAs:  config.RegistryCAs,
+	}), nil
+}
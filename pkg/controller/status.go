@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+)
+
+const (
+	// ConditionContextReady tells whether the build context is prepared.
+	ConditionContextReady = "ContextReady"
+	// ConditionBuilt tells whether the executor built the image.
+	ConditionBuilt = "Built"
+	// ConditionPushed tells whether the image reached the registry.
+	ConditionPushed = "Pushed"
+	// ConditionImageCreated tells whether the Image resource was created.
+	ConditionImageCreated = "ImageCreated"
+	// ConditionReady tells whether the Builder finished successfully.
+	ConditionReady = "Ready"
+
+	// ReasonPending is used for conditions of phases that did not run yet
+	ReasonPending = "Pending"
+	// ReasonInProgress is used for the Ready condition of a running Builder
+	ReasonInProgress = "InProgress"
+)
+
+// phaseConditions maps each phase to the condition its completion sets.
+var phaseConditions = map[string]string{
+	ContextGetting:      ConditionContextReady,
+	ImageBuilding:       ConditionBuilt,
+	ImagePushing:        ConditionPushed,
+	ImageSourceCreating: ConditionImageCreated,
+}
+
+// setState moves the Builder to state. reason and message explain why the
+// current phase ended, they are recorded on the condition of that phase and
+// on the Builder. The phase history, timestamps and Ready are kept up to
+// date along with it.
+func setState(builder *builderv1.Builder, state, reason, message string) {
+	status := &builder.Status
+	now := metav1.Now()
+
+	if status.State == "" {
+		// a new Builder, none of its phases ran yet
+		for _, condition := range []string{ConditionContextReady, ConditionBuilt, ConditionPushed, ConditionImageCreated} {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               condition,
+				Status:             metav1.ConditionUnknown,
+				ObservedGeneration: builder.Generation,
+				Reason:             ReasonPending,
+			})
+		}
+	}
+
+	if condition, ok := phaseConditions[status.State]; ok && status.State != state {
+		conditionStatus := metav1.ConditionTrue
+		if state == Failed {
+			conditionStatus = metav1.ConditionFalse
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               condition,
+			Status:             conditionStatus,
+			ObservedGeneration: builder.Generation,
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+
+	if n := len(status.Phases); n > 0 && status.Phases[n-1].CompletionTime == nil && status.Phases[n-1].Phase != state {
+		status.Phases[n-1].CompletionTime = &now
+	}
+
+	ready := metav1.Condition{
+		Type:               ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: builder.Generation,
+		Reason:             ReasonInProgress,
+		Message:            fmt.Sprintf("Builder is in state %s", state),
+	}
+	switch state {
+	case Finished, Failed:
+		status.CompletionTime = &now
+		if status.StartTime != nil {
+			status.Duration = now.Sub(status.StartTime.Time).Round(time.Second).String()
+		}
+		ready.Reason = reason
+		ready.Message = message
+		if state == Finished {
+			ready.Status = metav1.ConditionTrue
+		}
+	default:
+		if status.State != state {
+			status.Phases = append(status.Phases, builderv1.PhaseStatus{Phase: state, StartTime: now})
+		}
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+
+	status.State = state
+	status.Reason = reason
+	status.Message = message
+	status.ObservedGeneration = builder.Generation
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+)
+
+// patchStatus applies mutate to the status of the Builder and writes the
+// change as a merge patch of the status subresource. The patch is bound to the
+// resourceVersion it was computed from, on a conflict the latest Builder is
+// fetched and mutate applied to it again.
+func (c *Controller) patchStatus(ctx context.Context, builder *builderv1.Builder, mutate func(*builderv1.Builder)) (*builderv1.Builder, error) {
+	current := builder
+	var updated *builderv1.Builder
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if current == nil {
+			latest, err := c.client.BuilderV1().Builders().Get(ctx, builder.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			current = latest
+		}
+		modified := current.DeepCopy()
+		mutate(modified)
+		if equality.Semantic.DeepEqual(current.Status, modified.Status) {
+			updated = current
+			return nil
+		}
+
+		patch, err := statusPatch(current, modified)
+		if err != nil {
+			return err
+		}
+		updated, err = c.client.BuilderV1().Builders().Patch(ctx, builder.Name, types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+		if errors.IsConflict(err) {
+			current = nil
+		}
+		return err
+	})
+	return updated, err
+}
+
+// statusPatch returns the merge patch turning the status of original into the
+// one of modified, fields dropped from the status are set to null.
+func statusPatch(original, modified *builderv1.Builder) ([]byte, error) {
+	before, err := json.Marshal(map[string]interface{}{"status": original.Status})
+	if err != nil {
+		return nil, err
+	}
+	after, err := json.Marshal(map[string]interface{}{"status": modified.Status})
+	if err != nil {
+		return nil, err
+	}
+	diff, err := jsonpatch.CreateMergePatch(before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(diff, &patch); err != nil {
+		return nil, err
+	}
+	patch["metadata"] = map[string]interface{}{"resourceVersion": original.ResourceVersion}
+	return json.Marshal(patch)
+}
@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	core "k8s.io/client-go/testing"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	"builder/pkg/client/generated/clientset/versioned/fake"
+)
+
+func newTestBuilder() *builderv1.Builder {
+	return &builderv1.Builder{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", ResourceVersion: "1", Generation: 1},
+		Spec:       builderv1.BuilderSpec{DockerFileString: "FROM scratch\n"},
+	}
+}
+
+// statusPatches returns the patches of the status subresource the client sent.
+func statusPatches(client *fake.Clientset) []core.PatchAction {
+	var patches []core.PatchAction
+	for _, action := range client.Actions() {
+		if patch, ok := action.(core.PatchAction); ok && patch.GetSubresource() == "status" {
+			patches = append(patches, patch)
+		}
+	}
+	return patches
+}
+
+func TestPatchStatusPersistsTransitions(t *testing.T) {
+	builder := newTestBuilder()
+	client := fake.NewSimpleClientset(builder)
+	c := &Controller{client: client}
+
+	transitions := []struct {
+		state  string
+		reason string
+		phases int
+		ready  metav1.ConditionStatus
+	}{
+		{ContextGetting, ReasonStarted, 1, metav1.ConditionFalse},
+		{ImageBuilding, ReasonContextReady, 2, metav1.ConditionFalse},
+		{ImagePushing, ReasonBuildSucceeded, 3, metav1.ConditionFalse},
+		{ImageSourceCreating, ReasonPushSucceeded, 4, metav1.ConditionFalse},
+		{Finished, ReasonFinished, 4, metav1.ConditionTrue},
+	}
+	for _, tt := range transitions {
+		updated, err := c.patchStatus(context.Background(), builder, func(builder *builderv1.Builder) {
+			setState(builder, tt.state, tt.reason, "")
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.state, err)
+		}
+
+		stored, err := client.BuilderV1().Builders().Get(context.Background(), builder.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status.State != tt.state || stored.Status.Reason != tt.reason {
+			t.Errorf("stored state %s/%s, want %s/%s", stored.Status.State, stored.Status.Reason, tt.state, tt.reason)
+		}
+		if updated.Status.State != tt.state {
+			t.Errorf("returned state %s, want %s", updated.Status.State, tt.state)
+		}
+		if len(stored.Status.Phases) != tt.phases {
+			t.Errorf("%s: %d phases recorded, want %d", tt.state, len(stored.Status.Phases), tt.phases)
+		}
+		if ready := meta.FindStatusCondition(stored.Status.Conditions, ConditionReady); ready == nil || ready.Status != tt.ready {
+			t.Errorf("%s: Ready condition is %v, want %s", tt.state, ready, tt.ready)
+		}
+		if stored.Spec.DockerFileString != builder.Spec.DockerFileString {
+			t.Errorf("%s: the spec was changed by a status patch", tt.state)
+		}
+		builder = stored
+	}
+
+	if patches := statusPatches(client); len(patches) != len(transitions) {
+		t.Errorf("%d status patches sent, want %d", len(patches), len(transitions))
+	}
+}
+
+func TestPatchStatusRetriesConflicts(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflicts int
+		wantErr   bool
+		mutations int
+	}{
+		{"no conflict", 0, false, 1},
+		{"one conflict", 1, false, 2},
+		{"conflicts until the retries run out", 10, true, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stale := newTestBuilder()
+			// someone else wrote the status since the Builder was read
+			latest := stale.DeepCopy()
+			latest.ResourceVersion = "2"
+			latest.Status.Progress = "10 MiB downloaded"
+			client := fake.NewSimpleClientset(latest)
+
+			conflicts := tt.conflicts
+			client.PrependReactor("patch", "builders", func(action core.Action) (bool, runtime.Object, error) {
+				if conflicts == 0 {
+					return false, nil, nil
+				}
+				conflicts--
+				return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "builder.hjjzs.xyz", Resource: "builders"}, stale.Name, nil)
+			})
+			c := &Controller{client: client}
+
+			mutations := 0
+			_, err := c.patchStatus(context.Background(), stale, func(builder *builderv1.Builder) {
+				mutations++
+				builder.Status.State = ImageBuilding
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("patchStatus() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !apierrors.IsConflict(err) {
+				t.Errorf("patchStatus() = %v, want a conflict", err)
+			}
+			if mutations != tt.mutations {
+				t.Errorf("mutate ran %d times, want %d", mutations, tt.mutations)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			stored, err := client.BuilderV1().Builders().Get(context.Background(), stale.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status.State != ImageBuilding {
+				t.Errorf("stored state %q, want %q", stored.Status.State, ImageBuilding)
+			}
+			if stored.Status.Progress != latest.Status.Progress {
+				t.Errorf("progress written meanwhile was lost: %q", stored.Status.Progress)
+			}
+		})
+	}
+}
+
+func TestPatchStatusSkipsNoop(t *testing.T) {
+	builder := newTestBuilder()
+	builder.Status.State = ImageBuilding
+	client := fake.NewSimpleClientset(builder)
+	c := &Controller{client: client}
+
+	updated, err := c.patchStatus(context.Background(), builder, func(builder *builderv1.Builder) {
+		builder.Status.State = ImageBuilding
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != builder {
+		t.Error("an unchanged status did not return the Builder as is")
+	}
+	if patches := statusPatches(client); len(patches) != 0 {
+		t.Errorf("%d status patches sent for an unchanged status", len(patches))
+	}
+}
+
+func TestStatusPatch(t *testing.T) {
+	original := newTestBuilder()
+	original.ResourceVersion = "42"
+	original.Status.State = ImagePushing
+	original.Status.Progress = "pushing"
+	modified := original.DeepCopy()
+	modified.Status.State = Failed
+	modified.Status.Progress = ""
+
+	data, err := statusPatch(original, modified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patch struct {
+		Metadata map[string]interface{} `json:"metadata"`
+		Status   map[string]interface{} `json:"status"`
+		Spec     interface{}            `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if patch.Metadata["resourceVersion"] != "42" {
+		t.Errorf("patch is not bound to the resourceVersion: %s", data)
+	}
+	if patch.Status["state"] != Failed {
+		t.Errorf("patch does not set the state: %s", data)
+	}
+	if value, ok := patch.Status["progress"]; !ok || value != nil {
+		t.Errorf("patch does not clear the progress: %s", data)
+	}
+	if patch.Spec != nil {
+		t.Errorf("patch touches the spec: %s", data)
+	}
+}
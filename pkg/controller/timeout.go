@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+)
+
+// buildTimeout returns how long the Builder may take, spec.buildTimeout is
+// given in minutes.
+func (c *Controller) buildTimeout(builder *builderv1.Builder) time.Duration {
+	if builder.Spec.BuildTimeout > 0 {
+		return time.Duration(builder.Spec.BuildTimeout) * time.Minute
+	}
+	return c.config.DefaultBuildTimeout
+}
+
+// buildDeadline returns when the Builder times out, false if it has not
+// started yet or has no timeout.
+func (c *Controller) buildDeadline(builder *builderv1.Builder) (time.Time, bool) {
+	timeout := c.buildTimeout(builder)
+	if builder.Status.StartTime == nil || timeout <= 0 {
+		return time.Time{}, false
+	}
+	return builder.Status.StartTime.Add(timeout), true
+}
+
+// startBuilder moves a new Builder to ContextGetting, which starts its
+// timeout.
+func (c *Controller) startBuilder(ctx context.Context, builder *builderv1.Builder) error {
+	_, err := c.patchStatus(ctx, builder, func(builder *builderv1.Builder) {
+		now := metav1.Now()
+		builder.Status.StartTime = &now
+		setState(builder, ContextGetting, ReasonStarted, MessageStarted)
+	})
+	return err
+}
+
+// timeoutBuilder stops the running build of a Builder that exceeded its
+// timeout and moves it to Failed. Downloads stop on their own as they run
+// with the deadline of the Builder.
+func (c *Controller) timeoutBuilder(ctx context.Context, builder *builderv1.Builder, logger klog.Logger) error {
+	if exec, build, err := c.newBuild(builder); err == nil {
+		if err := exec.Cancel(ctx, build); err != nil {
+			return fmt.Errorf("failed to cancel build: %w", err)
+		}
+	}
+
+	message := fmt.Sprintf(MessageTimeout, c.buildTimeout(builder), builder.Status.State)
+	logger.Info("builder timed out", "builder", builder.Name, "state", builder.Status.State)
+	return c.failBuilder(ctx, builder, ReasonTimeout, message)
+}
@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	imagev1 "builder/pkg/apis/image/v1"
+	"builder/pkg/client/generated/clientset/versioned/fake"
+	"builder/pkg/executor"
+)
+
+// delayQueue records the delays Builders are put back on the workqueue with.
+type delayQueue struct {
+	workqueue.TypedRateLimitingInterface[cache.ObjectName]
+
+	mu     sync.Mutex
+	delays []time.Duration
+}
+
+func (q *delayQueue) AddAfter(item cache.ObjectName, duration time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.delays = append(q.delays, duration)
+}
+
+// runningExecutor reports every build as running, its status blocks until
+// the context is done while block is set. Cancel fails with cancelErr.
+type runningExecutor struct {
+	block     bool
+	cancelErr error
+	cancels   int
+}
+
+func (e *runningExecutor) Start(ctx context.Context, build *executor.Build) error { return nil }
+
+func (e *runningExecutor) Status(ctx context.Context, build *executor.Build) (*executor.Status, error) {
+	if e.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &executor.Status{Phase: executor.Running}, nil
+}
+
+func (e *runningExecutor) Logs(ctx context.Context, build *executor.Build) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (e *runningExecutor) Cancel(ctx context.Context, build *executor.Build) error {
+	e.cancels++
+	return e.cancelErr
+}
+
+func (e *runningExecutor) GetType() executor.ExecutorType { return "running" }
+
+// newTimedBuilder returns a Builder in state that started elapsed ago and may
+// take timeout minutes.
+func newTimedBuilder(state string, elapsed time.Duration, timeout int) *builderv1.Builder {
+	builder := newTestBuilder()
+	builder.Finalizers = []string{BuilderFinalizer}
+	builder.Spec.Executor = "running"
+	builder.Spec.BuildTimeout = timeout
+	builder.Spec.Image = imagev1.ImageSpec{ImageUrl: "registry.example.com/app", ImageTag: "v1"}
+	path := []string{ContextGetting, ImageBuilding, ImagePushing, ImageSourceCreating, Finished}
+	if state == Failed {
+		path = []string{ContextGetting, Failed}
+	}
+	for _, s := range path {
+		setState(builder, s, ReasonStarted, "")
+		if s == state {
+			break
+		}
+	}
+	start := metav1.NewTime(time.Now().Add(-elapsed))
+	builder.Status.StartTime = &start
+	return builder
+}
+
+func newTimeoutController(builder *builderv1.Builder, exec *runningExecutor) (*Controller, *fake.Clientset, *delayQueue, *record.FakeRecorder) {
+	client := fake.NewSimpleClientset(builder)
+	queue := &delayQueue{}
+	recorder := record.NewFakeRecorder(10)
+	return &Controller{
+		client:     client,
+		recorder:   recorder,
+		executors:  map[executor.ExecutorType]executor.BuildExecutor{"running": exec},
+		syncCancel: make(map[string]context.CancelFunc),
+		workqueue:  queue,
+	}, client, queue, recorder
+}
+
+func TestSyncHandlerTimesOut(t *testing.T) {
+	for _, state := range []string{ContextGetting, ImageBuilding, ImagePushing, ImageSourceCreating} {
+		t.Run(state, func(t *testing.T) {
+			builder := newTimedBuilder(state, 2*time.Hour, 60)
+			exec := &runningExecutor{}
+			c, client, queue, recorder := newTimeoutController(builder, exec)
+
+			if err := c.syncHandler(context.Background(), cache.ObjectName{Name: builder.Name}); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := client.BuilderV1().Builders().Get(context.Background(), builder.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status.State != Failed || stored.Status.Reason != ReasonTimeout {
+				t.Fatalf("state %s/%s, want %s/%s", stored.Status.State, stored.Status.Reason, Failed, ReasonTimeout)
+			}
+			if !strings.Contains(stored.Status.Message, "1h0m0s") || !strings.Contains(stored.Status.Message, state) {
+				t.Errorf("message %q does not name the timeout and the state", stored.Status.Message)
+			}
+			if ready := meta.FindStatusCondition(stored.Status.Conditions, ConditionReady); ready == nil ||
+				ready.Status != metav1.ConditionFalse || ready.Reason != ReasonTimeout {
+				t.Errorf("Ready condition is %+v, want False with reason %s", ready, ReasonTimeout)
+			}
+			if phase := meta.FindStatusCondition(stored.Status.Conditions, phaseConditions[state]); phase == nil ||
+				phase.Status != metav1.ConditionFalse || phase.Reason != ReasonTimeout {
+				t.Errorf("%s condition is %+v, want False with reason %s", phaseConditions[state], phase, ReasonTimeout)
+			}
+			if stored.Status.CompletionTime == nil {
+				t.Error("completion time is not recorded")
+			}
+			if exec.cancels != 1 {
+				t.Errorf("build was cancelled %d times, want 1", exec.cancels)
+			}
+			if event := <-recorder.Events; !strings.HasPrefix(event, "Warning "+ReasonTimeout) {
+				t.Errorf("event %q, want a %s warning", event, ReasonTimeout)
+			}
+			if len(queue.delays) != 0 {
+				t.Errorf("a timed out Builder was requeued after %v", queue.delays)
+			}
+		})
+	}
+}
+
+func TestSyncHandlerBeforeDeadline(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		elapsed  time.Duration
+		timeout  int
+		requeued bool
+	}{
+		{"deadline ahead", ImageBuilding, 20 * time.Minute, 60, true},
+		{"no timeout", ImageBuilding, 20 * time.Hour, 0, false},
+		{"finished before the deadline passed", Finished, 2 * time.Hour, 60, false},
+		{"failed before the deadline passed", Failed, 2 * time.Hour, 60, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := newTimedBuilder(tt.state, tt.elapsed, tt.timeout)
+			exec := &runningExecutor{}
+			c, client, queue, recorder := newTimeoutController(builder, exec)
+
+			if err := c.syncHandler(context.Background(), cache.ObjectName{Name: builder.Name}); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := client.BuilderV1().Builders().Get(context.Background(), builder.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status.State != tt.state || exec.cancels != 0 || len(recorder.Events) != 0 {
+				t.Errorf("state %s with %d cancels and %d events, want %s untouched", stored.Status.State, exec.cancels, len(recorder.Events), tt.state)
+			}
+			if (len(queue.delays) == 1) != tt.requeued {
+				t.Fatalf("requeued after %v, want requeued %v", queue.delays, tt.requeued)
+			}
+			// the Builder comes back once its deadline passed
+			remaining := time.Duration(tt.timeout)*time.Minute - tt.elapsed
+			if tt.requeued && (queue.delays[0] > remaining || queue.delays[0] < remaining-time.Minute) {
+				t.Errorf("requeued after %v, want about %v", queue.delays[0], remaining)
+			}
+		})
+	}
+}
+
+func TestSyncHandlerDeadlinePassesDuringSync(t *testing.T) {
+	// the deadline passes while the executor is asked for the status
+	builder := newTimedBuilder(ImageBuilding, time.Minute-100*time.Millisecond, 1)
+	exec := &runningExecutor{block: true}
+	c, client, _, _ := newTimeoutController(builder, exec)
+
+	if err := c.syncHandler(context.Background(), cache.ObjectName{Name: builder.Name}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := client.BuilderV1().Builders().Get(context.Background(), builder.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.State != Failed || stored.Status.Reason != ReasonTimeout {
+		t.Errorf("state %s/%s, want %s/%s", stored.Status.State, stored.Status.Reason, Failed, ReasonTimeout)
+	}
+	if exec.cancels != 1 {
+		t.Errorf("build was cancelled %d times, want 1", exec.cancels)
+	}
+}
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	"builder/pkg/downloader"
+	"builder/pkg/downloader/archive"
+	"builder/pkg/downloader/downloaderPlugin"
+)
+
+// workspaceDir returns the directory everything fetched for the Builder is
+// stored in.
+func (c *Controller) workspaceDir(builder *builderv1.Builder) string {
+	return filepath.Join(c.config.WorkspaceRoot, builder.Name)
+}
+
+// prepareWorkspace creates an empty workspace for the Builder, leftovers of
+// an earlier attempt are removed.
+func (c *Controller) prepareWorkspace(builder *builderv1.Builder) (string, error) {
+	dir := c.workspaceDir(builder)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// downloadFailureReason returns the status reason for a failed download and
+// whether it is permanent, i.e. retrying can't help because the spec asks for
+// something unsupported or the content was rejected.
+func downloadFailureReason(err error) (string, bool) {
+	var sizeErr *downloaderPlugin.SizeLimitError
+	var digestErr *downloaderPlugin.DigestMismatchError
+	var signatureErr *downloader.SignatureError
+	var permanentErr *downloaderPlugin.PermanentError
+	switch {
+	case errors.As(err, &digestErr):
+		return ReasonDigestMismatch, true
+	case errors.As(err, &signatureErr):
+		return ReasonSignatureInvalid, true
+	case errors.Is(err, downloaderPlugin.ErrUnsupportedProtocol),
+		errors.Is(err, archive.ErrLimitExceeded),
+		errors.As(err, &sizeErr),
+		errors.As(err, &permanentErr):
+		return ReasonContextFailed, true
+	}
+	return "", false
+}
+
+// validateContextSource rejects remote contexts that rely on what only the
+// controller does while it prepares the workspace. Without a workspace claim
+// build Jobs fetch spec.remoteContext.contentUrl themselves, so digests,
+// signatures and credentials the controller checked wouldn't apply to what
+// is actually built.
+func (c *Controller) validateContextSource(spec builderv1.BuilderSpec) *specError {
+	remote := spec.RemoteContext
+	if c.config.WorkspaceClaim != "" || remote.ContentUrl == "" {
+		return nil
+	}
+	var fields []string
+	if remote.Sha256 != "" {
+		fields = append(fields, "sha256")
+	}
+	if remote.Sha512 != "" {
+		fields = append(fields, "sha512")
+	}
+	if remote.Signature != nil {
+		fields = append(fields, "signature")
+	}
+	if remote.AuthConfigMap != "" {
+		fields = append(fields, "authConfigMap")
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return &specError{ReasonInvalidSpec, fmt.Sprintf("spec.remoteContext.%s needs a workspace claim, without one the build fetches the context itself and skips the controller's checks", strings.Join(fields, ", "))}
+}
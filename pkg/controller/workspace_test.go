@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+	imagev1 "builder/pkg/apis/image/v1"
+	"builder/pkg/client/generated/clientset/versioned/fake"
+	"builder/pkg/executor"
+)
+
+// newContextController returns a controller preparing workspaces below a
+// temporary directory, with a workspace claim unless claim is empty.
+func newContextController(t *testing.T, builder *builderv1.Builder, claim string) (*Controller, *fake.Clientset) {
+	t.Helper()
+	client := fake.NewSimpleClientset(builder)
+	return &Controller{
+		client:        client,
+		kubeclientset: kubefake.NewSimpleClientset(),
+		recorder:      record.NewFakeRecorder(100),
+		config: Config{
+			WorkspaceRoot:   t.TempDir(),
+			WorkspaceClaim:  claim,
+			DefaultExecutor: "kaniko",
+		},
+		executors: executor.NewExecutors(executor.Env{WorkspaceClaim: claim}),
+	}, client
+}
+
+func TestContextVerificationWithoutClaim(t *testing.T) {
+	const published = "FROM scratch\n"
+	sum := sha256.Sum256([]byte(published))
+	digest := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		claim    string
+		sha256   string
+		served   string
+		state    string
+		reason   string
+		requests int32
+	}{
+		{"tampered context without a claim", "", digest, "FROM evil\n", Failed, ReasonInvalidSpec, 0},
+		{"verified context without a claim", "", digest, published, Failed, ReasonInvalidSpec, 0},
+		{"unverified context without a claim", "", "", "FROM evil\n", ImageBuilding, ReasonContextReady, 1},
+		{"tampered context with a claim", "workspace", digest, "FROM evil\n", Failed, ReasonDigestMismatch, 1},
+		{"verified context with a claim", "workspace", digest, published, ImageBuilding, ReasonContextReady, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.Write([]byte(tt.served))
+			}))
+			defer srv.Close()
+
+			builder := &builderv1.Builder{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", ResourceVersion: "1", Generation: 1},
+				Spec: builderv1.BuilderSpec{
+					Image:         imagev1.ImageSpec{ImageUrl: "registry.example.com/app", ImageTag: "v1"},
+					RemoteContext: builderv1.RemoteContext{ContentUrl: srv.URL + "/Dockerfile", Type: "http", Sha256: tt.sha256},
+				},
+			}
+			c, client := newContextController(t, builder, tt.claim)
+
+			if err := c.handlerContextGetting(context.Background(), builder, klog.Background()); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := client.BuilderV1().Builders().Get(context.Background(), builder.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status.State != tt.state || stored.Status.Reason != tt.reason {
+				t.Errorf("state %s/%s, want %s/%s: %s", stored.Status.State, stored.Status.Reason, tt.state, tt.reason, stored.Status.Message)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.requests {
+				t.Errorf("context was fetched %d times, want %d", got, tt.requests)
+			}
+			// the build Job of a Builder that got past validation some other way
+			// must not fetch the context unchecked either
+			if _, _, err := c.newBuild(builder); (err == nil) != (tt.claim != "" || tt.sha256 == "") {
+				t.Errorf("newBuild() = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,481 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format 压缩包格式
+type Format string
+
+const (
+	None    Format = ""
+	Tar     Format = "tar"
+	TarGzip Format = "tar.gz"
+	TarZstd Format = "tar.zst"
+	Zip     Format = "zip"
+)
+
+// Limits 解压限制, 0 表示不限制
+type Limits struct {
+	// MaxFiles 允许解压的最大条目数
+	MaxFiles int
+	// MaxSize 解压后文件内容的最大总字节数
+	MaxSize int64
+}
+
+// DefaultLimits 默认的解压限制
+var DefaultLimits = Limits{
+	MaxFiles: 100000,
+	MaxSize:  8 << 30,
+}
+
+// ErrLimitExceeded 压缩包超出 Limits
+var ErrLimitExceeded = errors.New("archive exceeds the extraction limits")
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+	// 空 zip 只包含 end of central directory
+	emptyZipMagic = []byte("PK\x05\x06")
+	tarMagic      = []byte("ustar")
+)
+
+// 各格式对应的 content type
+var contentTypes = map[string]Format{
+	"application/x-tar":            Tar,
+	"application/tar":              Tar,
+	"application/gzip":             TarGzip,
+	"application/x-gzip":           TarGzip,
+	"application/x-compressed-tar": TarGzip,
+	"application/zstd":             TarZstd,
+	"application/x-zstd":           TarZstd,
+	"application/zip":              Zip,
+	"application/x-zip-compressed": Zip,
+}
+
+// 各格式对应的扩展名, 按匹配顺序排列
+var extensions = []struct {
+	suffix string
+	format Format
+}{
+	{".tar.gz", TarGzip},
+	{".tgz", TarGzip},
+	{".tar.zst", TarZstd},
+	{".tzst", TarZstd},
+	{".tar", Tar},
+	{".zip", Zip},
+}
+
+// Detect 判断文件的压缩包格式
+// 依次根据文件头、content type 和名称判断, 都无法识别时返回 None
+func Detect(file string, contentType string, name string) (Format, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return None, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return None, err
+	}
+	if format := detectMagic(header[:n]); format != None {
+		return format, nil
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if format, ok := contentTypes[mediaType]; ok {
+			return format, nil
+		}
+	}
+
+	name = strings.ToLower(name)
+	for _, ext := range extensions {
+		if strings.HasSuffix(name, ext.suffix) {
+			return ext.format, nil
+		}
+	}
+	return None, nil
+}
+
+// 辅助函数：根据文件头判断格式
+func detectMagic(header []byte) Format {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return TarGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return TarZstd
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, emptyZipMagic):
+		return Zip
+	case len(header) >= 262 && bytes.Equal(header[257:262], tarMagic):
+		return Tar
+	}
+	return None
+}
+
+// Extract 将压缩包解压到 destination 目录
+// 拒绝逃逸出 destination 的路径和链接, 超出 limits 时返回 ErrLimitExceeded
+func Extract(file string, format Format, destination string, limits Limits) error {
+	if err := os.MkdirAll(destination, 0o755); err != nil {
+		return err
+	}
+	e := &extractor{root: destination, limits: limits}
+
+	if format == Zip {
+		if err := e.extractZip(file); err != nil {
+			return err
+		}
+		return e.checkSymlinks()
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	switch format {
+	case TarGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case TarZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	case Tar:
+	default:
+		return fmt.Errorf("unsupported archive format %q", format)
+	}
+	if err := e.extractTar(r); err != nil {
+		return err
+	}
+	return e.checkSymlinks()
+}
+
+type extractor struct {
+	root   string
+	limits Limits
+	files  int
+	size   int64
+}
+
+func (e *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = e.dir(header.Name, header.FileInfo().Mode())
+		case tar.TypeReg, tar.TypeRegA:
+			err = e.file(header.Name, header.FileInfo().Mode(), tr)
+		case tar.TypeSymlink:
+			err = e.symlink(header.Name, header.Linkname)
+		case tar.TypeLink:
+			err = e.hardlink(header.Name, header.Linkname)
+		default:
+			// 设备文件、fifo 以及 pax 扩展头不属于构建上下文
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *extractor) extractZip(file string) error {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.dir(f.Name, mode)
+		case mode&fs.ModeSymlink != 0:
+			err = e.zipSymlink(f)
+		case mode.IsRegular():
+			err = e.zipFile(f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extractor) zipFile(f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return e.file(f.Name, f.Mode(), rc)
+}
+
+func (e *extractor) zipSymlink(f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return e.symlink(f.Name, string(target))
+}
+
+// path 将条目名转换为 root 下的路径, 并确认其父目录中没有符号链接
+func (e *extractor) path(name string) (string, error) {
+	if err := e.count(); err != nil {
+		return "", err
+	}
+	clean := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	if clean == "/" {
+		return e.root, nil
+	}
+	if strings.HasPrefix(name, "/") || hasDotDot(name) {
+		return "", fmt.Errorf("archive entry %q escapes the destination", name)
+	}
+
+	// 父目录中的符号链接可能指向 root 之外, 写入前逐级检查
+	if e.throughSymlink(clean) {
+		return "", fmt.Errorf("archive entry %q is written through a symlink", name)
+	}
+	return filepath.Join(e.root, filepath.FromSlash(clean)), nil
+}
+
+// throughSymlink 判断 root 中的路径 clean 的父目录中是否有符号链接, clean 为以 / 开头的干净路径
+func (e *extractor) throughSymlink(clean string) bool {
+	dir := e.root
+	for _, part := range strings.Split(strings.Trim(path.Dir(clean), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false
+		}
+		if err != nil || info.Mode()&fs.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *extractor) count() error {
+	e.files++
+	if e.limits.MaxFiles > 0 && e.files > e.limits.MaxFiles {
+		return fmt.Errorf("%w: more than %d entries", ErrLimitExceeded, e.limits.MaxFiles)
+	}
+	return nil
+}
+
+func (e *extractor) dir(name string, mode fs.FileMode) error {
+	target, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, mode.Perm()|0o700)
+}
+
+func (e *extractor) file(name string, mode fs.FileMode, r io.Reader) error {
+	target, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	// 已存在的同名条目(包括符号链接)先删除, 避免通过它写到别处
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()|0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if e.limits.MaxSize > 0 {
+		// 多读一个字节用于判断是否超出限制
+		r = io.LimitReader(r, e.limits.MaxSize-e.size+1)
+	}
+	n, err := io.Copy(out, r)
+	e.size += n
+	if err != nil {
+		return err
+	}
+	if e.limits.MaxSize > 0 && e.size > e.limits.MaxSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrLimitExceeded, e.limits.MaxSize)
+	}
+	return nil
+}
+
+func (e *extractor) symlink(name string, linkname string) error {
+	target, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if path.IsAbs(linkname) || filepath.IsAbs(linkname) {
+		return fmt.Errorf("archive symlink %q points to absolute path %q", name, linkname)
+	}
+	// 相对链接按条目所在目录以及已经解压的符号链接解析, 不能指向 root 之外
+	// 例如 y -> . 之后的 x -> y/.. 只看文本不会离开 root
+	if e.escapes(path.Dir(path.Clean("/"+name)), linkname) {
+		return fmt.Errorf("archive symlink %q escapes the destination", name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Symlink(linkname, target)
+}
+
+func (e *extractor) hardlink(name string, linkname string) error {
+	target, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(linkname, "/") || hasDotDot(linkname) {
+		return fmt.Errorf("archive hardlink %q escapes the destination", name)
+	}
+	// 链接源的父目录中的符号链接同样可能指向 root 之外
+	if e.escapes("/", linkname) || e.throughSymlink(path.Clean("/"+linkname)) {
+		return fmt.Errorf("archive hardlink %q points through a symlink", name)
+	}
+	source := filepath.Join(e.root, filepath.FromSlash(path.Clean("/"+linkname)))
+	info, err := os.Lstat(source)
+	if err != nil {
+		return fmt.Errorf("archive hardlink %q: %w", name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("archive hardlink %q must point to a regular file", name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Link(source, target)
+}
+
+// 辅助函数：判断路径中是否包含 ..
+func hasDotDot(name string) bool {
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// maxSymlinkHops 解析路径时最多跟随的符号链接数, 与 Linux 的 MAXSYMLINKS 相同
+const maxSymlinkHops = 40
+
+// escapes 判断从 root 中的目录 dir 出发的相对链接 linkname 在解析过程中是否会离开 root
+// 与内核一样逐段解析, 遇到已经解压的符号链接时展开它, 不存在的部分按普通目录处理
+func (e *extractor) escapes(dir string, linkname string) bool {
+	split := func(name string) []string {
+		return strings.FieldsFunc(name, func(r rune) bool { return r == '/' })
+	}
+	pending := append(split(dir), split(linkname)...)
+	var resolved []string
+	hops := 0
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return true
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		current := filepath.Join(e.root, filepath.Join(append(resolved, part)...))
+		info, err := os.Lstat(current)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			resolved = append(resolved, part)
+			continue
+		}
+		hops++
+		link, err := os.Readlink(current)
+		if err != nil || hops > maxSymlinkHops || path.IsAbs(link) || filepath.IsAbs(link) {
+			return true
+		}
+		// 链接相对于它所在的目录解析
+		pending = append(split(link), pending...)
+	}
+	return false
+}
+
+// checkSymlinks 在解压完成后重新解析所有符号链接
+// 后解压的链接可能改变先解压的链接的含义, 例如 x -> a/b/../.. 之后出现的 a -> . 和 b -> .
+func (e *extractor) checkSymlinks() error {
+	return filepath.WalkDir(e.root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(e.root, name)
+		if err != nil {
+			return err
+		}
+		link, err := os.Readlink(name)
+		if err != nil {
+			return err
+		}
+		if e.escapes(path.Dir("/"+filepath.ToSlash(rel)), link) {
+			return fmt.Errorf("archive symlink %q escapes the destination", filepath.ToSlash(rel))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,276 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entry 测试压缩包中的一个条目, link 不为空时为符号链接, hardlink 为真时为硬链接
+type entry struct {
+	name     string
+	body     string
+	link     string
+	hardlink bool
+	dir      bool
+}
+
+func writeTar(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.dir:
+			header = &tar.Header{Name: e.name, Mode: 0o755, Typeflag: tar.TypeDir}
+		case e.hardlink:
+			header = &tar.Header{Name: e.name, Typeflag: tar.TypeLink, Linkname: e.link}
+		case e.link != "":
+			header = &tar.Header{Name: e.name, Mode: 0o777, Typeflag: tar.TypeSymlink, Linkname: e.link}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeZip(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		if e.link != "" {
+			header.SetMode(os.ModeSymlink | 0o777)
+			body = e.link
+		} else {
+			header.SetMode(0o644)
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extract 将 data 写入临时文件并解压, 返回解压目录
+// 解压目录位于一个单独的父目录中, 逃逸的条目会出现在父目录里
+func extract(t *testing.T, data []byte, format Format, limits Limits) (string, error) {
+	t.Helper()
+	parent := t.TempDir()
+	file := filepath.Join(parent, "archive")
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	destination := filepath.Join(parent, "context")
+	return destination, Extract(file, format, destination, limits)
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		files   map[string]string
+	}{
+		{
+			name: "files and directories",
+			entries: []entry{
+				{name: "src/", dir: true},
+				{name: "Dockerfile", body: "FROM scratch\n"},
+				{name: "./src/main.go", body: "package main\n"},
+			},
+			files: map[string]string{"Dockerfile": "FROM scratch\n", "src/main.go": "package main\n"},
+		},
+		{
+			name: "symlinks inside the destination",
+			entries: []entry{
+				{name: "a/file", body: "a"},
+				{name: "b/link", link: "../a/file"},
+				{name: "dir", link: "a"},
+				{name: "self", link: "."},
+			},
+			files: map[string]string{"b/link": "a", "dir/file": "a", "self/a/file": "a"},
+		},
+		{
+			name: "hardlink",
+			entries: []entry{
+				{name: "a", body: "content"},
+				{name: "b", link: "a", hardlink: true},
+			},
+			files: map[string]string{"b": "content"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination, err := extract(t, writeTar(t, tt.entries), Tar, DefaultLimits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.files {
+				data, err := os.ReadFile(filepath.Join(destination, filepath.FromSlash(name)))
+				if err != nil || string(data) != want {
+					t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"parent directory", []entry{{name: "../evil", body: "x"}}},
+		{"nested parent directory", []entry{{name: "a/../../evil", body: "x"}}},
+		{"absolute path", []entry{{name: "/evil", body: "x"}}},
+		{"absolute symlink", []entry{{name: "link", link: "/etc"}}},
+		{"symlink to parent", []entry{{name: "link", link: ".."}}},
+		{"nested symlink to parent", []entry{{name: "a/link", link: "../../evil"}}},
+		{"write through symlink", []entry{{name: "dir", link: "a"}, {name: "dir/file", body: "x"}}},
+		{
+			"symlink chain",
+			[]entry{{name: "y", link: "."}, {name: "x", link: "y/.."}},
+		},
+		{
+			"chain through nested link",
+			[]entry{{name: "a/up", link: ".."}, {name: "b", link: "a/up/.."}},
+		},
+		{
+			"later links change an earlier one",
+			[]entry{{name: "x", link: "a/b/../.."}, {name: "a", link: "."}, {name: "b", link: "."}},
+		},
+		{
+			"chain used to write outside",
+			[]entry{{name: "y", link: "."}, {name: "x", link: "y/.."}, {name: "x/context/evil", body: "x"}},
+		},
+		{"hardlink outside", []entry{{name: "h", link: "../evil", hardlink: true}}},
+		{
+			"hardlink through symlink",
+			[]entry{{name: "y", link: "."}, {name: "x", link: "y"}, {name: "h", link: "x/f", hardlink: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination, err := extract(t, writeTar(t, tt.entries), Tar, DefaultLimits)
+			if err == nil {
+				t.Fatal("archive was extracted")
+			}
+			if _, statErr := os.Lstat(filepath.Join(filepath.Dir(destination), "evil")); !os.IsNotExist(statErr) {
+				t.Fatalf("an entry was written outside the destination: %v", statErr)
+			}
+		})
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		valid   bool
+	}{
+		{"files", []entry{{name: "Dockerfile", body: "FROM scratch\n"}, {name: "src/main.go", body: "package main\n"}}, true},
+		{"symlink inside", []entry{{name: "a", body: "a"}, {name: "b", link: "a"}}, true},
+		{"parent directory", []entry{{name: "../evil", body: "x"}}, false},
+		{"symlink chain", []entry{{name: "y", link: "."}, {name: "x", link: "y/.."}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := extract(t, writeZip(t, tt.entries), Zip, DefaultLimits)
+			if (err == nil) != tt.valid {
+				t.Errorf("Extract() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	entries := []entry{{name: "a", body: strings.Repeat("a", 10)}, {name: "b", body: strings.Repeat("b", 10)}}
+	tests := []struct {
+		name   string
+		limits Limits
+		valid  bool
+	}{
+		{"within limits", Limits{MaxFiles: 2, MaxSize: 20}, true},
+		{"too many files", Limits{MaxFiles: 1}, false},
+		{"too large", Limits{MaxSize: 19}, false},
+		{"no limits", Limits{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := extract(t, writeTar(t, entries), Tar, tt.limits)
+			if (err == nil) != tt.valid {
+				t.Fatalf("Extract() = %v, want valid %v", err, tt.valid)
+			}
+			if err != nil && !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("Extract() = %v, want ErrLimitExceeded", err)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tarData := writeTar(t, []entry{{name: "Dockerfile", body: "FROM scratch\n"}})
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		file        string
+		format      Format
+	}{
+		{"tar magic", tarData, "", "context", Tar},
+		{"gzip magic", gzipData(t, tarData), "", "context", TarGzip},
+		{"zip magic", writeZip(t, []entry{{name: "a", body: "a"}}), "", "context", Zip},
+		{"zstd magic", []byte{0x28, 0xb5, 0x2f, 0xfd, 0}, "", "context", TarZstd},
+		{"content type", []byte("data"), "application/x-tar; charset=binary", "context", Tar},
+		{"extension", []byte("data"), "application/octet-stream", "context.TGZ", TarGzip},
+		{"plain file", []byte("FROM scratch\n"), "text/plain", "Dockerfile", None},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "download")
+		if err := os.WriteFile(file, tt.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		format, err := Detect(file, tt.contentType, tt.file)
+		if err != nil || format != tt.format {
+			t.Errorf("%s: Detect() = %q, %v, want %q", tt.name, format, err, tt.format)
+		}
+	}
+}
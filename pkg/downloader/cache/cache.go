@@ -0,0 +1,344 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry 描述一份缓存的下载内容
+type Entry struct {
+	// URL 下载地址
+	URL string `json:"url"`
+	// Revision 下载前解析出的版本, 例如 git commit, 无法解析时为空
+	Revision string `json:"revision,omitempty"`
+	// Validator 用于重新校验缓存的值, 例如 http 的 ETag 或 Last-Modified
+	Validator string `json:"validator,omitempty"`
+	// Digest 内容的摘要, 格式为 sha256:<hex>, 也是内容在缓存中的地址
+	Digest string `json:"digest"`
+	// ContentType 内容的类型
+	ContentType string `json:"contentType,omitempty"`
+	// Size 内容的字节数
+	Size int64 `json:"size"`
+}
+
+// Cache 以内容摘要为地址的下载缓存, 超出容量时淘汰最久未使用的内容
+// 目录结构:
+//
+//	blobs/<hex>  下载到的文件或目录
+//	refs/<key>   URL+版本到 Entry 的索引
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu    sync.Mutex
+	blobs map[string]*blob
+	size  int64
+}
+
+type blob struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// New 打开 dir 中的缓存, maxSize 为 0 时不限制大小
+func New(dir string, maxSize int64) (*Cache, error) {
+	c := &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		blobs:   make(map[string]*blob),
+	}
+	for _, sub := range []string{"blobs", "refs", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	// 上次未完成的写入
+	if err := os.RemoveAll(filepath.Join(dir, "tmp")); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs"))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		size, err := diskUsage(filepath.Join(dir, "blobs", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		c.blobs[entry.Name()] = &blob{size: size, lastUsed: info.ModTime()}
+		c.size += size
+	}
+	return c, nil
+}
+
+// Lookup 查找 url 在 revision 下的缓存
+func (c *Cache) Lookup(url string, revision string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := os.ReadFile(c.refPath(url, revision))
+	if err != nil {
+		return nil, false
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil || entry.URL != url || entry.Revision != revision {
+		return nil, false
+	}
+	if _, ok := c.blobs[blobName(entry.Digest)]; !ok {
+		// 内容已被淘汰
+		os.Remove(c.refPath(url, revision))
+		return nil, false
+	}
+	return entry, true
+}
+
+// Contains 判断摘要为 digest 的内容是否在缓存中
+func (c *Cache) Contains(digest string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.blobs[blobName(digest)]
+	return ok
+}
+
+// Store 将 path 中下载到的文件或目录移入缓存并记录 entry
+// 调用后 path 不再存在, 需要通过 Materialize 取出内容
+func (c *Cache) Store(entry Entry, path string) error {
+	name := blobName(entry.Digest)
+	if name == "" {
+		return fmt.Errorf("invalid cache digest %q", entry.Digest)
+	}
+	size, err := diskUsage(path)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	target := filepath.Join(c.dir, "blobs", name)
+	if _, ok := c.blobs[name]; ok {
+		// 相同内容已经存在
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	} else {
+		if err := c.move(path, target); err != nil {
+			return err
+		}
+		c.blobs[name] = &blob{size: size}
+		c.size += size
+	}
+	c.touch(name)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(c.dir, "tmp"), c.refPath(entry.URL, entry.Revision), data); err != nil {
+		return err
+	}
+	c.evict(name)
+	return nil
+}
+
+// Materialize 将摘要为 digest 的内容复制到 destination
+// 文件优先使用硬链接, 调用方不能修改 destination 中的内容
+func (c *Cache) Materialize(digest string, destination string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := blobName(digest)
+	if _, ok := c.blobs[name]; !ok {
+		return fmt.Errorf("%s is not cached", digest)
+	}
+	c.touch(name)
+	return copyPath(filepath.Join(c.dir, "blobs", name), destination)
+}
+
+// touch 更新内容的最近使用时间
+func (c *Cache) touch(name string) {
+	now := time.Now()
+	c.blobs[name].lastUsed = now
+	os.Chtimes(filepath.Join(c.dir, "blobs", name), now, now)
+}
+
+// evict 淘汰最久未使用的内容直到总大小不超过上限, keep 不会被淘汰
+func (c *Cache) evict(keep string) {
+	if c.maxSize <= 0 || c.size <= c.maxSize {
+		return
+	}
+	names := make([]string, 0, len(c.blobs))
+	for name := range c.blobs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return c.blobs[names[i]].lastUsed.Before(c.blobs[names[j]].lastUsed)
+	})
+	for _, name := range names {
+		if c.size <= c.maxSize {
+			return
+		}
+		if name == keep {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.dir, "blobs", name)); err != nil {
+			continue
+		}
+		c.size -= c.blobs[name].size
+		delete(c.blobs, name)
+	}
+}
+
+// move 将 path 移动到 target, 跨文件系统时复制
+func (c *Cache) move(path string, target string) error {
+	if err := os.Rename(path, target); err == nil {
+		return nil
+	}
+	tmp, err := os.MkdirTemp(filepath.Join(c.dir, "tmp"), "blob-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyPath(path, filepath.Join(tmp, "blob")); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(tmp, "blob"), target); err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+func (c *Cache) refPath(url string, revision string) string {
+	sum := sha256.Sum256([]byte(url + "\x00" + revision))
+	return filepath.Join(c.dir, "refs", hex.EncodeToString(sum[:]))
+}
+
+// 辅助函数：摘要在缓存中的文件名
+func blobName(digest string) string {
+	algorithm, hexDigest, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" || len(hexDigest) != 64 {
+		return ""
+	}
+	if _, err := hex.DecodeString(hexDigest); err != nil {
+		return ""
+	}
+	return hexDigest
+}
+
+// 辅助函数：计算文件或目录占用的字节数
+func diskUsage(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// 辅助函数：先写入临时文件再重命名, 避免读到写了一半的内容
+func writeFileAtomic(tmpDir string, path string, data []byte) error {
+	f, err := os.CreateTemp(tmpDir, "ref-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// 辅助函数：复制文件或目录, 文件优先使用硬链接, 保留符号链接
+func copyPath(source string, destination string) error {
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return copyFile(source, destination, info.Mode())
+	}
+
+	return filepath.WalkDir(source, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(name, target, info.Mode())
+		}
+		return nil
+	})
+}
+
+func copyFile(source string, destination string, mode fs.FileMode) error {
+	if err := os.Link(source, destination); err == nil {
+		return nil
+	} else if errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// store 将 content 作为 url 在 revision 下的内容放入缓存, 返回其摘要
+func store(t *testing.T, c *Cache, url, revision, content string) string {
+	t.Helper()
+	sum := sha256.Sum256([]byte(content))
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	path := filepath.Join(t.TempDir(), "download")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entry := Entry{URL: url, Revision: revision, Digest: digest, Size: int64(len(content))}
+	if err := c.Store(entry, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("Store left %s behind: %v", path, err)
+	}
+	return digest
+}
+
+func TestCacheLookup(t *testing.T) {
+	c, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := store(t, c, "https://example.com/a.tar", "r1", "content a")
+
+	tests := []struct {
+		name     string
+		url      string
+		revision string
+		found    bool
+	}{
+		{"same url and revision", "https://example.com/a.tar", "r1", true},
+		{"other revision", "https://example.com/a.tar", "r2", false},
+		{"no revision", "https://example.com/a.tar", "", false},
+		{"other url", "https://example.com/b.tar", "r1", false},
+	}
+	for _, tt := range tests {
+		entry, ok := c.Lookup(tt.url, tt.revision)
+		if ok != tt.found {
+			t.Errorf("%s: found = %v, want %v", tt.name, ok, tt.found)
+			continue
+		}
+		if ok && entry.Digest != digest {
+			t.Errorf("%s: digest = %s, want %s", tt.name, entry.Digest, digest)
+		}
+	}
+
+	destination := filepath.Join(t.TempDir(), "context")
+	if err := c.Materialize(digest, destination); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(destination); err != nil || string(data) != "content a" {
+		t.Errorf("materialized %q, %v", data, err)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := store(t, c, "a", "", "0123456789")
+	time.Sleep(10 * time.Millisecond)
+	b := store(t, c, "b", "", "9876543210")
+	time.Sleep(10 * time.Millisecond)
+	// a 被使用后 b 成为最久未使用的内容
+	if err := c.Materialize(a, filepath.Join(t.TempDir(), "a")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	store(t, c, "c", "", "abcdefghij")
+
+	if !c.Contains(a) {
+		t.Error("recently used content was evicted")
+	}
+	if c.Contains(b) {
+		t.Error("least recently used content was kept")
+	}
+	if _, ok := c.Lookup("b", ""); ok {
+		t.Error("lookup found evicted content")
+	}
+
+	// 重新打开后从磁盘恢复
+	reopened, err := New(dir, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Contains(a) || reopened.Contains(b) {
+		t.Error("reopened cache does not match what was on disk")
+	}
+}
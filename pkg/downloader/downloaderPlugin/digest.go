@@ -0,0 +1,69 @@
+package downloaderPlugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DigestPath 计算文件或目录的大小和 sha256 摘要
+func DigestPath(path string) (int64, string, error) {
+	h := sha256.New()
+	size, err := HashPath(path, h)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, FormatDigest(h), nil
+}
+
+// HashPath 将文件或目录写入 h 并返回内容的字节数
+// 目录按字典序逐个文件计算, 每一项包含以 / 分隔的相对路径、权限和内容
+func HashPath(path string, h hash.Hash) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, name)
+		if err != nil {
+			return err
+		}
+		if name != path {
+			fmt.Fprintf(h, "%s %o\n", filepath.ToSlash(rel), info.Mode())
+		}
+
+		switch {
+		case info.Mode().IsRegular():
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			n, err := io.Copy(h, f)
+			size += n
+			return err
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			io.WriteString(h, target)
+		}
+		return nil
+	})
+	return size, err
+}
+
+// FormatDigest 将 sha256 的结果格式化为 sha256:<hex>
+func FormatDigest(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
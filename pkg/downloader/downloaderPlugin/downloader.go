@@ -1,18 +1,24 @@
 package downloaderPlugin
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 )
 
 type PluginType string
 
 // Downloader 接口
+// 新的下载器应同时实现 DownloaderV2
 type Downloader interface {
 	Download(url string, destination string) error
 	GetType() PluginType
 }
 
+// ErrUnsupportedProtocol 没有对应的下载器
+var ErrUnsupportedProtocol = errors.New("unsupported protocol")
+
 // 下载器注册表
 var (
 	downloaders = make(map[string]Downloader)
@@ -40,7 +46,7 @@ func GetDownloader(url string) (Downloader, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("unsupported protocol")
+	return nil, ErrUnsupportedProtocol
 }
 
 // GetDownloaderByType 根据type 获取下载器
@@ -53,10 +59,10 @@ func GetDownloaderByType(t string) (Downloader, error) {
 			return downloader, nil
 		}
 	}
-	return nil, fmt.Errorf("unsupported protocol")
+	return nil, ErrUnsupportedProtocol
 }
 
 // 辅助函数：判断 URL 前缀是否是协议
 func startsWithProtocol(url string, protocol string) bool {
-	return len(url) > len(protocol) && url[:len(protocol)+3] == protocol+"://"
+	return strings.HasPrefix(url, protocol+"://")
 }
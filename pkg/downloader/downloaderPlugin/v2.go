@@ -0,0 +1,168 @@
+package downloaderPlugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Auth 为下载器使用的认证信息
+// 来自 RemoteContext.AuthConfigMap 指向的 ConfigMap 和同名 Secret, Secret 中的值优先
+type Auth map[string]string
+
+// Options 下载选项
+type Options struct {
+	// Auth 认证信息, 各下载器自行解释其中的 key
+	Auth Auth
+	// Digest 期望的摘要, 格式为 sha256:<hex>, 为空时不校验
+	Digest string
+	// MaxSize 允许下载的最大字节数, 0 表示不限制
+	MaxSize int64
+	// Headers 附加的请求头, 只对 http 下载器有效
+	Headers map[string]string
+	// Timeout 单次请求的超时时间, 0 表示不限制, 整个下载的期限由 ctx 控制
+	Timeout time.Duration
+	// Progress 不为空时在下载过程中被调用, total 未知时为 -1
+	Progress func(written int64, total int64)
+	// Validator 上次下载结果中的 Validator, 内容未变化时下载器返回 ErrNotModified
+	Validator string
+}
+
+// Result 下载结果
+type Result struct {
+	// Size 写入的字节数
+	Size int64
+	// ContentType 下载内容的类型, 未知时为空
+	ContentType string
+	// Digest 下载内容的摘要, 格式为 sha256:<hex>, 目录按 DigestPath 计算
+	Digest string
+	// Revision 实际下载到的版本, 例如 git commit
+	Revision string
+	// Validator 用于下次判断内容是否变化的值, 例如 http 的 ETag 或 Last-Modified
+	Validator string
+}
+
+// DownloaderV2 支持取消和选项的下载器接口
+// ctx 被取消时下载应尽快返回 ctx.Err()
+type DownloaderV2 interface {
+	DownloadContext(ctx context.Context, url string, destination string, opts Options) (*Result, error)
+	GetType() PluginType
+}
+
+// RevisionResolver 由能在下载前解析出版本的下载器实现, 例如 git 的 commit
+// 相同 URL 和版本的内容被认为不变, 可以直接使用缓存
+type RevisionResolver interface {
+	// ResolveRevision 返回 url 当前的版本, 无法解析时返回空字符串
+	ResolveRevision(ctx context.Context, url string, opts Options) (string, error)
+}
+
+// Uploader 由能把文件上传到 url 的下载器实现, 例如 s3, 用于导出构建结果
+// 无法通过重试成功的错误应包装为 PermanentError
+type Uploader interface {
+	Upload(ctx context.Context, url string, source string, opts Options) error
+}
+
+// ErrNotModified 内容与 Options.Validator 对应的版本相同, 没有下载任何内容
+var ErrNotModified = errors.New("content not modified")
+
+// Adapt 将只实现 Downloader 的下载器适配为 DownloaderV2
+// 旧的下载器无法被中断, ctx 取消后下载在后台继续, 结果被丢弃
+func Adapt(downloader Downloader) DownloaderV2 {
+	if v2, ok := downloader.(DownloaderV2); ok {
+		return v2
+	}
+	return &adapter{downloader: downloader}
+}
+
+type adapter struct {
+	downloader Downloader
+}
+
+func (a *adapter) DownloadContext(ctx context.Context, url string, destination string, opts Options) (*Result, error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- a.downloader.Download(url, destination)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	size, digest, err := DigestPath(destination)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Size: size, Digest: digest}
+	return result, CheckResult(opts, result)
+}
+
+func (a *adapter) GetType() PluginType {
+	return a.downloader.GetType()
+}
+
+// CheckResult 检查下载结果是否满足选项中的摘要和大小限制
+func CheckResult(opts Options, result *Result) error {
+	if opts.MaxSize > 0 && result.Size > opts.MaxSize {
+		return &SizeLimitError{Limit: opts.MaxSize}
+	}
+	if opts.Digest != "" && opts.Digest != result.Digest {
+		return &DigestMismatchError{Expected: opts.Digest, Actual: result.Digest}
+	}
+	return nil
+}
+
+// SizeLimitError 下载内容超过 Options.MaxSize
+type SizeLimitError struct {
+	Limit int64
+}
+
+func (e *SizeLimitError) Error() string {
+	return fmt.Sprintf("download exceeds the size limit of %d bytes", e.Limit)
+}
+
+// PermanentError 重试也无法成功的错误, 例如服务端返回 404
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// DigestMismatchError 下载内容与 Options.Digest 不一致
+type DigestMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("digest mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// GetDownloaderV2 根据 URL 获取下载器, 旧的下载器会被适配
+func GetDownloaderV2(url string) (DownloaderV2, error) {
+	downloader, err := GetDownloader(url)
+	if err != nil {
+		return nil, err
+	}
+	return Adapt(downloader), nil
+}
+
+// GetDownloaderV2ByType 根据 type 获取下载器, 旧的下载器会被适配
+func GetDownloaderV2ByType(t string) (DownloaderV2, error) {
+	downloader, err := GetDownloaderByType(t)
+	if err != nil {
+		return nil, err
+	}
+	return Adapt(downloader), nil
+}
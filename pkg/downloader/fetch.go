@@ -0,0 +1,219 @@
+package downloader
+
+import (
+	"builder/pkg/downloader/archive"
+	"builder/pkg/downloader/cache"
+	"builder/pkg/downloader/downloaderPlugin"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Request 描述一次构建上下文的获取
+type Request struct {
+	// URL 构建上下文的地址
+	URL string
+	// Type 下载器类型, 为空时使用 URL 的协议
+	Type string
+	// Destination 构建上下文所在的目录, 压缩包会被解压到这里
+	Destination string
+	// Options 传给下载器的选项
+	Options downloaderPlugin.Options
+	// Limits 解压限制
+	Limits archive.Limits
+	// Digests 期望的摘要, 格式为 <algorithm>:<hex>, 支持 sha256 和 sha512
+	Digests []string
+	// Signature 不为空时校验下载内容的分离签名
+	Signature *Signature
+	// Cache 不为空时复用之前下载过的内容
+	Cache *cache.Cache
+}
+
+// Resolve 获取下载器, 优先使用 t, 否则使用 URL 的协议
+func Resolve(t string, rawURL string) (downloaderPlugin.DownloaderV2, error) {
+	if t != "" {
+		downloader, err := downloaderPlugin.GetDownloaderV2ByType(t)
+		if err != nil {
+			return nil, fmt.Errorf("remote context type %q: %w", t, err)
+		}
+		return downloader, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote context url: %w", err)
+	}
+	downloader, err := downloaderPlugin.GetDownloaderV2ByType(u.Scheme)
+	if err != nil {
+		return nil, fmt.Errorf("remote context scheme %q: %w", u.Scheme, err)
+	}
+	return downloader, nil
+}
+
+// Fetch 下载构建上下文到 req.Destination 目录
+// 下载内容先按 req 校验摘要和签名, 压缩包会被自动解压, 所有下载器共用这一流程
+func Fetch(ctx context.Context, req Request) (*downloaderPlugin.Result, error) {
+	downloader, err := Resolve(req.Type, req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	download := req.Destination + ".download"
+	if err := os.RemoveAll(download); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(download)
+	if err := os.RemoveAll(req.Destination); err != nil {
+		return nil, err
+	}
+
+	result, err := fetchCached(ctx, downloader, req, download)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解压前校验下载到的原始内容
+	if err := verifyDigests(download, req.Digests); err != nil {
+		return nil, err
+	}
+	if req.Signature != nil {
+		if err := verifySignature(ctx, download, req.Signature, req.Options.Auth); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := os.Stat(download)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return result, os.Rename(download, req.Destination)
+	}
+
+	name := fileName(req.URL)
+	format, err := archive.Detect(download, result.ContentType, name)
+	if err != nil {
+		return nil, err
+	}
+	if format == archive.None {
+		// 不是压缩包时作为上下文中唯一的文件, 例如直接下载的 Dockerfile
+		if err := os.MkdirAll(req.Destination, 0o755); err != nil {
+			return nil, err
+		}
+		return result, os.Rename(download, filepath.Join(req.Destination, name))
+	}
+	if err := archive.Extract(download, format, req.Destination, req.Limits); err != nil {
+		return nil, fmt.Errorf("extract %s archive: %w", format, err)
+	}
+	return result, nil
+}
+
+// fetchCached 将 req.URL 的原始内容放到 download, req.Cache 不为空时优先使用缓存
+// 缓存按 URL+版本索引, 能解析版本的下载器直接比较版本, 其余的通过 Validator 重新校验,
+// 缓存内容的摘要与 req.Digests 中的 sha256 一致时不再访问远端
+func fetchCached(ctx context.Context, downloader downloaderPlugin.DownloaderV2, req Request, download string) (*downloaderPlugin.Result, error) {
+	if req.Cache == nil {
+		return downloader.DownloadContext(ctx, req.URL, download, req.Options)
+	}
+
+	revision := ""
+	resolver, canResolve := downloader.(downloaderPlugin.RevisionResolver)
+	if canResolve {
+		var err error
+		if revision, err = resolver.ResolveRevision(ctx, req.URL, req.Options); err != nil {
+			return nil, fmt.Errorf("resolve revision of %s: %w", req.URL, err)
+		}
+		if revision == "" {
+			// 无法确定版本时不能判断缓存是否有效
+			return downloader.DownloadContext(ctx, req.URL, download, req.Options)
+		}
+	}
+
+	opts := req.Options
+	if entry, ok := req.Cache.Lookup(req.URL, revision); ok {
+		if canResolve || pinned(req.Digests, entry.Digest) {
+			return materialize(req.Cache, entry, download)
+		}
+		if entry.Validator != "" {
+			opts.Validator = entry.Validator
+		}
+	}
+
+	result, err := downloader.DownloadContext(ctx, req.URL, download, opts)
+	if errors.Is(err, downloaderPlugin.ErrNotModified) {
+		entry, ok := req.Cache.Lookup(req.URL, revision)
+		if !ok {
+			// 校验期间缓存被淘汰, 重新完整下载
+			if err := os.RemoveAll(download); err != nil {
+				return nil, err
+			}
+			return downloader.DownloadContext(ctx, req.URL, download, req.Options)
+		}
+		return materialize(req.Cache, entry, download)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Revision != "" {
+		revision = result.Revision
+	}
+	entry := cache.Entry{
+		URL:         req.URL,
+		Revision:    revision,
+		Validator:   result.Validator,
+		Digest:      result.Digest,
+		ContentType: result.ContentType,
+		Size:        result.Size,
+	}
+	if err := req.Cache.Store(entry, download); err != nil {
+		if _, statErr := os.Lstat(download); statErr == nil {
+			// 无法缓存不影响本次下载
+			return result, nil
+		}
+		return nil, err
+	}
+	return result, req.Cache.Materialize(entry.Digest, download)
+}
+
+// 辅助函数：从缓存中取出内容
+func materialize(c *cache.Cache, entry *cache.Entry, download string) (*downloaderPlugin.Result, error) {
+	if err := os.RemoveAll(download); err != nil {
+		return nil, err
+	}
+	if err := c.Materialize(entry.Digest, download); err != nil {
+		return nil, err
+	}
+	return &downloaderPlugin.Result{
+		Size:        entry.Size,
+		ContentType: entry.ContentType,
+		Digest:      entry.Digest,
+		Revision:    entry.Revision,
+		Validator:   entry.Validator,
+	}, nil
+}
+
+// 辅助函数：判断 digest 是否在期望的摘要中
+func pinned(digests []string, digest string) bool {
+	for _, d := range digests {
+		if d == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// 辅助函数：取 URL 路径的最后一段作为文件名, 不能作为文件名的 .、.. 和 / 使用固定的名称
+func fileName(rawURL string) string {
+	name := "context"
+	if u, err := url.Parse(rawURL); err == nil {
+		if base := path.Base(u.Path); base != "." && base != ".." && base != "/" {
+			name = base
+		}
+	}
+	return name
+}
@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"builder/pkg/downloader/cache"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// revalidatingServer 返回带 ETag 的 Dockerfile, 记录完整响应和 304 响应的次数
+type revalidatingServer struct {
+	mu          sync.Mutex
+	etag        string
+	body        string
+	full        int
+	notModified int
+}
+
+func (s *revalidatingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Header.Get("If-None-Match") == s.etag {
+		s.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.full++
+	w.Header().Set("ETag", s.etag)
+	w.Write([]byte(s.body))
+}
+
+func (s *revalidatingServer) set(etag, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.etag, s.body = etag, body
+}
+
+func TestFetchCachedRevalidation(t *testing.T) {
+	server := &revalidatingServer{}
+	server.set(`"v1"`, "FROM scratch\n")
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	c, err := cache.New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := srv.URL + "/Dockerfile"
+
+	tests := []struct {
+		name        string
+		etag        string
+		body        string
+		full        int
+		notModified int
+	}{
+		{"first download", `"v1"`, "FROM scratch\n", 1, 0},
+		{"unchanged content is revalidated", `"v1"`, "FROM scratch\n", 1, 1},
+		{"changed content is downloaded", `"v2"`, "FROM busybox\n", 2, 1},
+		{"new content is cached", `"v2"`, "FROM busybox\n", 2, 2},
+	}
+	for _, tt := range tests {
+		server.set(tt.etag, tt.body)
+		destination := filepath.Join(t.TempDir(), "context")
+		if _, err := Fetch(context.Background(), Request{URL: url, Destination: destination, Cache: c}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		data, err := os.ReadFile(filepath.Join(destination, "Dockerfile"))
+		if err != nil || string(data) != tt.body {
+			t.Errorf("%s: context has %q, %v, want %q", tt.name, data, err, tt.body)
+		}
+		if server.full != tt.full || server.notModified != tt.notModified {
+			t.Errorf("%s: %d full and %d not modified responses, want %d and %d", tt.name, server.full, server.notModified, tt.full, tt.notModified)
+		}
+	}
+}
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		url  string
+		name string
+	}{
+		{"https://example.com/build/Dockerfile", "Dockerfile"},
+		{"https://example.com/context.tar.gz?token=abc", "context.tar.gz"},
+		{"https://example.com/build/", "build"},
+		{"https://example.com", "context"},
+		{"https://example.com/", "context"},
+		{"https://example.com/build/..", "context"},
+		{"https://example.com/%2e%2e", "context"},
+		{"https://example.com/.", "context"},
+		{"s3://bucket/..", "context"},
+	}
+	for _, tt := range tests {
+		if name := fileName(tt.url); name != tt.name {
+			t.Errorf("fileName(%q) = %q, want %q", tt.url, name, tt.name)
+		}
+	}
+}
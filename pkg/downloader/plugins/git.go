@@ -0,0 +1,258 @@
+package plugins
+
+import (
+	"builder/pkg/downloader/downloaderPlugin"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GitDownloader 克隆 git 仓库作为构建上下文
+// url 沿用 docker build 的写法: <repository>#<ref>:<subdir>
+// ref 可以是分支、tag 或 commit, subdir 为仓库内作为上下文的子目录
+type GitDownloader struct {
+	// Depth 为 clone 深度, 0 表示完整 clone
+	Depth int
+	// Protocols 允许使用的传输协议, 以 : 分隔, 作为 GIT_ALLOW_PROTOCOL 传给 git, 为空时使用 defaultGitProtocols
+	// ext:: 等会执行命令的传输因此不可用
+	Protocols string
+}
+
+const gitType = "git"
+
+// defaultGitProtocols 默认允许的传输协议, file 只用于测试, 默认不允许读取控制器本地的仓库
+const defaultGitProtocols = "https:http:ssh:git"
+
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+func (d *GitDownloader) Download(url string, destination string) error {
+	_, err := d.DownloadContext(context.Background(), url, destination, downloaderPlugin.Options{})
+	return err
+}
+
+// DownloadContext 将仓库的指定版本检出到 destination, Result.Revision 为实际检出的 commit
+func (d *GitDownloader) DownloadContext(ctx context.Context, url string, destination string, opts downloaderPlugin.Options) (*downloaderPlugin.Result, error) {
+	commit, err := d.checkout(ctx, url, destination)
+	if err != nil {
+		return nil, err
+	}
+
+	size, digest, err := downloaderPlugin.DigestPath(destination)
+	if err != nil {
+		return nil, err
+	}
+	result := &downloaderPlugin.Result{
+		Size:     size,
+		Digest:   digest,
+		Revision: commit,
+	}
+	return result, downloaderPlugin.CheckResult(opts, result)
+}
+
+// ResolveRevision 通过 ls-remote 解析 ref 当前指向的 commit, 无法解析短 commit 时返回空字符串
+func (d *GitDownloader) ResolveRevision(ctx context.Context, url string, opts downloaderPlugin.Options) (string, error) {
+	repository, ref, _ := parseGitURL(url)
+	if err := validateGitURL(repository, ref); err != nil {
+		return "", err
+	}
+	if len(ref) == 40 && commitPattern.MatchString(ref) {
+		return ref, nil
+	}
+
+	// ls-remote 按后缀匹配, 只取与候选完整引用名相同的条目, 以免 main 匹配到 refs/heads/feature/main
+	// 附注 tag 解引用后的 ^{} 条目不匹配 tag 自身的名称, 需要单独列出
+	candidates := gitRefCandidates(ref)
+	args := []string{"ls-remote", "--", repository}
+	for _, name := range candidates {
+		args = append(args, name, name+"^{}")
+	}
+	out, err := d.git(ctx, "", args...)
+	if err != nil {
+		return "", err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if sha, name, ok := strings.Cut(line, "\t"); ok {
+			refs[name] = sha
+		}
+	}
+	for _, name := range candidates {
+		// 附注 tag 指向 tag 对象, 以 ^{} 结尾的条目才是它指向的 commit
+		if sha, ok := refs[name+"^{}"]; ok {
+			return sha, nil
+		}
+		if sha, ok := refs[name]; ok {
+			return sha, nil
+		}
+	}
+	return "", nil
+}
+
+// gitRefCandidates 返回 ref 可能指向的完整引用名, 按使用顺序排列: 同名时分支优先于 tag
+// 完整的 commit 和 refs/ 开头的引用原样返回, 解析版本和检出使用同样的顺序
+func gitRefCandidates(ref string) []string {
+	switch {
+	case ref == "":
+		return []string{"HEAD"}
+	case ref == "HEAD", strings.HasPrefix(ref, "refs/"), len(ref) == 40 && commitPattern.MatchString(ref):
+		return []string{ref}
+	}
+	return []string{"refs/heads/" + ref, "refs/tags/" + ref}
+}
+
+// checkout 克隆仓库并将 ref 对应的子目录移动到 destination, 返回实际检出的 commit
+func (d *GitDownloader) checkout(ctx context.Context, url string, destination string) (string, error) {
+	repository, ref, subdir := parseGitURL(url)
+	if err := validateGitURL(repository, ref); err != nil {
+		return "", err
+	}
+
+	src, err := os.MkdirTemp(filepath.Dir(destination), ".git-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(src)
+
+	if _, err := d.git(ctx, src, "init", "-q"); err != nil {
+		return "", err
+	}
+	if _, err := d.git(ctx, src, "remote", "add", "--", "origin", repository); err != nil {
+		return "", err
+	}
+	target, err := d.fetch(ctx, src, ref)
+	if err != nil {
+		return "", err
+	}
+	// 末尾的 -- 使 target 只能被解释为版本
+	if _, err := d.git(ctx, src, "checkout", "-q", target, "--"); err != nil {
+		return "", err
+	}
+	commit, err := d.git(ctx, src, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(filepath.Join(src, ".git")); err != nil {
+		return "", err
+	}
+
+	context := src
+	if subdir != "" {
+		if context, err = gitSubdir(src, subdir); err != nil {
+			return "", err
+		}
+		if info, err := os.Stat(context); err != nil || !info.IsDir() {
+			return "", fmt.Errorf("subdirectory %q not found in %s at %s", subdir, repository, commit)
+		}
+	}
+	if err := os.Rename(context, destination); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// fetch 拉取 ref 并返回需要检出的对象
+// 部分服务端不允许浅拉取任意 commit, 此时退回完整拉取
+func (d *GitDownloader) fetch(ctx context.Context, dir string, ref string) (string, error) {
+	args := []string{"fetch", "-q"}
+	if d.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(d.Depth))
+	}
+	var err error
+	for _, name := range gitRefCandidates(ref) {
+		if _, err = d.git(ctx, dir, append(args, "--", "origin", name)...); err == nil {
+			return "FETCH_HEAD", nil
+		}
+	}
+	if !commitPattern.MatchString(ref) {
+		return "", err
+	}
+	if _, err := d.git(ctx, dir, "fetch", "-q", "--tags", "--", "origin"); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+// parseGitURL 拆分 <repository>#<ref>:<subdir>
+func parseGitURL(url string) (repository, ref, subdir string) {
+	repository, fragment, _ := strings.Cut(url, "#")
+	ref, subdir, _ = strings.Cut(fragment, ":")
+	return repository, ref, strings.Trim(subdir, "/")
+}
+
+// validateGitURL 拒绝会被 git 当作选项的仓库地址和 ref, 例如 #--upload-pack=<cmd>
+// 调用 git 时位置参数前都有 --, 这里再检查一次, 同时让错误在下载前就能报告
+func validateGitURL(repository, ref string) error {
+	switch {
+	case repository == "":
+		return &downloaderPlugin.PermanentError{Err: fmt.Errorf("git url has no repository")}
+	case strings.HasPrefix(repository, "-"):
+		return &downloaderPlugin.PermanentError{Err: fmt.Errorf("git repository %q must not start with -", repository)}
+	case strings.HasPrefix(ref, "-"):
+		return &downloaderPlugin.PermanentError{Err: fmt.Errorf("git ref %q must not start with -", ref)}
+	}
+	return nil
+}
+
+// gitSubdir 返回 src 中的子目录 subdir, 解析符号链接后仍必须位于 src 中
+// 仓库中的子目录可能是指向仓库外的符号链接, 只比较路径文本不够
+func gitSubdir(src, subdir string) (string, error) {
+	root, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return "", err
+	}
+	context := filepath.Join(root, filepath.FromSlash(subdir))
+	if !within(root, context) {
+		return "", &downloaderPlugin.PermanentError{Err: fmt.Errorf("subdirectory %q is outside of the repository", subdir)}
+	}
+	resolved, err := filepath.EvalSymlinks(context)
+	if err != nil {
+		// 不存在时由调用方报告
+		return context, nil
+	}
+	if !within(root, resolved) {
+		return "", &downloaderPlugin.PermanentError{Err: fmt.Errorf("subdirectory %q resolves to outside of the repository", subdir)}
+	}
+	return resolved, nil
+}
+
+// 辅助函数：path 是否为 root 或位于 root 之下
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// 辅助函数：执行 git 命令并返回输出, 只允许使用 d.Protocols 中的传输协议
+func (d *GitDownloader) git(ctx context.Context, dir string, args ...string) (string, error) {
+	protocols := d.Protocols
+	if protocols == "" {
+		protocols = defaultGitProtocols
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+protocols)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (d *GitDownloader) GetType() downloaderPlugin.PluginType {
+	return gitType
+}
+
+// 在 init 函数中注册 git 下载器, 默认浅 clone
+func init() {
+	downloaderPlugin.RegisterDownloader(gitType, &GitDownloader{Depth: 1})
+}
@@ -0,0 +1,275 @@
+package plugins
+
+import (
+	"builder/pkg/downloader/downloaderPlugin"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newGitRepository 创建一个 bare 仓库并返回其 file:// 地址和各 commit
+// 仓库中 main 分支有两个 commit, 第一个带有 tag v1, 第二个加入子目录 sub 和指向仓库外的符号链接 escape
+func newGitRepository(t *testing.T) (url string, first string, second string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	bare := filepath.Join(dir, "repo.git")
+	run := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+			"GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(work, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.MkdirAll(work, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	run(work, "init", "-q", "-b", "main")
+	write("Dockerfile", "FROM scratch\n")
+	run(work, "add", ".")
+	run(work, "commit", "-q", "-m", "first")
+	run(work, "tag", "v1")
+	first = run(work, "rev-parse", "HEAD")
+
+	write("sub/Dockerfile", "FROM busybox\n")
+	if err := os.Symlink(dir, filepath.Join(work, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	run(work, "add", ".")
+	run(work, "commit", "-q", "-m", "second")
+	second = run(work, "rev-parse", "HEAD")
+
+	run(dir, "clone", "-q", "--bare", work, bare)
+	return "file://" + bare, first, second
+}
+
+func TestParseGitURL(t *testing.T) {
+	tests := []struct {
+		url        string
+		repository string
+		ref        string
+		subdir     string
+	}{
+		{"https://example.com/r.git", "https://example.com/r.git", "", ""},
+		{"https://example.com/r.git#main", "https://example.com/r.git", "main", ""},
+		{"https://example.com/r.git#v1.0:docker/app/", "https://example.com/r.git", "v1.0", "docker/app"},
+		{"https://example.com/r.git#:sub", "https://example.com/r.git", "", "sub"},
+		{"git@example.com:team/r.git#main", "git@example.com:team/r.git", "main", ""},
+	}
+	for _, tt := range tests {
+		repository, ref, subdir := parseGitURL(tt.url)
+		if repository != tt.repository || ref != tt.ref || subdir != tt.subdir {
+			t.Errorf("parseGitURL(%q) = %q, %q, %q, want %q, %q, %q", tt.url, repository, ref, subdir, tt.repository, tt.ref, tt.subdir)
+		}
+	}
+}
+
+func TestGitDownloader(t *testing.T) {
+	url, first, second := newGitRepository(t)
+	downloader := &GitDownloader{Depth: 1, Protocols: "file"}
+
+	tests := []struct {
+		name     string
+		url      string
+		revision string
+		file     string
+	}{
+		{"default branch", url, second, "sub/Dockerfile"},
+		{"branch", url + "#main", second, "Dockerfile"},
+		{"tag", url + "#v1", first, "Dockerfile"},
+		{"commit", url + "#" + first, first, "Dockerfile"},
+		{"short commit", url + "#" + first[:7], first, "Dockerfile"},
+		{"subdir", url + "#main:sub", second, "Dockerfile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination := filepath.Join(t.TempDir(), "context")
+			result, err := downloader.DownloadContext(context.Background(), tt.url, destination, downloaderPlugin.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Revision != tt.revision {
+				t.Errorf("revision = %s, want %s", result.Revision, tt.revision)
+			}
+			if _, err := os.Stat(filepath.Join(destination, tt.file)); err != nil {
+				t.Errorf("%s is missing: %v", tt.file, err)
+			}
+			if _, err := os.Stat(filepath.Join(destination, ".git")); !os.IsNotExist(err) {
+				t.Errorf(".git is left in the context: %v", err)
+			}
+		})
+	}
+}
+
+func TestGitDownloaderRejects(t *testing.T) {
+	url, _, _ := newGitRepository(t)
+	marker := filepath.Join(t.TempDir(), "pwned")
+
+	tests := []struct {
+		name       string
+		downloader *GitDownloader
+		url        string
+	}{
+		{"upload-pack in ref", &GitDownloader{Depth: 1, Protocols: "file"}, url + "#--upload-pack=touch " + marker},
+		{"option as repository", &GitDownloader{Depth: 1, Protocols: "file"}, "--upload-pack=touch " + marker + "#main"},
+		{"ext transport", &GitDownloader{Depth: 1, Protocols: "file"}, "ext::sh -c touch% " + marker},
+		{"file transport by default", &GitDownloader{Depth: 1}, url},
+		{"subdir outside", &GitDownloader{Depth: 1, Protocols: "file"}, url + "#main:../.."},
+		{"subdir symlink outside", &GitDownloader{Depth: 1, Protocols: "file"}, url + "#main:escape"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination := filepath.Join(t.TempDir(), "context")
+			if _, err := tt.downloader.DownloadContext(context.Background(), tt.url, destination, downloaderPlugin.Options{}); err == nil {
+				t.Fatal("download succeeded")
+			}
+			if _, err := os.Stat(marker); !os.IsNotExist(err) {
+				t.Fatalf("git ran a command from the url: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateGitURL(t *testing.T) {
+	tests := []struct {
+		repository string
+		ref        string
+		valid      bool
+	}{
+		{"https://example.com/r.git", "main", true},
+		{"https://example.com/r.git", "", true},
+		{"https://example.com/r.git", "refs/heads/feature-1", true},
+		{"", "main", false},
+		{"-c core.sshCommand=id", "main", false},
+		{"https://example.com/r.git", "--upload-pack=id", false},
+		{"https://example.com/r.git", "-x", false},
+	}
+	for _, tt := range tests {
+		err := validateGitURL(tt.repository, tt.ref)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateGitURL(%q, %q) = %v, want valid %v", tt.repository, tt.ref, err, tt.valid)
+		}
+		var permanent *downloaderPlugin.PermanentError
+		if err != nil && !errors.As(err, &permanent) {
+			t.Errorf("validateGitURL(%q, %q) = %v, want a permanent error", tt.repository, tt.ref, err)
+		}
+	}
+}
+
+func TestGitResolveRevision(t *testing.T) {
+	url, first, second := newGitRepository(t)
+	downloader := &GitDownloader{Depth: 1, Protocols: "file"}
+	marker := filepath.Join(t.TempDir(), "pwned")
+
+	tests := []struct {
+		name     string
+		url      string
+		revision string
+		invalid  bool
+	}{
+		{"default branch", url, second, false},
+		{"branch", url + "#main:sub", second, false},
+		{"tag", url + "#v1", first, false},
+		{"full commit", url + "#" + strings.Repeat("a", 40), strings.Repeat("a", 40), false},
+		{"unknown ref", url + "#nope", "", false},
+		{"upload-pack in ref", url + "#--upload-pack=touch " + marker, "", true},
+		{"option as repository", "--upload-pack=touch " + marker, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revision, err := downloader.ResolveRevision(context.Background(), tt.url, downloaderPlugin.Options{})
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("resolved %q", revision)
+				}
+				if _, err := os.Stat(marker); !os.IsNotExist(err) {
+					t.Fatalf("git ran a command from the url: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revision != tt.revision {
+				t.Errorf("revision = %q, want %q", revision, tt.revision)
+			}
+		})
+	}
+}
+
+func TestGitResolveRevisionRefOrder(t *testing.T) {
+	url, first, second := newGitRepository(t)
+	bare := strings.TrimPrefix(url, "file://")
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = bare
+		cmd.Env = append(os.Environ(), "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com", "GIT_CONFIG_NOSYSTEM=1", "HOME="+t.TempDir())
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	// 同名的分支和 tag 指向不同的 commit, 名称以 main 结尾的其它分支排在 refs/heads/main 之前
+	git("branch", "release", second)
+	git("tag", "release", first)
+	git("branch", "feature/main", first)
+	git("tag", "-a", "-m", "annotated", "v2", first)
+	downloader := &GitDownloader{Depth: 1, Protocols: "file"}
+
+	tests := []struct {
+		name     string
+		ref      string
+		revision string
+	}{
+		{"branch before a branch with the same suffix", "main", second},
+		{"branch before a tag with the same name", "release", second},
+		{"full tag name", "refs/tags/release", first},
+		{"annotated tag", "v2", first},
+		{"full annotated tag name", "refs/tags/v2", first},
+		{"lightweight tag", "v1", first},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revision, err := downloader.ResolveRevision(context.Background(), url+"#"+tt.ref, downloaderPlugin.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revision != tt.revision {
+				t.Errorf("revision = %q, want %q", revision, tt.revision)
+			}
+			// 检出的 commit 与解析的版本一致
+			result, err := downloader.DownloadContext(context.Background(), url+"#"+tt.ref, filepath.Join(t.TempDir(), "context"), downloaderPlugin.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Revision != tt.revision {
+				t.Errorf("checked out %q, want %q", result.Revision, tt.revision)
+			}
+		})
+	}
+}
@@ -2,38 +2,337 @@ package plugins
 
 import (
 	"builder/pkg/downloader/downloaderPlugin"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
+// HTTPDownloader 通过 http(s) 下载构建上下文
+// 认证信息支持 basic auth、bearer token、自定义请求头以及 tls.go 中的 TLS 配置
+// 失败时按指数退避重试, 服务端支持 Range 时从已下载的位置续传
 type HTTPDownloader struct {
+	// Retries 失败后的最大重试次数
+	Retries int
+	// Backoff 第一次重试前的等待时间, 之后每次翻倍
+	Backoff time.Duration
 }
 
-const httpType = "http"
+const (
+	httpType  = "http"
+	httpsType = "https"
+)
+
+// HTTP 认证信息中使用的 key, 与 kubernetes.io/basic-auth 类型的 Secret 一致
+const (
+	HTTPUsernameKey = "username"
+	HTTPPasswordKey = "password"
+	HTTPTokenKey    = "token"
+	// HTTPHeaderPrefix 开头的 key 作为请求头, 例如 header.X-Api-Key
+	HTTPHeaderPrefix = "header."
+)
 
 func (d *HTTPDownloader) Download(url string, destination string) error {
-	resp, err := http.Get(url)
+	_, err := d.DownloadContext(context.Background(), url, destination, downloaderPlugin.Options{})
+	return err
+}
+
+func (d *HTTPDownloader) DownloadContext(ctx context.Context, url string, destination string, opts downloaderPlugin.Options) (*downloaderPlugin.Result, error) {
+	client, err := newHTTPClient(opts.Auth)
+	if err != nil {
+		return nil, err
+	}
+	outFile, err := os.Create(destination)
+	if err != nil {
+		return nil, err
+	}
+	defer outFile.Close()
+
+	t := &httpTransfer{
+		client: client,
+		url:    url,
+		opts:   opts,
+		file:   outFile,
+		hash:   sha256.New(),
+	}
+	backoff := d.Backoff
+	for attempt := 0; ; attempt++ {
+		err := t.attempt(ctx)
+		if err == nil {
+			break
+		}
+		if attempt >= d.Retries || !retryable(ctx, err) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	result := &downloaderPlugin.Result{
+		Size:        t.written,
+		ContentType: t.contentType,
+		Digest:      downloaderPlugin.FormatDigest(t.hash),
+		Validator:   t.cacheValidator,
+	}
+	return result, downloaderPlugin.CheckResult(opts, result)
+}
+
+// httpTransfer 记录一次下载在多次尝试之间的状态
+type httpTransfer struct {
+	client *http.Client
+	url    string
+	opts   downloaderPlugin.Options
+	file   *os.File
+	hash   hash.Hash
+
+	written     int64
+	contentType string
+	// validator 为 ETag 或 Last-Modified, 续传时用于 If-Range
+	validator string
+	// cacheValidator 为 ETag 或 Last-Modified, 下次下载时用于条件请求
+	cacheValidator string
+}
+
+// attempt 发起一次请求, 已有部分内容时尝试续传
+func (t *httpTransfer) attempt(ctx context.Context) error {
+	if t.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.opts.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return &downloaderPlugin.PermanentError{Err: err}
+	}
+	if err := setAuth(req, t.opts.Auth); err != nil {
+		return &downloaderPlugin.PermanentError{Err: err}
+	}
+	for key, value := range t.opts.Headers {
+		req.Header.Set(key, value)
+	}
+	resuming := t.written > 0 && t.validator != ""
+	if resuming {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", t.written))
+		req.Header.Set("If-Range", t.validator)
+	} else if t.opts.Validator != "" {
+		setConditional(req, t.opts.Validator)
+	}
+
+	resp, err := t.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	outFile, err := os.Create(destination)
-	if err != nil {
+	switch {
+	case resp.StatusCode == http.StatusNotModified && !resuming && t.opts.Validator != "":
+		return &downloaderPlugin.PermanentError{Err: downloaderPlugin.ErrNotModified}
+	case resp.StatusCode == http.StatusPartialContent && resuming && rangeStart(resp) == t.written:
+	case resp.StatusCode == http.StatusPartialContent && resuming:
+		// 返回的范围与续传位置不一致, 丢弃已下载的内容, 下次不带 Range 从头下载
+		err := fmt.Errorf("GET %s: Content-Range %q does not resume at byte %d", t.url, resp.Header.Get("Content-Range"), t.written)
+		if resetErr := t.reset(); resetErr != nil {
+			return resetErr
+		}
+		t.validator = ""
 		return err
+	case resp.StatusCode == http.StatusPartialContent:
+		return &downloaderPlugin.PermanentError{Err: fmt.Errorf("GET %s: partial content without a Range request", t.url)}
+	case resp.StatusCode >= 200 && resp.StatusCode < 300 && resp.StatusCode != http.StatusPartialContent:
+		// 服务端返回完整内容, 从头开始写
+		if err := t.reset(); err != nil {
+			return err
+		}
+		t.contentType = resp.Header.Get("Content-Type")
+		t.validator = validator(resp)
+		t.cacheValidator = cacheValidator(resp)
+	default:
+		return statusError(t.url, resp)
 	}
-	defer outFile.Close()
 
-	_, err = io.Copy(outFile, resp.Body)
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = t.written + resp.ContentLength
+	}
+	var body io.Reader = resp.Body
+	if t.opts.MaxSize > 0 {
+		// 多读一个字节用于判断是否超出限制
+		body = io.LimitReader(resp.Body, t.opts.MaxSize-t.written+1)
+	}
+
+	w := io.MultiWriter(t.file, t.hash)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return &downloaderPlugin.PermanentError{Err: err}
+			}
+			t.written += int64(n)
+			if t.opts.Progress != nil {
+				t.opts.Progress(t.written, total)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.opts.MaxSize > 0 && t.written > t.opts.MaxSize {
+		return &downloaderPlugin.SizeLimitError{Limit: t.opts.MaxSize}
+	}
+	if total >= 0 && t.written < total {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// reset 丢弃已下载的内容
+func (t *httpTransfer) reset() error {
+	if t.written == 0 {
+		return nil
+	}
+	if err := t.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	t.hash.Reset()
+	t.written = 0
+	return nil
+}
+
+// 辅助函数：取 Content-Range 的起始位置, 无法解析时返回 -1
+func rangeStart(resp *http.Response) int64 {
+	var start, end, size int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size); err == nil {
+		return start
+	}
+	var total string
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%s", &start, &end, &total); err == nil {
+		return start
+	}
+	return -1
+}
+
+// 辅助函数：取用于 If-Range 的校验值, 弱 ETag 不能用于 Range 请求
+func validator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// 辅助函数：取用于条件请求的校验值, 优先使用 ETag
+func cacheValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// 辅助函数：根据校验值设置 If-None-Match 或 If-Modified-Since
+func setConditional(req *http.Request, validator string) {
+	if strings.HasPrefix(validator, `"`) || strings.HasPrefix(validator, `W/"`) {
+		req.Header.Set("If-None-Match", validator)
+		return
+	}
+	req.Header.Set("If-Modified-Since", validator)
+}
+
+// 辅助函数：将非预期的状态码转换为错误, 除 408 和 429 外的 4xx 不会重试
+func statusError(url string, resp *http.Response) error {
+	err := fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &downloaderPlugin.PermanentError{Err: err}
+	}
 	return err
 }
 
+// 辅助函数：判断错误是否值得重试
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var permanent *downloaderPlugin.PermanentError
+	var sizeErr *downloaderPlugin.SizeLimitError
+	return !errors.As(err, &permanent) && !errors.As(err, &sizeErr)
+}
+
+// setAuth 根据认证信息设置请求头, token 优先于用户名密码
+func setAuth(req *http.Request, auth downloaderPlugin.Auth) error {
+	for key, value := range auth {
+		if name, ok := strings.CutPrefix(key, HTTPHeaderPrefix); ok && name != "" {
+			req.Header.Set(name, value)
+		}
+	}
+
+	switch {
+	case auth[HTTPTokenKey] != "":
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(auth[HTTPTokenKey]))
+	case auth[HTTPUsernameKey] != "":
+		req.SetBasicAuth(auth[HTTPUsernameKey], auth[HTTPPasswordKey])
+	case auth[HTTPPasswordKey] != "":
+		return fmt.Errorf("%s is set without %s", HTTPPasswordKey, HTTPUsernameKey)
+	}
+	return nil
+}
+
+// newHTTPClient 创建使用认证信息中 TLS 配置的客户端
+func newHTTPClient(auth downloaderPlugin.Auth) (*http.Client, error) {
+	if len(auth) == 0 {
+		return http.DefaultClient, nil
+	}
+	tlsConfig, err := newTLSConfig(auth)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, CheckRedirect: redirectPolicy(auth)}, nil
+}
+
+// redirectPolicy 重定向到其他主机时去掉认证相关的请求头, 避免凭据泄露给第三方
+// net/http 只会去掉 Authorization 等标准请求头, header.* 指定的请求头需要自己处理
+func redirectPolicy(auth downloaderPlugin.Auth) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if req.URL.Host == via[0].URL.Host {
+			return nil
+		}
+		req.Header.Del("Authorization")
+		for key := range auth {
+			if name, ok := strings.CutPrefix(key, HTTPHeaderPrefix); ok && name != "" {
+				req.Header.Del(name)
+			}
+		}
+		return nil
+	}
+}
+
 func (d *HTTPDownloader) GetType() downloaderPlugin.PluginType {
 	return httpType
 }
 
 // 在 init 函数中注册 HTTP 下载器
 func init() {
-	downloaderPlugin.RegisterDownloader(httpType, &HTTPDownloader{})
+	downloaderPlugin.RegisterDownloader(httpType, &HTTPDownloader{Retries: 3, Backoff: time.Second})
+	downloaderPlugin.RegisterDownloader(httpsType, &HTTPDownloader{Retries: 3, Backoff: time.Second})
 }
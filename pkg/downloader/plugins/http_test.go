@@ -0,0 +1,419 @@
+package plugins
+
+import (
+	"builder/pkg/downloader/downloaderPlugin"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const httpContent = "FROM scratch\n"
+
+// download 使用 d 下载 url, 返回下载到的内容
+func download(t *testing.T, d *HTTPDownloader, url string, opts downloaderPlugin.Options) (string, *downloaderPlugin.Result, error) {
+	t.Helper()
+	destination := filepath.Join(t.TempDir(), "download")
+	result, err := d.DownloadContext(context.Background(), url, destination, opts)
+	data, readErr := os.ReadFile(destination)
+	if err == nil && readErr != nil {
+		t.Fatal(readErr)
+	}
+	return string(data), result, err
+}
+
+// authServer 只在请求带有 header 为 value 时返回内容, 否则返回 401
+func authServer(header, value string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(header) != value {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(httpContent))
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		auth    downloaderPlugin.Auth
+		wantErr bool
+	}{
+		{"basic auth", authServer("Authorization", "Basic dXNlcjpwYXNz"), downloaderPlugin.Auth{HTTPUsernameKey: "user", HTTPPasswordKey: "pass"}, false},
+		{"wrong password", authServer("Authorization", "Basic dXNlcjpwYXNz"), downloaderPlugin.Auth{HTTPUsernameKey: "user", HTTPPasswordKey: "wrong"}, true},
+		{"bearer token", authServer("Authorization", "Bearer secret"), downloaderPlugin.Auth{HTTPTokenKey: "secret\n"}, false},
+		{"token wins over basic auth", authServer("Authorization", "Bearer secret"), downloaderPlugin.Auth{HTTPTokenKey: "secret", HTTPUsernameKey: "user"}, false},
+		{"custom header", authServer("X-Api-Key", "secret"), downloaderPlugin.Auth{HTTPHeaderPrefix + "X-Api-Key": "secret"}, false},
+		{"password without username", authServer("Authorization", ""), downloaderPlugin.Auth{HTTPPasswordKey: "pass"}, true},
+		{"anonymous", authServer("Authorization", "Bearer secret"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			data, _, err := download(t, &HTTPDownloader{}, srv.URL+"/Dockerfile", downloaderPlugin.Options{Auth: tt.auth})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DownloadContext() = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && data != httpContent {
+				t.Errorf("downloaded %q, want %q", data, httpContent)
+			}
+			if err != nil && retryable(context.Background(), err) {
+				t.Errorf("authentication failure %v is retried", err)
+			}
+		})
+	}
+}
+
+func TestHTTPRedirectAuth(t *testing.T) {
+	var leaked http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Clone()
+		w.Write([]byte(httpContent))
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same-host":
+			http.Redirect(w, r, "/Dockerfile", http.StatusFound)
+		case "/other-host":
+			http.Redirect(w, r, other.URL+"/Dockerfile", http.StatusFound)
+		default:
+			authServer("X-Api-Key", "secret")(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		auth downloaderPlugin.Auth
+	}{
+		{"bearer token", downloaderPlugin.Auth{HTTPTokenKey: "secret", HTTPHeaderPrefix + "X-Api-Key": "secret"}},
+		{"basic auth", downloaderPlugin.Auth{HTTPUsernameKey: "user", HTTPPasswordKey: "pass", HTTPHeaderPrefix + "X-Api-Key": "secret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaked = nil
+			if _, _, err := download(t, &HTTPDownloader{}, srv.URL+"/other-host", downloaderPlugin.Options{Auth: tt.auth}); err != nil {
+				t.Fatal(err)
+			}
+			if leaked.Get("Authorization") != "" || leaked.Get("X-Api-Key") != "" {
+				t.Errorf("credentials were sent to another host: %v", leaked)
+			}
+		})
+	}
+
+	// 同一主机内的重定向保留认证信息
+	auth := downloaderPlugin.Auth{HTTPHeaderPrefix + "X-Api-Key": "secret"}
+	if _, _, err := download(t, &HTTPDownloader{}, srv.URL+"/same-host", downloaderPlugin.Options{Auth: auth}); err != nil {
+		t.Errorf("redirect on the same host lost the credentials: %v", err)
+	}
+}
+
+// newClientCertificate 生成自签名的客户端证书, 返回 PEM 格式的证书和私钥
+func newClientCertificate(t *testing.T) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "builder"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert,
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestHTTPTLS(t *testing.T) {
+	clientCert, certPEM, keyPEM := newClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	srv := httptest.NewUnstartedServer(authServer("", ""))
+	srv.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+	mtls := httptest.NewUnstartedServer(authServer("", ""))
+	mtls.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	mtls.StartTLS()
+	defer mtls.Close()
+
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	mtlsCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: mtls.Certificate().Raw}))
+
+	tests := []struct {
+		name    string
+		url     string
+		auth    downloaderPlugin.Auth
+		wantErr bool
+	}{
+		{"custom CA", srv.URL, downloaderPlugin.Auth{TLSCAKey: ca}, false},
+		{"unknown CA", srv.URL, nil, true},
+		{"CA without certificates", srv.URL, downloaderPlugin.Auth{TLSCAKey: "not a certificate"}, true},
+		{"insecure skip verify", srv.URL, downloaderPlugin.Auth{TLSInsecureSkipVerifyKey: "true"}, false},
+		{"client certificate", mtls.URL, downloaderPlugin.Auth{TLSCAKey: mtlsCA, TLSCertKey: certPEM, TLSKeyKey: keyPEM}, false},
+		{"missing client certificate", mtls.URL, downloaderPlugin.Auth{TLSCAKey: mtlsCA}, true},
+		{"client certificate without key", mtls.URL, downloaderPlugin.Auth{TLSCAKey: mtlsCA, TLSCertKey: certPEM}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _, err := download(t, &HTTPDownloader{}, tt.url+"/Dockerfile", downloaderPlugin.Options{Auth: tt.auth})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DownloadContext() = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && data != httpContent {
+				t.Errorf("downloaded %q, want %q", data, httpContent)
+			}
+		})
+	}
+}
+
+// rangeServer 返回带 ETag 的内容, 支持 Range 和 If-None-Match, 记录每个请求的 Range 请求头
+type rangeServer struct {
+	mu      sync.Mutex
+	content string
+	etag    string
+	// failures 之后的多少个请求返回 status
+	failures int
+	status   int
+	// truncate 不为 0 时第一个完整响应只写入这么多字节就断开
+	truncate int
+	// shift 不为 0 时 206 响应的 Content-Range 从续传位置偏移这么多字节
+	shift  int
+	ranges []string
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(s.status)
+		return
+	}
+	if r.Header.Get("If-None-Match") == s.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", s.etag)
+
+	var offset int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset); err == nil && r.Header.Get("If-Range") == s.etag {
+		start := offset - s.shift
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(s.content)-1, len(s.content)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.content)-start))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(s.content[start:]))
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+	if s.truncate > 0 {
+		// 声明的长度大于写入的内容, 客户端读到 unexpected EOF
+		w.Write([]byte(s.content[:s.truncate]))
+		s.truncate = 0
+		return
+	}
+	w.Write([]byte(s.content))
+}
+
+func (s *rangeServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func TestHTTPRetries(t *testing.T) {
+	const content = "FROM scratch\nCOPY . /app\n"
+	const backoff = 10 * time.Millisecond
+
+	tests := []struct {
+		name     string
+		server   *rangeServer
+		ranges   []string
+		wantErr  bool
+		minDelay time.Duration
+	}{
+		{"transient failures", &rangeServer{failures: 2, status: http.StatusServiceUnavailable}, []string{"", "", ""}, false, 3 * backoff},
+		{"too many requests", &rangeServer{failures: 1, status: http.StatusTooManyRequests}, []string{"", ""}, false, backoff},
+		{"retries run out", &rangeServer{failures: 4, status: http.StatusBadGateway}, []string{"", "", ""}, true, 3 * backoff},
+		{"not found is permanent", &rangeServer{failures: 1, status: http.StatusNotFound}, []string{""}, true, 0},
+		{"forbidden is permanent", &rangeServer{failures: 1, status: http.StatusForbidden}, []string{""}, true, 0},
+		{"resumed after a broken connection", &rangeServer{truncate: 5}, []string{"", "bytes=5-"}, false, backoff},
+		{"mismatched content range restarts", &rangeServer{truncate: 5, shift: 2}, []string{"", "bytes=5-", ""}, false, 3 * backoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.server
+			server.content, server.etag = content, `"v1"`
+			srv := httptest.NewServer(server)
+			defer srv.Close()
+
+			start := time.Now()
+			data, result, err := download(t, &HTTPDownloader{Retries: 2, Backoff: backoff}, srv.URL+"/Dockerfile", downloaderPlugin.Options{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DownloadContext() = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (data != content || result.Size != int64(len(content))) {
+				t.Errorf("downloaded %q (%+v), want %q", data, result, content)
+			}
+			if ranges := server.requests(); strings.Join(ranges, ",") != strings.Join(tt.ranges, ",") {
+				t.Errorf("requests with ranges %q, want %q", ranges, tt.ranges)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minDelay {
+				t.Errorf("retried after %v, want a backoff of at least %v", elapsed, tt.minDelay)
+			}
+		})
+	}
+}
+
+func TestHTTPValidator(t *testing.T) {
+	server := &rangeServer{content: httpContent, etag: `"v1"`}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	d := &HTTPDownloader{Retries: 2, Backoff: time.Millisecond}
+
+	tests := []struct {
+		name        string
+		etag        string
+		validator   string
+		notModified bool
+	}{
+		{"first download", `"v1"`, "", false},
+		{"unchanged", `"v1"`, `"v1"`, true},
+		{"changed", `"v2"`, `"v1"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.mu.Lock()
+			server.etag = tt.etag
+			server.mu.Unlock()
+
+			data, result, err := download(t, d, srv.URL+"/Dockerfile", downloaderPlugin.Options{Validator: tt.validator})
+			if errors.Is(err, downloaderPlugin.ErrNotModified) != tt.notModified {
+				t.Fatalf("DownloadContext() = %v, want not modified %v", err, tt.notModified)
+			}
+			if tt.notModified {
+				if retryable(context.Background(), err) {
+					t.Errorf("not modified response %v is retried", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data != httpContent || result.Validator != tt.etag {
+				t.Errorf("downloaded %q with validator %s, want %q and %s", data, result.Validator, httpContent, tt.etag)
+			}
+		})
+	}
+}
+
+func TestHTTPConditionalHeaders(t *testing.T) {
+	tests := []struct {
+		validator string
+		header    string
+	}{
+		{`"v1"`, "If-None-Match"},
+		{`W/"v1"`, "If-None-Match"},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", "If-Modified-Since"},
+	}
+	for _, tt := range tests {
+		var got http.Header
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Clone()
+			w.WriteHeader(http.StatusNotModified)
+		}))
+		_, _, err := download(t, &HTTPDownloader{}, srv.URL+"/Dockerfile", downloaderPlugin.Options{Validator: tt.validator})
+		srv.Close()
+		if !errors.Is(err, downloaderPlugin.ErrNotModified) {
+			t.Errorf("%s: DownloadContext() = %v, want not modified", tt.validator, err)
+		}
+		if got.Get(tt.header) != tt.validator {
+			t.Errorf("%s: request headers %v, want %s", tt.validator, got, tt.header)
+		}
+	}
+
+	// 没有条件请求时 304 是非预期的状态码
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer srv.Close()
+	if _, _, err := download(t, &HTTPDownloader{}, srv.URL+"/Dockerfile", downloaderPlugin.Options{}); err == nil || errors.Is(err, downloaderPlugin.ErrNotModified) {
+		t.Errorf("unconditional request: DownloadContext() = %v, want a status error", err)
+	}
+}
+
+func TestHTTPProgress(t *testing.T) {
+	content := strings.Repeat("x", 100<<10)
+	tests := []struct {
+		name   string
+		server *rangeServer
+	}{
+		{"single response", &rangeServer{}},
+		{"resumed", &rangeServer{truncate: 40 << 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.server
+			server.content, server.etag = content, `"v1"`
+			srv := httptest.NewServer(server)
+			defer srv.Close()
+
+			var written []int64
+			progress := func(n, total int64) {
+				if total != int64(len(content)) {
+					t.Errorf("progress total %d, want %d", total, len(content))
+				}
+				written = append(written, n)
+			}
+			if _, _, err := download(t, &HTTPDownloader{Retries: 1, Backoff: time.Millisecond}, srv.URL+"/Dockerfile", downloaderPlugin.Options{Progress: progress}); err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i < len(written); i++ {
+				if written[i] <= written[i-1] {
+					t.Fatalf("progress went from %d to %d", written[i-1], written[i])
+				}
+			}
+			if len(written) == 0 || written[len(written)-1] != int64(len(content)) {
+				t.Errorf("progress ended at %v, want %d", written, len(content))
+			}
+		})
+	}
+}
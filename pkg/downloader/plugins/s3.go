@@ -0,0 +1,260 @@
+package plugins
+
+import (
+	"builder/pkg/downloader/downloaderPlugin"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Downloader 从 S3 兼容的对象存储(例如 MinIO)下载构建上下文
+// url 格式为 s3://bucket/key, key 以 / 结尾时下载该前缀下的所有对象
+type S3Downloader struct {
+}
+
+const s3Type = "s3"
+
+// S3 认证信息中使用的 key, TLS 配置使用 tls.go 中的 key
+const (
+	S3EndpointKey     = "endpoint"
+	S3AccessKeyKey    = "accessKey"
+	S3SecretKeyKey    = "secretKey"
+	S3SessionTokenKey = "sessionToken"
+	S3RegionKey       = "region"
+	S3InsecureKey     = "insecure"
+	S3PathStyleKey    = "pathStyle"
+)
+
+const defaultS3Endpoint = "s3.amazonaws.com"
+
+func (d *S3Downloader) Download(url string, destination string) error {
+	_, err := d.DownloadContext(context.Background(), url, destination, downloaderPlugin.Options{})
+	return err
+}
+
+// DownloadContext 使用 opts.Auth 中的 endpoint、凭证和 TLS 配置下载对象
+func (d *S3Downloader) DownloadContext(ctx context.Context, url string, destination string, opts downloaderPlugin.Options) (*downloaderPlugin.Result, error) {
+	bucket, key, err := parseS3URL(url)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newS3Client(opts.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *downloaderPlugin.Result
+	if key == "" || strings.HasSuffix(key, "/") {
+		result, err = downloadS3Prefix(ctx, client, bucket, key, destination, opts.MaxSize)
+	} else {
+		result, err = downloadS3Object(ctx, client, bucket, key, destination, opts.MaxSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, downloaderPlugin.CheckResult(opts, result)
+}
+
+// ResolveRevision 使用对象的 ETag 作为版本, 前缀按其下所有对象的 key 和 ETag 计算
+func (d *S3Downloader) ResolveRevision(ctx context.Context, url string, opts downloaderPlugin.Options) (string, error) {
+	bucket, key, err := parseS3URL(url)
+	if err != nil {
+		return "", err
+	}
+	client, err := newS3Client(opts.Auth)
+	if err != nil {
+		return "", err
+	}
+
+	if key != "" && !strings.HasSuffix(key, "/") {
+		info, err := client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+		if err != nil {
+			return "", err
+		}
+		return info.ETag, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	h := sha256.New()
+	// ListObjects 按 key 的字典序返回, 结果是稳定的
+	for object := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: key, Recursive: true}) {
+		if object.Err != nil {
+			return "", object.Err
+		}
+		fmt.Fprintf(h, "%s %s\n", object.Key, object.ETag)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Upload 将 source 文件上传为 url 指向的对象, 已存在的对象会被覆盖
+// 服务端的 4xx 错误(例如 bucket 不存在或没有权限)重试也无法成功
+func (d *S3Downloader) Upload(ctx context.Context, url string, source string, opts downloaderPlugin.Options) error {
+	bucket, key, err := parseS3URL(url)
+	if err != nil {
+		return &downloaderPlugin.PermanentError{Err: err}
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		return &downloaderPlugin.PermanentError{Err: fmt.Errorf("s3 url %q names a prefix, expected an object", url)}
+	}
+	client, err := newS3Client(opts.Auth)
+	if err != nil {
+		return &downloaderPlugin.PermanentError{Err: err}
+	}
+
+	_, err = client.FPutObject(ctx, bucket, key, source, minio.PutObjectOptions{ContentType: "application/x-tar"})
+	if response := minio.ToErrorResponse(err); response.StatusCode >= 400 && response.StatusCode < 500 {
+		return &downloaderPlugin.PermanentError{Err: err}
+	}
+	return err
+}
+
+// downloadS3Object 下载单个对象到 destination 文件
+func downloadS3Object(ctx context.Context, client *minio.Client, bucket, key, destination string, maxSize int64) (*downloaderPlugin.Result, error) {
+	object, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	info, err := object.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && info.Size > maxSize {
+		return nil, &downloaderPlugin.SizeLimitError{Limit: maxSize}
+	}
+
+	outFile, err := os.Create(destination)
+	if err != nil {
+		return nil, err
+	}
+	defer outFile.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(outFile, h), object)
+	if err != nil {
+		return nil, err
+	}
+	return &downloaderPlugin.Result{
+		Size:        n,
+		ContentType: info.ContentType,
+		Digest:      downloaderPlugin.FormatDigest(h),
+	}, nil
+}
+
+// downloadS3Prefix 将 prefix 下的所有对象按相对路径保存到 destination 目录
+func downloadS3Prefix(ctx context.Context, client *minio.Client, bucket, prefix, destination string, maxSize int64) (*downloaderPlugin.Result, error) {
+	if err := os.MkdirAll(destination, 0o755); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	count := 0
+	var total int64
+	for object := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		name := path.Clean("/" + strings.TrimPrefix(object.Key, prefix))
+		if name == "/" {
+			continue
+		}
+		total += object.Size
+		if maxSize > 0 && total > maxSize {
+			return nil, &downloaderPlugin.SizeLimitError{Limit: maxSize}
+		}
+		if err := client.FGetObject(ctx, bucket, object.Key, filepath.Join(destination, filepath.FromSlash(name)), minio.GetObjectOptions{}); err != nil {
+			return nil, fmt.Errorf("download %s: %w", object.Key, err)
+		}
+		count++
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("no objects found under s3://%s/%s", bucket, prefix)
+	}
+
+	size, digest, err := downloaderPlugin.DigestPath(destination)
+	if err != nil {
+		return nil, err
+	}
+	return &downloaderPlugin.Result{Size: size, Digest: digest}, nil
+}
+
+// parseS3URL 拆分 s3://bucket/key
+func parseS3URL(rawURL string) (bucket, key string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != s3Type || u.Host == "" {
+		return "", "", fmt.Errorf("invalid s3 url %q, expected s3://bucket/key", rawURL)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// newS3Client 根据认证信息创建客户端
+func newS3Client(auth downloaderPlugin.Auth) (*minio.Client, error) {
+	endpoint := auth[S3EndpointKey]
+	if endpoint == "" {
+		endpoint = defaultS3Endpoint
+	}
+	secure := true
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		// endpoint 允许带上协议, 例如 http://minio:9000
+		secure = u.Scheme != "http"
+		endpoint = u.Host
+	}
+	if insecure, _ := strconv.ParseBool(auth[S3InsecureKey]); insecure {
+		secure = false
+	}
+
+	transport, err := minio.DefaultTransport(secure)
+	if err != nil {
+		return nil, err
+	}
+	if secure {
+		tlsConfig, err := newTLSConfig(auth)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	options := &minio.Options{
+		Secure:    secure,
+		Transport: transport,
+		Region:    auth[S3RegionKey],
+		// access key 为空时匿名访问
+		Creds: credentials.NewStaticV4(auth[S3AccessKeyKey], auth[S3SecretKeyKey], auth[S3SessionTokenKey]),
+	}
+	if pathStyle, _ := strconv.ParseBool(auth[S3PathStyleKey]); pathStyle {
+		options.BucketLookup = minio.BucketLookupPath
+	}
+	return minio.New(endpoint, options)
+}
+
+func (d *S3Downloader) GetType() downloaderPlugin.PluginType {
+	return s3Type
+}
+
+// 在 init 函数中注册 S3 下载器
+func init() {
+	downloaderPlugin.RegisterDownloader(s3Type, &S3Downloader{})
+}
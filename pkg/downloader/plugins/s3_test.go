@@ -0,0 +1,255 @@
+package plugins
+
+import (
+	"builder/pkg/downloader/downloaderPlugin"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 只实现下载器用到的 S3 API: 读取对象和 ListObjectsV2
+// bucket 之外的请求一律返回 AccessDenied
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T, bucket string, objects map[string]string) (*fakeS3, downloaderPlugin.Auth) {
+	t.Helper()
+	s := &fakeS3{bucket: bucket, objects: make(map[string][]byte)}
+	for key, content := range objects {
+		s.objects[key] = []byte(content)
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, downloaderPlugin.Auth{
+		S3EndpointKey:  srv.URL,
+		S3AccessKeyKey: "access",
+		S3SecretKeyKey: "secret",
+		S3RegionKey:    "us-east-1",
+		S3PathStyleKey: "true",
+	}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		s.error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r.URL.Query().Get("prefix"))
+	case key != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		body, ok := s.objects[key]
+		if !ok {
+			s.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(body))
+		w.Header().Set("Last-Modified", time.Unix(0, 0).UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, key, time.Unix(0, 0), bytes.NewReader(body))
+	default:
+		s.error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type object struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []object
+	}{Name: s.bucket, Prefix: prefix}
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Contents = append(result.Contents, object{
+			Key:          key,
+			LastModified: time.Unix(0, 0).UTC().Format(time.RFC3339),
+			ETag:         etag(s.objects[key]),
+			Size:         len(s.objects[key]),
+		})
+	}
+	result.KeyCount = len(keys)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (s *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func etag(body []byte) string {
+	sum := md5.Sum(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func TestParseS3URL(t *testing.T) {
+	tests := []struct {
+		url    string
+		bucket string
+		key    string
+		valid  bool
+	}{
+		{"s3://contexts/app.tar.gz", "contexts", "app.tar.gz", true},
+		{"s3://contexts/team/app/", "contexts", "team/app/", true},
+		{"s3://contexts", "contexts", "", true},
+		{"https://contexts/app.tar.gz", "", "", false},
+		{"s3:///app.tar.gz", "", "", false},
+	}
+	for _, tt := range tests {
+		bucket, key, err := parseS3URL(tt.url)
+		if (err == nil) != tt.valid {
+			t.Errorf("parseS3URL(%q) = %v, want valid %v", tt.url, err, tt.valid)
+			continue
+		}
+		if tt.valid && (bucket != tt.bucket || key != tt.key) {
+			t.Errorf("parseS3URL(%q) = %q, %q, want %q, %q", tt.url, bucket, key, tt.bucket, tt.key)
+		}
+	}
+}
+
+func TestS3Downloader(t *testing.T) {
+	_, auth := newFakeS3(t, "contexts", map[string]string{
+		"app.tar":                "tarball",
+		"team/app/Dockerfile":    "FROM scratch\n",
+		"team/app/src/main.go":   "package main\n",
+		"team/other/Dockerfile":  "FROM busybox\n",
+		"team/app-v2/Dockerfile": "FROM alpine\n",
+	})
+	downloader := &S3Downloader{}
+
+	tests := []struct {
+		name  string
+		url   string
+		files map[string]string
+	}{
+		{"object", "s3://contexts/app.tar", map[string]string{"": "tarball"}},
+		{"prefix", "s3://contexts/team/app/", map[string]string{"Dockerfile": "FROM scratch\n", "src/main.go": "package main\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination := filepath.Join(t.TempDir(), "download")
+			result, err := downloader.DownloadContext(context.Background(), tt.url, destination, downloaderPlugin.Options{Auth: auth})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.files {
+				data, err := os.ReadFile(filepath.Join(destination, filepath.FromSlash(name)))
+				if err != nil || string(data) != want {
+					t.Errorf("%q = %q, %v, want %q", name, data, err, want)
+				}
+			}
+			// team/app-v2/ 和 team/other/ 不属于 team/app/
+			files := 0
+			filepath.WalkDir(destination, func(_ string, entry fs.DirEntry, err error) error {
+				if err == nil && entry.Type().IsRegular() {
+					files++
+				}
+				return err
+			})
+			if files != len(tt.files) {
+				t.Errorf("%d files downloaded, want %d", files, len(tt.files))
+			}
+			if _, digest, err := downloaderPlugin.DigestPath(destination); err != nil || digest != result.Digest {
+				t.Errorf("result digest %s, content has %s, %v", result.Digest, digest, err)
+			}
+		})
+	}
+}
+
+func TestS3DownloaderErrors(t *testing.T) {
+	_, auth := newFakeS3(t, "contexts", map[string]string{"big": strings.Repeat("x", 100)})
+	downloader := &S3Downloader{}
+
+	tests := []struct {
+		name string
+		url  string
+		opts downloaderPlugin.Options
+		want error
+	}{
+		{"size limit", "s3://contexts/big", downloaderPlugin.Options{Auth: auth, MaxSize: 10}, &downloaderPlugin.SizeLimitError{}},
+		{"digest mismatch", "s3://contexts/big", downloaderPlugin.Options{Auth: auth, Digest: "sha256:" + strings.Repeat("0", 64)}, &downloaderPlugin.DigestMismatchError{}},
+		{"missing object", "s3://contexts/missing", downloaderPlugin.Options{Auth: auth}, nil},
+		{"empty prefix", "s3://contexts/missing/", downloaderPlugin.Options{Auth: auth}, nil},
+		{"other bucket", "s3://private/big", downloaderPlugin.Options{Auth: auth}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination := filepath.Join(t.TempDir(), "download")
+			_, err := downloader.DownloadContext(context.Background(), tt.url, destination, tt.opts)
+			if err == nil {
+				t.Fatal("download succeeded")
+			}
+			switch want := tt.want.(type) {
+			case *downloaderPlugin.SizeLimitError:
+				if !errors.As(err, &want) {
+					t.Errorf("error %v, want a size limit error", err)
+				}
+			case *downloaderPlugin.DigestMismatchError:
+				if !errors.As(err, &want) {
+					t.Errorf("error %v, want a digest mismatch", err)
+				}
+			}
+		})
+	}
+}
+
+func TestS3ResolveRevision(t *testing.T) {
+	s3, auth := newFakeS3(t, "contexts", map[string]string{"app.tar": "v1", "team/a": "a", "team/b": "b"})
+	downloader := &S3Downloader{}
+	opts := downloaderPlugin.Options{Auth: auth}
+
+	object, err := downloader.ResolveRevision(context.Background(), "s3://contexts/app.tar", opts)
+	if want := strings.Trim(etag([]byte("v1")), `"`); err != nil || object != want {
+		t.Errorf("object revision = %q, %v, want %q", object, err, want)
+	}
+	prefix, err := downloader.ResolveRevision(context.Background(), "s3://contexts/team/", opts)
+	if err != nil || prefix == "" {
+		t.Fatalf("prefix revision = %q, %v", prefix, err)
+	}
+
+	s3.mu.Lock()
+	s3.objects["team/b"] = []byte("changed")
+	s3.mu.Unlock()
+	changed, err := downloader.ResolveRevision(context.Background(), "s3://contexts/team/", opts)
+	if err != nil || changed == prefix {
+		t.Errorf("prefix revision did not change with its objects: %q, %v", changed, err)
+	}
+}
@@ -0,0 +1,45 @@
+package plugins
+
+import (
+	"builder/pkg/downloader/downloaderPlugin"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strconv"
+)
+
+// 认证信息中 TLS 相关的 key, 与 kubernetes.io/tls 类型的 Secret 一致
+const (
+	TLSCAKey                 = "ca.crt"
+	TLSCertKey               = "tls.crt"
+	TLSKeyKey                = "tls.key"
+	TLSInsecureSkipVerifyKey = "insecureSkipVerify"
+)
+
+// newTLSConfig 根据认证信息创建 TLS 配置
+// 支持自定义 CA、客户端证书以及跳过证书校验
+func newTLSConfig(auth downloaderPlugin.Auth) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca := auth[TLSCAKey]; ca != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(ca)) {
+			return nil, fmt.Errorf("no certificates found in %s", TLSCAKey)
+		}
+		config.RootCAs = pool
+	}
+
+	cert, key := auth[TLSCertKey], auth[TLSKeyKey]
+	if cert != "" || key != "" {
+		certificate, err := tls.X509KeyPair([]byte(cert), []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	config.InsecureSkipVerify, _ = strconv.ParseBool(auth[TLSInsecureSkipVerifyKey])
+	return config, nil
+}
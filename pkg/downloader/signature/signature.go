@@ -0,0 +1,181 @@
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Format 签名格式
+type Format string
+
+const (
+	// Cosign 为 cosign sign-blob 生成的 base64 签名, 公钥为 PEM 格式
+	Cosign Format = "cosign"
+	// Minisign 为 minisign 生成的 .minisig 签名
+	Minisign Format = "minisign"
+)
+
+// ErrInvalidSignature 签名与内容不匹配
+var ErrInvalidSignature = errors.New("signature does not match the content")
+
+// Verify 使用 publicKey 校验 file 的分离签名
+func Verify(format Format, publicKey []byte, signature []byte, file string) error {
+	switch format {
+	case Cosign:
+		return verifyCosign(publicKey, signature, file)
+	case Minisign:
+		return verifyMinisign(publicKey, signature, file)
+	default:
+		return fmt.Errorf("unsupported signature format %q", format)
+	}
+}
+
+// verifyCosign 校验 cosign sign-blob 的签名
+// 签名为 base64 编码, ECDSA 和 RSA 签名作用于内容的 sha256, ed25519 直接作用于内容
+func verifyCosign(publicKey []byte, signature []byte, file string) error {
+	block, _ := pem.Decode(publicKey)
+	if block == nil {
+		return fmt.Errorf("cosign public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parse cosign public key: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("decode cosign signature: %w", err)
+	}
+
+	if key, ok := key.(ed25519.PublicKey); ok {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(key, content, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	digest, err := hashFile(sha256.New(), file)
+	if err != nil {
+		return err
+	}
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, sig) {
+			return ErrInvalidSignature
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig); err != nil {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("unsupported cosign public key type %T", key)
+	}
+	return nil
+}
+
+// minisign 的签名算法
+var (
+	minisignLegacy    = []byte("Ed")
+	minisignPrehashed = []byte("ED")
+)
+
+// verifyMinisign 校验 minisign 的签名
+// 公钥为 "Ed" + 8 字节 key id + 32 字节 ed25519 公钥
+// 签名为算法 + 8 字节 key id + 64 字节签名, 之后是 trusted comment 及其全局签名
+func verifyMinisign(publicKey []byte, signature []byte, file string) error {
+	keyLines := minisignLines(publicKey)
+	if len(keyLines) < 1 {
+		return fmt.Errorf("minisign public key is empty")
+	}
+	key, err := base64.StdEncoding.DecodeString(keyLines[len(keyLines)-1])
+	if err != nil || len(key) != 42 || !bytes.Equal(key[:2], minisignLegacy) {
+		return fmt.Errorf("invalid minisign public key")
+	}
+	keyID, pub := key[2:10], ed25519.PublicKey(key[10:])
+
+	sigLines := minisignLines(signature)
+	if len(sigLines) != 3 || !strings.HasPrefix(sigLines[1], "trusted comment: ") {
+		return fmt.Errorf("invalid minisign signature file")
+	}
+	sig, err := base64.StdEncoding.DecodeString(sigLines[0])
+	if err != nil || len(sig) != 74 {
+		return fmt.Errorf("invalid minisign signature")
+	}
+	if !bytes.Equal(sig[2:10], keyID) {
+		return fmt.Errorf("minisign signature was created with another key")
+	}
+
+	var message []byte
+	switch {
+	case bytes.Equal(sig[:2], minisignPrehashed):
+		h, _ := blake2b.New512(nil)
+		message, err = hashFile(h, file)
+	case bytes.Equal(sig[:2], minisignLegacy):
+		message, err = os.ReadFile(file)
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %q", sig[:2])
+	}
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, message, sig[10:]) {
+		return ErrInvalidSignature
+	}
+
+	// 全局签名覆盖签名本身和 trusted comment, 防止 comment 被篡改
+	trustedComment := strings.TrimPrefix(sigLines[1], "trusted comment: ")
+	globalSig, err := base64.StdEncoding.DecodeString(sigLines[2])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid minisign global signature")
+	}
+	if !ed25519.Verify(pub, append(append([]byte{}, sig[10:]...), trustedComment...), globalSig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// 辅助函数：去掉 untrusted comment 和空行
+func minisignLines(content []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// 辅助函数：计算文件的摘要
+func hashFile(h hash.Hash, file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,219 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const content = "FROM scratch\n"
+
+// writeContent 将 data 写入临时文件并返回其路径
+func writeContent(t *testing.T, data string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "Dockerfile")
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// cosignKey 生成的 cosign 密钥, sign 返回 cosign sign-blob 格式的签名
+type cosignKey struct {
+	publicKey []byte
+	sign      func(data string) []byte
+}
+
+func newCosignKey(t *testing.T, algorithm string) cosignKey {
+	t.Helper()
+	var (
+		public interface{}
+		sign   func(data string) ([]byte, error)
+	)
+	switch algorithm {
+	case "ecdsa":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = &key.PublicKey
+		sign = func(data string) ([]byte, error) {
+			digest := sha256.Sum256([]byte(data))
+			return ecdsa.SignASN1(rand.Reader, key, digest[:])
+		}
+	case "rsa":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = &key.PublicKey
+		sign = func(data string) ([]byte, error) {
+			digest := sha256.Sum256([]byte(data))
+			return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		}
+	case "ed25519":
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = pub
+		sign = func(data string) ([]byte, error) {
+			return ed25519.Sign(key, []byte(data)), nil
+		}
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cosignKey{
+		publicKey: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		sign: func(data string) []byte {
+			sig, err := sign(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+		},
+	}
+}
+
+func TestVerifyCosign(t *testing.T) {
+	ecdsaKey, rsaKey, ed25519Key := newCosignKey(t, "ecdsa"), newCosignKey(t, "rsa"), newCosignKey(t, "ed25519")
+
+	tests := []struct {
+		name      string
+		publicKey []byte
+		signature []byte
+		content   string
+		invalid   bool
+		wantErr   bool
+	}{
+		{"ecdsa", ecdsaKey.publicKey, ecdsaKey.sign(content), content, false, false},
+		{"rsa", rsaKey.publicKey, rsaKey.sign(content), content, false, false},
+		{"ed25519", ed25519Key.publicKey, ed25519Key.sign(content), content, false, false},
+		{"ecdsa tampered content", ecdsaKey.publicKey, ecdsaKey.sign(content), "FROM evil\n", true, true},
+		{"rsa tampered content", rsaKey.publicKey, rsaKey.sign(content), "FROM evil\n", true, true},
+		{"ed25519 tampered content", ed25519Key.publicKey, ed25519Key.sign(content), "FROM evil\n", true, true},
+		{"ecdsa wrong key", newCosignKey(t, "ecdsa").publicKey, ecdsaKey.sign(content), content, true, true},
+		{"ed25519 wrong key", newCosignKey(t, "ed25519").publicKey, ed25519Key.sign(content), content, true, true},
+		{"key of another algorithm", rsaKey.publicKey, ecdsaKey.sign(content), content, true, true},
+		{"key is not PEM", []byte("not a key"), ecdsaKey.sign(content), content, false, true},
+		{"key is not PKIX", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("garbage")}), ecdsaKey.sign(content), content, false, true},
+		{"signature is not base64", ecdsaKey.publicKey, []byte("!!!"), content, false, true},
+		{"empty signature", ecdsaKey.publicKey, nil, content, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(Cosign, tt.publicKey, tt.signature, writeContent(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrInvalidSignature) != tt.invalid {
+				t.Errorf("Verify() = %v, want invalid signature %v", err, tt.invalid)
+			}
+		})
+	}
+}
+
+// minisignKey 生成的 minisign 密钥
+type minisignKey struct {
+	id        []byte
+	private   ed25519.PrivateKey
+	publicKey []byte
+}
+
+func newMinisignKey(t *testing.T) minisignKey {
+	t.Helper()
+	pub, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	key := append(append(append([]byte{}, minisignLegacy...), id...), pub...)
+	return minisignKey{
+		id:        id,
+		private:   private,
+		publicKey: []byte("untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(key) + "\n"),
+	}
+}
+
+// sign 返回 minisign 格式的签名文件, algorithm 为 Ed 或 ED, 签名时的 trusted comment 为 signed,
+// 写入文件的为 comment
+func (k minisignKey) sign(data, algorithm, signed, comment string) []byte {
+	message := []byte(data)
+	if algorithm == string(minisignPrehashed) {
+		sum := blake2b.Sum512(message)
+		message = sum[:]
+	}
+	sig := ed25519.Sign(k.private, message)
+	global := ed25519.Sign(k.private, append(append([]byte{}, sig...), signed...))
+	encoded := append(append(append([]byte{}, algorithm...), k.id...), sig...)
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(encoded) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	key := newMinisignKey(t)
+	const comment = "timestamp:1700000000\tfile:Dockerfile"
+
+	// 同一 key id 的另一个密钥
+	impostor := newMinisignKey(t)
+	impostor.id = key.id
+	impostor.publicKey = key.publicKey
+
+	tests := []struct {
+		name      string
+		publicKey []byte
+		signature []byte
+		content   string
+		invalid   bool
+		wantErr   bool
+	}{
+		{"prehashed", key.publicKey, key.sign(content, "ED", comment, comment), content, false, false},
+		{"legacy", key.publicKey, key.sign(content, "Ed", comment, comment), content, false, false},
+		{"tampered content", key.publicKey, key.sign(content, "ED", comment, comment), "FROM evil\n", true, true},
+		{"tampered trusted comment", key.publicKey, key.sign(content, "ED", comment, "timestamp:1800000000\tfile:Dockerfile"), content, true, true},
+		{"signed by another key with the same id", key.publicKey, impostor.sign(content, "ED", comment, comment), content, true, true},
+		{"key id mismatch", newMinisignKey(t).publicKey, key.sign(content, "ED", comment, comment), content, false, true},
+		{"unsupported algorithm", key.publicKey, key.sign(content, "XX", comment, comment), content, false, true},
+		{"malformed key", []byte("untrusted comment: key\nRWQ=\n"), key.sign(content, "ED", comment, comment), content, false, true},
+		{"empty key", nil, key.sign(content, "ED", comment, comment), content, false, true},
+		{"malformed signature", key.publicKey, []byte("untrusted comment: sig\nRUQ=\ntrusted comment: x\nRUQ=\n"), content, false, true},
+		{"missing trusted comment", key.publicKey, []byte("untrusted comment: sig\n" + minisignLines(key.sign(content, "ED", comment, comment))[0] + "\n"), content, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(Minisign, tt.publicKey, tt.signature, writeContent(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrInvalidSignature) != tt.invalid {
+				t.Errorf("Verify() = %v, want invalid signature %v", err, tt.invalid)
+			}
+		})
+	}
+}
+
+func TestVerifyUnsupportedFormat(t *testing.T) {
+	if err := Verify("gpg", nil, nil, writeContent(t, content)); err == nil {
+		t.Error("Verify() accepted an unsupported format")
+	}
+}
@@ -0,0 +1,21 @@
+package downloader
+
+import (
+	"builder/pkg/downloader/downloaderPlugin"
+	"context"
+	"fmt"
+)
+
+// Upload 使用 URL 协议对应的下载器将 source 文件上传到 rawURL
+// 下载器没有实现 downloaderPlugin.Uploader 时返回 PermanentError
+func Upload(ctx context.Context, rawURL string, source string, opts downloaderPlugin.Options) error {
+	downloader, err := Resolve("", rawURL)
+	if err != nil {
+		return &downloaderPlugin.PermanentError{Err: err}
+	}
+	uploader, ok := downloader.(downloaderPlugin.Uploader)
+	if !ok {
+		return &downloaderPlugin.PermanentError{Err: fmt.Errorf("%s can't be uploaded to", downloader.GetType())}
+	}
+	return uploader.Upload(ctx, rawURL, source, opts)
+}
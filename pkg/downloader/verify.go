@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"builder/pkg/downloader/downloaderPlugin"
+	"builder/pkg/downloader/signature"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+)
+
+// Signature 分离签名的校验信息
+type Signature struct {
+	// URL 签名文件的地址, 根据其协议选择下载器
+	URL string
+	// Format 签名格式
+	Format signature.Format
+	// PublicKey 校验签名使用的公钥
+	PublicKey []byte
+}
+
+// SignatureError 签名校验失败
+type SignatureError struct {
+	Err error
+}
+
+func (e *SignatureError) Error() string {
+	return "signature verification failed: " + e.Err.Error()
+}
+
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// 签名文件的最大字节数
+const maxSignatureSize = 64 << 10
+
+// 支持的摘要算法
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// verifyDigests 校验下载内容的摘要, digest 格式为 <algorithm>:<hex>
+func verifyDigests(download string, digests []string) error {
+	for _, expected := range digests {
+		algorithm, _, _ := strings.Cut(expected, ":")
+		newHash, ok := digestAlgorithms[algorithm]
+		if !ok {
+			return fmt.Errorf("unsupported digest algorithm %q", algorithm)
+		}
+		h := newHash()
+		if _, err := downloaderPlugin.HashPath(download, h); err != nil {
+			return err
+		}
+		actual := algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+		if !strings.EqualFold(actual, expected) {
+			return &downloaderPlugin.DigestMismatchError{Expected: expected, Actual: actual}
+		}
+	}
+	return nil
+}
+
+// verifySignature 下载签名文件并校验下载内容
+func verifySignature(ctx context.Context, download string, sig *Signature, auth downloaderPlugin.Auth) error {
+	info, err := os.Stat(download)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &SignatureError{Err: fmt.Errorf("only single file contents can be signed")}
+	}
+
+	downloader, err := Resolve("", sig.URL)
+	if err != nil {
+		return err
+	}
+	file := download + ".sig"
+	defer os.Remove(file)
+	if _, err := downloader.DownloadContext(ctx, sig.URL, file, downloaderPlugin.Options{Auth: auth, MaxSize: maxSignatureSize}); err != nil {
+		return fmt.Errorf("download signature: %w", err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	if err := signature.Verify(sig.Format, sig.PublicKey, content, download); err != nil {
+		return &SignatureError{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package downloader
+
+import (
+	"builder/pkg/downloader/downloaderPlugin"
+	"builder/pkg/downloader/signature"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyDigests(t *testing.T) {
+	const content = "FROM scratch\n"
+	sum256, sum512 := sha256.Sum256([]byte(content)), sha512.Sum512([]byte(content))
+	sha256Digest := "sha256:" + hex.EncodeToString(sum256[:])
+	sha512Digest := "sha512:" + hex.EncodeToString(sum512[:])
+	download := filepath.Join(t.TempDir(), "Dockerfile")
+	if err := os.WriteFile(download, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		digests  []string
+		mismatch bool
+		wantErr  bool
+	}{
+		{"no digests", nil, false, false},
+		{"sha256", []string{sha256Digest}, false, false},
+		{"sha512", []string{sha512Digest}, false, false},
+		{"both", []string{sha256Digest, sha512Digest}, false, false},
+		{"upper case hex", []string{"sha256:" + strings.ToUpper(hex.EncodeToString(sum256[:]))}, false, false},
+		{"sha256 mismatch", []string{"sha256:" + strings.Repeat("0", 64)}, true, true},
+		{"sha512 mismatch", []string{"sha512:" + strings.Repeat("0", 128)}, true, true},
+		{"second digest mismatches", []string{sha256Digest, "sha512:" + strings.Repeat("0", 128)}, true, true},
+		{"unsupported algorithm", []string{"md5:" + strings.Repeat("0", 32)}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyDigests(download, tt.digests)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyDigests() = %v, want error %v", err, tt.wantErr)
+			}
+			var mismatch *downloaderPlugin.DigestMismatchError
+			if errors.As(err, &mismatch) != tt.mismatch {
+				t.Errorf("verifyDigests() = %v, want digest mismatch %v", err, tt.mismatch)
+			}
+		})
+	}
+}
+
+func TestFetchSignature(t *testing.T) {
+	const content = "FROM scratch\n"
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalPKIXPublicKey(otherPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	signed := base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(content)))
+
+	tests := []struct {
+		name      string
+		served    string
+		signature string
+		publicKey []byte
+		invalid   bool
+		wantErr   bool
+	}{
+		{"valid signature", content, signed, publicKey, false, false},
+		{"tampered content", "FROM evil\n", signed, publicKey, true, true},
+		{"wrong key", content, signed, otherKey, true, true},
+		{"malformed signature", content, "!!!", publicKey, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/Dockerfile":
+					w.Write([]byte(tt.served))
+				case "/Dockerfile.sig":
+					w.Write([]byte(tt.signature))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			destination := filepath.Join(t.TempDir(), "context")
+			_, err := Fetch(context.Background(), Request{
+				URL:         srv.URL + "/Dockerfile",
+				Destination: destination,
+				Signature:   &Signature{URL: srv.URL + "/Dockerfile.sig", Format: signature.Cosign, PublicKey: tt.publicKey},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch() = %v, want error %v", err, tt.wantErr)
+			}
+			var sigErr *SignatureError
+			if err != nil && !errors.As(err, &sigErr) {
+				t.Errorf("Fetch() = %v, want a signature error", err)
+			}
+			if errors.Is(err, signature.ErrInvalidSignature) != tt.invalid {
+				t.Errorf("Fetch() = %v, want invalid signature %v", err, tt.invalid)
+			}
+			if _, statErr := os.Stat(destination); (statErr == nil) == tt.wantErr {
+				t.Errorf("context exists %v after Fetch() = %v", statErr == nil, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+
+	"k8s.io/client-go/kubernetes"
+	batchListers "k8s.io/client-go/listers/batch/v1"
+	coreListers "k8s.io/client-go/listers/core/v1"
+)
+
+type ExecutorType string
+
+// BuilderLabel 设置在为 Builder 创建的 Job、Pod 和 ConfigMap 上, 控制器只监听带有它的对象
+// 值为 Builder 的名称, 超过 63 个字符时被截断并加上哈希
+const BuilderLabel = "builder.hjjzs.xyz/builder"
+
+// ContextDirName 准备好的构建上下文在 Builder 工作目录中的目录名
+const ContextDirName = "context"
+
+// ImageDirName 导出的镜像在 Builder 工作目录中的目录名, 格式为 OCI image layout
+const ImageDirName = "image"
+
+// Build 描述交给执行器的一次构建
+type Build struct {
+	// Builder 构建所属的 Builder, 为构建创建的对象归它所有
+	Builder *builderv1.Builder
+	// Dockerfile 为 spec 中的内联 Dockerfile, Dockerfile 在上下文中时为空
+	Dockerfile []byte
+	// Destination 镜像推送的目标, 例如 registry.example.com/app:v1
+	Destination string
+	// Deadline 构建必须完成的时间, 为零值时不限制
+	Deadline time.Time
+	// Export 为 true 时执行器不推送镜像, 而是将其写入工作目录中的 ImageDirName, 由控制器推送
+	// 只在有 WorkspaceClaim 时使用
+	Export bool
+	// Cache 构建使用的层缓存, 为 nil 时不导入也不导出缓存
+	Cache *Cache
+}
+
+// 层缓存的类型, 与 BuilderSpec 中 cache.type 的取值相同
+const (
+	// CacheRegistry 从 Cache.From 中的镜像导入缓存, 导出到 Cache.To
+	CacheRegistry = "Registry"
+	// CacheVolume 缓存保存在缓存卷中的 <Builder 名称>/<Cache.Key> 目录
+	CacheVolume = "Volume"
+	// CacheInline 缓存写入推送的镜像中, 从 Cache.From 中的镜像导入
+	CacheInline = "Inline"
+	// CacheNone 不使用缓存
+	CacheNone = "None"
+)
+
+// Cache 构建使用的层缓存, 控制器已经填入默认值
+type Cache struct {
+	Type string
+	// Key 缓存的名称, 即缓存卷中的目录名
+	Key string
+	// From 导入缓存的镜像, 为空时不导入
+	From []string
+	// To 导出 Registry 缓存的镜像
+	To string
+	// Mode 导出的缓存包含的层, min 只包含最终阶段的层, max 包含所有阶段的层
+	Mode string
+}
+
+// CacheStats 构建中使用缓存和重新执行的步骤数
+type CacheStats struct {
+	Hits   int
+	Misses int
+}
+
+// Phase 构建所处的阶段
+type Phase string
+
+const (
+	// NotStarted 构建还没有开始, 需要调用 Start
+	NotStarted Phase = "NotStarted"
+	Running    Phase = "Running"
+	Succeeded  Phase = "Succeeded"
+	Failed     Phase = "Failed"
+)
+
+// Status 构建的状态
+type Status struct {
+	Phase Phase
+	// Message 说明构建失败的原因
+	Message string
+	// Digest 构建成功后推送或导出的镜像 manifest 的摘要, 执行器无法得知时为空
+	Digest string
+	// Cache 构建成功后从日志中统计的缓存使用情况, 执行器无法得知时为 nil
+	Cache *CacheStats
+}
+
+// BuildExecutor 执行构建并推送镜像的接口
+// 所有方法都应是幂等的, 控制器在每次同步时都可能调用它们
+type BuildExecutor interface {
+	// Start 开始构建, 构建已经存在时不做任何事
+	Start(ctx context.Context, build *Build) error
+	// Status 返回构建的状态
+	Status(ctx context.Context, build *Build) (*Status, error)
+	// Logs 返回构建的日志
+	Logs(ctx context.Context, build *Build) (io.ReadCloser, error)
+	// Cancel 停止构建并删除为它创建的对象, 构建不存在时不返回错误
+	Cancel(ctx context.Context, build *Build) error
+	GetType() ExecutorType
+}
+
+// Env 为执行器提供的集群访问和配置
+type Env struct {
+	KubeClient kubernetes.Interface
+	// JobLister 和 PodLister 只包含带有 BuilderLabel 的对象
+	JobLister batchListers.JobLister
+	PodLister coreListers.PodLister
+	// Namespace 构建运行以及 Secret 所在的命名空间
+	Namespace string
+	// WorkspaceClaim 保存工作目录的 PersistentVolumeClaim, 准备好的构建上下文位于
+	// <Builder 名称>/context, 为空时执行器自己获取远程上下文
+	WorkspaceClaim string
+	// WorkspaceRoot 控制器中工作目录的位置, 供在控制器中运行的执行器使用
+	WorkspaceRoot string
+	// CacheClaim 保存 Volume 缓存的 PersistentVolumeClaim, 每个缓存位于 <Builder 名称>/<Cache.Key> 目录
+	CacheClaim string
+	// KanikoImage kaniko 执行器使用的镜像
+	KanikoImage string
+	// BuildKitImage buildkit 执行器运行 buildctl 使用的镜像
+	BuildKitImage string
+	// BuildKitAddress buildkitd 的地址, 例如 tcp://buildkitd:1234
+	BuildKitAddress string
+	// Notify 通知控制器重新同步 Builder, 供不通过 Job 运行的执行器使用
+	Notify func(builder string)
+	// EnableFake 启用不运行构建的 fake 执行器, 只应在测试环境中使用
+	EnableFake bool
+}
+
+// Factory 使用 env 创建执行器, env 中没有启用该执行器时返回 nil
+type Factory func(env Env) BuildExecutor
+
+// ErrUnsupportedExecutor 没有对应的执行器
+var ErrUnsupportedExecutor = errors.New("unsupported executor")
+
+// ErrUnsupportedBuild 执行器无法完成这次构建, 重试也无法成功
+var ErrUnsupportedBuild = errors.New("build is not supported by the executor")
+
+// 执行器注册表
+var (
+	factories = make(map[ExecutorType]Factory)
+	mu        sync.Mutex
+)
+
+// RegisterExecutor 注册执行器
+func RegisterExecutor(t ExecutorType, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := factories[t]; exists {
+		panic(fmt.Sprintf("Executor %s already exists", t))
+	}
+	factories[t] = factory
+}
+
+// NewExecutors 使用 env 创建所有已注册并且启用的执行器
+func NewExecutors(env Env) map[ExecutorType]BuildExecutor {
+	mu.Lock()
+	defer mu.Unlock()
+
+	executors := make(map[ExecutorType]BuildExecutor, len(factories))
+	for t, factory := range factories {
+		if exec := factory(env); exec != nil {
+			executors[t] = exec
+		}
+	}
+	return executors
+}
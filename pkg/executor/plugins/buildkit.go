@@ -0,0 +1,226 @@
+package plugins
+
+import (
+	"bufio"
+	"builder/pkg/downloader/downloaderPlugin"
+	"builder/pkg/executor"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// BuildKitExecutor 在 Job 中使用 buildctl 交给共享的 buildkitd 构建并推送镜像, 导出时将镜像写入工作目录而不推送
+// buildctl 的 metadata 文件中的镜像摘要写入 termination message
+type BuildKitExecutor struct {
+	jobExecutor
+}
+
+const (
+	buildKitType = "buildkit"
+	// buildctl 从 DOCKER_CONFIG 读取推送镜像使用的凭证
+	buildKitDockerConfig = "/docker-config"
+	// buildKitMetadataFile buildctl 写入构建结果的文件, 位于构建容器中
+	buildKitMetadataFile = "/tmp/buildkit-metadata.json"
+)
+
+func (e *BuildKitExecutor) Start(ctx context.Context, build *executor.Build) error {
+	volumes, mounts, local, dir, name := e.contextVolumes(build)
+	output := "--output=type=image,name=" + build.Destination + ",push=true"
+	if build.Export {
+		mount, err := e.outputMount(build)
+		if err != nil {
+			return err
+		}
+		output = "--output=type=oci,dest=" + outputDir + ",tar=false,name=" + build.Destination
+		mounts = append(mounts, mount)
+	}
+	args := []string{
+		"--addr=" + e.env.BuildKitAddress,
+		"build",
+		"--frontend=dockerfile.v0",
+		"--opt=filename=" + name,
+		output,
+		"--metadata-file=" + buildKitMetadataFile,
+		// plain 输出中包含每个步骤是否使用了缓存
+		"--progress=plain",
+	}
+	switch remote := build.Builder.Spec.RemoteContext; {
+	case local:
+		args = append(args, "--local=context="+contextDir, "--local=dockerfile="+dir)
+	case remote.ContentUrl == "":
+		// 没有远程上下文时只有内联 Dockerfile, 以它所在的目录作为上下文
+		args = append(args, "--local=context="+dir, "--local=dockerfile="+dir)
+	default:
+		contextType, err := remoteContextType(remote)
+		if err != nil {
+			return err
+		}
+		if !buildKitFetches(contextType) {
+			return fmt.Errorf("%w: buildkit can't fetch %s contexts without a workspace claim", executor.ErrUnsupportedBuild, contextType)
+		}
+		// dockerfile 前端直接支持 git 仓库和 http(s) 压缩包, git 的写法同样为 <repository>#<ref>:<subdir>
+		args = append(args, "--opt=context="+remote.ContentUrl)
+		if build.Dockerfile != nil {
+			args = append(args, "--local=dockerfile="+dir)
+		}
+	}
+	values, env := buildArgs(build.Builder)
+	for _, arg := range values {
+		args = append(args, "--opt=build-arg:"+arg.String())
+	}
+	if target := build.Builder.Spec.Target; target != "" {
+		args = append(args, "--opt=target="+target)
+	}
+	for _, label := range imageLabels(build.Builder) {
+		args = append(args, "--opt=label:"+label.String())
+	}
+	if platforms := build.Builder.Spec.Platforms; len(platforms) > 0 {
+		// 多个平台时 buildkitd 为每个平台构建镜像并推送 index, 没有对应节点的平台需要 buildkitd 所在节点安装 binfmt 模拟
+		args = append(args, "--opt=platform="+strings.Join(platforms, ","))
+	}
+	if build.Builder.Spec.NoCache {
+		args = append(args, "--no-cache")
+	}
+	cacheArgs, cacheVolumes, cacheMounts, command, err := e.cache(build)
+	if err != nil {
+		return err
+	}
+	args = append(args, cacheArgs...)
+	volumes, mounts = append(volumes, cacheVolumes...), append(mounts, cacheMounts...)
+	// buildctl 读取 secret 和私钥后交给 buildkitd, 它们不会写入镜像或构建缓存
+	buildVolumes, buildMounts, secrets, ssh := buildSecrets(build.Builder)
+	for _, secret := range secrets {
+		args = append(args, "--secret=id="+secret.key+",src="+secret.value)
+	}
+	for _, key := range ssh {
+		args = append(args, "--ssh="+key.String())
+	}
+	secretVolumes, secretMounts := dockerConfig(build.Builder, buildKitDockerConfig)
+
+	container := corev1.Container{
+		Image:        e.env.BuildKitImage,
+		Command:      command,
+		Args:         args,
+		Env:          append([]corev1.EnvVar{{Name: "DOCKER_CONFIG", Value: buildKitDockerConfig}}, env...),
+		VolumeMounts: append(append(mounts, secretMounts...), buildMounts...),
+	}
+	return e.start(ctx, build, newJob(build, container, append(append(volumes, secretVolumes...), buildVolumes...)))
+}
+
+// buildKitFetches 判断 dockerfile 前端能否自己获取该类型的远程上下文, s3 等需要控制器下载到工作目录
+func buildKitFetches(contextType downloaderPlugin.PluginType) bool {
+	switch contextType {
+	case gitContextType, httpContextType, httpsContextType:
+		return true
+	}
+	return false
+}
+
+func (e *BuildKitExecutor) Status(ctx context.Context, build *executor.Build) (*executor.Status, error) {
+	return e.status(ctx, build)
+}
+
+func (e *BuildKitExecutor) Logs(ctx context.Context, build *executor.Build) (io.ReadCloser, error) {
+	return e.logs(ctx, build)
+}
+
+func (e *BuildKitExecutor) Cancel(ctx context.Context, build *executor.Build) error {
+	return e.cancel(ctx, build)
+}
+
+func (e *BuildKitExecutor) GetType() executor.ExecutorType {
+	return buildKitType
+}
+
+// buildKitScript 运行 buildctl, 成功后只将 metadata 中的镜像摘要写入 termination message
+// metadata 中还有构建来源和各平台的描述符, 很容易超过 kubelet 对 termination message 限制的 4096 字节
+const buildKitScript = `buildctl "$@" && sed -n 's/^.*"containerimage\.digest": *"\([^"]*\)".*$/\1/p' ` + buildKitMetadataFile + ` > /dev/termination-log`
+
+// buildKitVolumeCacheScript 只在缓存目录中已有缓存时导入, 第一次构建时 buildctl 无法从空目录导入
+const buildKitVolumeCacheScript = `if [ -f ` + cacheDir + `/index.json ]; then set -- "$@" --import-cache=type=local,src=` + cacheDir + `; fi; ` + buildKitScript
+
+// cache 返回导入和导出层缓存的参数和运行 buildctl 的命令, Volume 缓存还需要挂载缓存卷
+func (e *BuildKitExecutor) cache(build *executor.Build) (args []string, volumes []corev1.Volume, mounts []corev1.VolumeMount, command []string, err error) {
+	command = []string{"sh", "-c", buildKitScript, "buildctl"}
+	cache := build.Cache
+	if cache == nil {
+		return nil, nil, nil, command, nil
+	}
+	for _, from := range cache.From {
+		args = append(args, "--import-cache=type=registry,ref="+from)
+	}
+	switch cache.Type {
+	case executor.CacheRegistry:
+		args = append(args, "--export-cache=type=registry,ref="+cache.To+",mode="+cache.Mode)
+	case executor.CacheInline:
+		args = append(args, "--export-cache=type=inline")
+	case executor.CacheVolume:
+		if volumes, mounts, err = e.cacheVolumes(build); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		args = append(args, "--export-cache=type=local,dest="+cacheDir+",mode="+cache.Mode)
+		if !build.Builder.Spec.NoCache {
+			command = []string{"sh", "-c", buildKitVolumeCacheScript, "buildctl"}
+		}
+	default:
+		return nil, nil, nil, nil, fmt.Errorf("%w: unknown cache type %s", executor.ErrUnsupportedBuild, cache.Type)
+	}
+	return args, volumes, mounts, command, nil
+}
+
+// buildKitStep 匹配 plain 输出中 Dockerfile 指令的步骤, 例如 #5 [build 2/4] RUN make
+var buildKitStep = regexp.MustCompile(`^#(\d+) \[[^\]]*\d+/\d+\] (\S+)`)
+
+// 辅助函数：从 buildctl 的 plain 输出中统计使用缓存和重新执行的步骤, FROM 不计入
+func buildKitCacheStats(log io.Reader) *executor.CacheStats {
+	steps := make(map[string]bool)
+	cached := make(map[string]bool)
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(log)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := buildKitStep.FindStringSubmatch(line); match != nil {
+			if !strings.EqualFold(match[2], "FROM") {
+				steps[match[1]] = true
+			}
+			continue
+		}
+		id, rest, ok := strings.Cut(strings.TrimPrefix(line, "#"), " ")
+		if !ok || !strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case rest == "CACHED":
+			cached[id] = true
+		case strings.HasPrefix(rest, "DONE"):
+			done[id] = true
+		}
+	}
+
+	stats := &executor.CacheStats{}
+	for id := range steps {
+		switch {
+		case cached[id]:
+			stats.Hits++
+		case done[id]:
+			stats.Misses++
+		}
+	}
+	return stats
+}
+
+// 在 init 函数中注册 buildkit 执行器
+func init() {
+	executor.RegisterExecutor(buildKitType, func(env executor.Env) executor.BuildExecutor {
+		return &BuildKitExecutor{jobExecutor{
+			env:        env,
+			digest:     strings.TrimSpace,
+			cacheStats: buildKitCacheStats,
+		}}
+	})
+}
@@ -0,0 +1,121 @@
+package plugins
+
+import (
+	"builder/pkg/executor"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildKitScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	// 多平台构建的 metadata 中有每个平台的描述符和构建来源, 远大于 4096 字节
+	metadata := map[string]interface{}{
+		"buildx.build.provenance": map[string]string{"buildType": strings.Repeat("x", 8<<10)},
+		"containerimage.descriptor": map[string]interface{}{
+			"mediaType": "application/vnd.oci.image.index.v1+json",
+			"digest":    digest,
+		},
+		"containerimage.digest": digest,
+		"image.name":            "registry.example.com/app:v1",
+	}
+	indented, _ := json.MarshalIndent(metadata, "", "  ")
+	compact, _ := json.Marshal(metadata)
+
+	tests := []struct {
+		name     string
+		metadata []byte
+		exitCode int
+		message  string
+	}{
+		{"indented metadata", indented, 0, digest},
+		{"compact metadata", compact, 0, digest},
+		{"failed build", indented, 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			metadataFile := filepath.Join(dir, "metadata.json")
+			terminationLog := filepath.Join(dir, "termination-log")
+			// buildctl 写入 metadata 后以 exitCode 退出
+			buildctl := "#!/bin/sh\ncat > " + metadataFile + " <<'EOF'\n" + string(tt.metadata) + "\nEOF\nexit " + strconv.Itoa(tt.exitCode) + "\n"
+			if err := os.WriteFile(filepath.Join(dir, "buildctl"), []byte(buildctl), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			script := strings.NewReplacer(buildKitMetadataFile, metadataFile, "/dev/termination-log", terminationLog).Replace(buildKitScript)
+
+			cmd := exec.Command("sh", "-c", script, "buildctl", "build", "--progress=plain")
+			cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"))
+			exitCode := 0
+			var exitErr *exec.ExitError
+			if err := cmd.Run(); errors.As(err, &exitErr) {
+				exitCode = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if exitCode != tt.exitCode {
+				t.Errorf("script exited with %d, want %d", exitCode, tt.exitCode)
+			}
+			message, _ := os.ReadFile(terminationLog)
+			if strings.TrimSpace(string(message)) != tt.message {
+				t.Errorf("termination message %q, want %q", message, tt.message)
+			}
+		})
+	}
+}
+
+func TestBuildKitRemoteContext(t *testing.T) {
+	newBuildKit := func(env executor.Env) executor.BuildExecutor {
+		return &BuildKitExecutor{jobExecutor{env: env}}
+	}
+	tests := []struct {
+		name        string
+		contextType string
+		url         string
+		// context 为空时 buildkit 不能获取该上下文
+		context string
+	}{
+		{"git url", "", "git://example.com/app.git#main:src", "git://example.com/app.git#main:src"},
+		{"git type", "git", "https://example.com/app.git#main", "https://example.com/app.git#main"},
+		{"https tarball", "", "https://example.com/context.tar.gz", "https://example.com/context.tar.gz"},
+		{"http tarball", "", "http://example.com/context.tar.gz", "http://example.com/context.tar.gz"},
+		{"s3 url", "", "s3://bucket/context.tar.gz", ""},
+		{"s3 type", "s3", "https://s3.example.com/bucket/context.tar.gz", ""},
+		{"unknown scheme", "", "ftp://example.com/context.tar.gz", ""},
+		{"unknown type", "svn", "https://example.com/app", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &builderv1.Builder{
+				ObjectMeta: metav1.ObjectMeta{Name: "app"},
+				Spec:       builderv1.BuilderSpec{RemoteContext: builderv1.RemoteContext{Type: tt.contextType, ContentUrl: tt.url}},
+			}
+			args, err := startArgs(t, newBuildKit, builder)
+			if tt.context == "" {
+				if !errors.Is(err, executor.ErrUnsupportedBuild) {
+					t.Fatalf("Start() = %v, want %v", err, executor.ErrUnsupportedBuild)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Contains(args, "--opt=context="+tt.context) {
+				t.Errorf("args %v, want context %s", args, tt.context)
+			}
+		})
+	}
+}
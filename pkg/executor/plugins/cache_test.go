@@ -0,0 +1,78 @@
+package plugins
+
+import (
+	"builder/pkg/executor"
+	"strings"
+	"testing"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCacheStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		parse  func(log string) *executor.CacheStats
+		log    string
+		hits   int
+		misses int
+	}{
+		{"kaniko", func(log string) *executor.CacheStats { return kanikoCacheStats(strings.NewReader(log)) }, `INFO[0001] Checking for cached layer registry.example.com/cache:abc...
+INFO[0001] Using caching version of cmd: RUN apk add git
+INFO[0002] No cached layer found for cmd RUN go build ./...
+INFO[0002] Using caching version of cmd: COPY go.mod .
+`, 2, 1},
+		{"kaniko without a cache", func(log string) *executor.CacheStats { return kanikoCacheStats(strings.NewReader(log)) }, "INFO[0001] Running: [/bin/sh -c make]\n", 0, 0},
+		{"buildkit", func(log string) *executor.CacheStats { return buildKitCacheStats(strings.NewReader(log)) }, `#1 [internal] load build definition from Dockerfile
+#1 DONE 0.0s
+#4 [build 1/4] FROM docker.io/library/golang:1.23
+#4 DONE 1.2s
+#5 [build 2/4] COPY go.mod go.sum ./
+#5 CACHED
+#6 [build 3/4] RUN go mod download
+#6 CACHED
+#7 [build 4/4] RUN go build ./...
+#7 0.512 compiling
+#7 DONE 12.3s
+#8 [stage-1 2/2] COPY --from=build /app /app
+#8 DONE 0.1s
+#9 exporting to image
+#9 DONE 0.3s
+`, 2, 2},
+		{"buildkit step still running", func(log string) *executor.CacheStats { return buildKitCacheStats(strings.NewReader(log)) }, "#5 [2/2] RUN sleep 100\n#5 0.1 sleeping\n", 0, 0},
+	}
+	for _, tt := range tests {
+		stats := tt.parse(tt.log)
+		if stats.Hits != tt.hits || stats.Misses != tt.misses {
+			t.Errorf("%s: %d hits and %d misses, want %d and %d", tt.name, stats.Hits, stats.Misses, tt.hits, tt.misses)
+		}
+	}
+}
+
+func TestCacheVolumes(t *testing.T) {
+	e := &jobExecutor{env: executor.Env{CacheClaim: "cache"}}
+	subPaths := map[string]string{}
+	for _, name := range []string{"app", "other"} {
+		build := &executor.Build{
+			Builder: &builderv1.Builder{ObjectMeta: metav1.ObjectMeta{Name: name}},
+			Cache:   &executor.Cache{Type: executor.CacheVolume, Key: "shared"},
+		}
+		volumes, mounts, err := e.cacheVolumes(build)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(volumes) != 1 || volumes[0].PersistentVolumeClaim.ClaimName != "cache" || len(mounts) != 1 {
+			t.Fatalf("%s: volumes %+v and mounts %+v", name, volumes, mounts)
+		}
+		subPaths[name] = mounts[0].SubPath
+	}
+	// Builders choosing the same key still get a cache of their own
+	if subPaths["app"] != "app/shared" || subPaths["other"] != "other/shared" {
+		t.Errorf("cache directories %v, want one per Builder", subPaths)
+	}
+
+	if _, _, err := (&jobExecutor{}).cacheVolumes(&executor.Build{Cache: &executor.Cache{Type: executor.CacheVolume, Key: "app"}}); err == nil {
+		t.Error("Volume cache without a cache claim is accepted")
+	}
+}
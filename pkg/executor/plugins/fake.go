@@ -0,0 +1,188 @@
+package plugins
+
+import (
+	"builder/pkg/executor"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeExecutor 不运行任何构建, 开始后立即成功, 用于在没有构建工具的环境中测试控制器
+// 只有 Env.EnableFake 为真时才会创建, 否则 spec.executor 为 fake 的 Builder 被视为无效
+// 报告的摘要由推送目标计算得到, 并不对应真实的镜像
+// 导出时在工作目录中写入一个没有层的镜像, 有多个平台时为 index, 其摘要与报告的一致
+type FakeExecutor struct {
+	env executor.Env
+
+	mu     sync.Mutex
+	builds map[string]string
+}
+
+const fakeType = "fake"
+
+func (e *FakeExecutor) Start(ctx context.Context, build *executor.Build) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.builds[build.Builder.Name]; ok {
+		return nil
+	}
+	digest := fakeDigest(build.Destination)
+	if build.Export {
+		var err error
+		if digest, err = writeFakeLayout(filepath.Join(e.env.WorkspaceRoot, build.Builder.Name, executor.ImageDirName), build.Builder.Spec.Platforms); err != nil {
+			return err
+		}
+	}
+	e.builds[build.Builder.Name] = digest
+	if e.env.Notify != nil {
+		e.env.Notify(build.Builder.Name)
+	}
+	return nil
+}
+
+func (e *FakeExecutor) Status(ctx context.Context, build *executor.Build) (*executor.Status, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	digest, ok := e.builds[build.Builder.Name]
+	if !ok {
+		return &executor.Status{Phase: executor.NotStarted}, nil
+	}
+	return &executor.Status{Phase: executor.Succeeded, Digest: digest}, nil
+}
+
+func (e *FakeExecutor) Logs(ctx context.Context, build *executor.Build) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	digest, ok := e.builds[build.Builder.Name]
+	if !ok {
+		return nil, fmt.Errorf("build of %s has not been started", build.Builder.Name)
+	}
+	return io.NopCloser(strings.NewReader("fake build of " + build.Destination + " produced " + digest + "\n")), nil
+}
+
+func (e *FakeExecutor) Cancel(ctx context.Context, build *executor.Build) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.builds, build.Builder.Name)
+	return nil
+}
+
+func (e *FakeExecutor) GetType() executor.ExecutorType {
+	return fakeType
+}
+
+// 辅助函数：由推送目标计算的摘要
+func fakeDigest(destination string) string {
+	sum := sha256.Sum256([]byte(destination))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// 辅助函数：在 dir 中写入只有配置没有层的 OCI image layout, 返回 index.json 指向的 manifest 或 index 的摘要
+// 多个平台时每个平台一个 manifest, 由一个 index 引用, 与 buildkit 导出的多平台镜像相同
+func writeFakeLayout(dir string, platforms []string) (string, error) {
+	if len(platforms) == 0 {
+		platforms = []string{"linux/" + runtime.GOARCH}
+	}
+	blobs := make(map[string][]byte)
+	add := func(v interface{}) (map[string]interface{}, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		digest := fakeDigest(string(data))
+		blobs[digest] = data
+		return map[string]interface{}{"digest": digest, "size": len(data)}, nil
+	}
+
+	var manifests []interface{}
+	for _, platform := range platforms {
+		parts := strings.SplitN(platform, "/", 3)
+		config := map[string]interface{}{
+			"os":           parts[0],
+			"architecture": parts[1],
+			"created":      time.Now().UTC().Format(time.RFC3339),
+			"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []string{}},
+		}
+		spec := map[string]interface{}{"os": parts[0], "architecture": parts[1]}
+		if len(parts) == 3 {
+			config["variant"] = parts[2]
+			spec["variant"] = parts[2]
+		}
+		configDescriptor, err := add(config)
+		if err != nil {
+			return "", err
+		}
+		configDescriptor["mediaType"] = "application/vnd.oci.image.config.v1+json"
+		manifest, err := add(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+			"config":        configDescriptor,
+			"layers":        []interface{}{},
+		})
+		if err != nil {
+			return "", err
+		}
+		manifest["mediaType"] = "application/vnd.oci.image.manifest.v1+json"
+		manifest["platform"] = spec
+		manifests = append(manifests, manifest)
+	}
+
+	root := manifests[0].(map[string]interface{})
+	if len(manifests) > 1 {
+		var err error
+		root, err = add(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     "application/vnd.oci.image.index.v1+json",
+			"manifests":     manifests,
+		})
+		if err != nil {
+			return "", err
+		}
+		root["mediaType"] = "application/vnd.oci.image.index.v1+json"
+	} else {
+		delete(root, "platform")
+	}
+	index, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"manifests":     []interface{}{root},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	blobDir := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobDir, 0o755); err != nil {
+		return "", err
+	}
+	for digest, blob := range blobs {
+		if err := os.WriteFile(filepath.Join(blobDir, strings.TrimPrefix(digest, "sha256:")), blob, 0o644); err != nil {
+			return "", err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), index, 0o644); err != nil {
+		return "", err
+	}
+	return root["digest"].(string), nil
+}
+
+// 在 init 函数中注册 fake 执行器
+func init() {
+	executor.RegisterExecutor(fakeType, func(env executor.Env) executor.BuildExecutor {
+		if !env.EnableFake {
+			return nil
+		}
+		return &FakeExecutor{env: env, builds: make(map[string]string)}
+	})
+}
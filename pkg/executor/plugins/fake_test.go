@@ -0,0 +1,89 @@
+package plugins
+
+import (
+	"builder/pkg/executor"
+	"builder/pkg/registry"
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFakeExecutor(t *testing.T) {
+	tests := []struct {
+		name      string
+		platforms []string
+		export    bool
+		manifests []string
+	}{
+		{"pushed by the executor", nil, false, nil},
+		{"exported single platform", []string{"linux/amd64"}, true, []string{"linux/amd64"}},
+		{"exported multi platform", []string{"linux/amd64", "linux/arm/v7"}, true, []string{"linux/amd64", "linux/arm/v7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			notified := ""
+			exec := executor.NewExecutors(executor.Env{
+				WorkspaceRoot: root,
+				EnableFake:    true,
+				Notify:        func(name string) { notified = name },
+			})[fakeType]
+			build := &executor.Build{
+				Builder: &builderv1.Builder{
+					ObjectMeta: metav1.ObjectMeta{Name: "app"},
+					Spec:       builderv1.BuilderSpec{Platforms: tt.platforms},
+				},
+				Destination: "registry.example.com/app:v1",
+				Export:      tt.export,
+			}
+
+			if err := exec.Start(context.Background(), build); err != nil {
+				t.Fatal(err)
+			}
+			status, err := exec.Status(context.Background(), build)
+			if err != nil || status.Phase != executor.Succeeded || status.Digest == "" {
+				t.Fatalf("Status() = %+v, %v", status, err)
+			}
+			if notified != "app" {
+				t.Errorf("controller was notified about %q", notified)
+			}
+			if !tt.export {
+				return
+			}
+
+			layout, err := registry.ReadLayout(filepath.Join(root, "app", executor.ImageDirName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if layout.Root.Digest != status.Digest {
+				t.Errorf("reported digest %s, exported %s", status.Digest, layout.Root.Digest)
+			}
+			manifest, _, err := layout.ReadManifest(layout.Root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var platforms []string
+			for _, child := range manifest.Manifests {
+				platforms = append(platforms, child.Platform.String())
+			}
+			if len(tt.manifests) == 1 {
+				if manifest.IsIndex() {
+					t.Error("a single platform was exported as an index")
+				}
+			} else if !reflect.DeepEqual(platforms, tt.manifests) {
+				t.Errorf("index lists %v, want %v", platforms, tt.manifests)
+			}
+		})
+	}
+}
+
+func TestFakeExecutorDisabled(t *testing.T) {
+	if _, ok := executor.NewExecutors(executor.Env{})[fakeType]; ok {
+		t.Error("fake executor is available without EnableFake")
+	}
+}
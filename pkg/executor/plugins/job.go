@@ -0,0 +1,510 @@
+package plugins
+
+import (
+	"builder/pkg/downloader"
+	"builder/pkg/downloader/downloaderPlugin"
+	"builder/pkg/executor"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	buildContainerName = "build"
+	dockerConfigVolume = "docker-config"
+	defaultDockerFile  = "Dockerfile"
+
+	// 构建上下文挂载在 contextDir, 来自工作目录的 PVC 或者是一个空目录
+	contextVolume = "context"
+	contextDir    = "/workspace"
+
+	// 没有工作目录 PVC 时内联 Dockerfile 通过 ConfigMap 挂载
+	dockerfileVolume = "dockerfile"
+	dockerfileDir    = "/dockerfile"
+
+	// 导出的镜像写入 outputDir, 即工作目录 PVC 中的 <Builder 名称>/image
+	outputDir = "/output"
+
+	// Volume 缓存挂载在 cacheDir, 即缓存卷中的 <Builder 名称>/<Cache.Key> 目录
+	cacheVolume = "cache"
+	cacheDir    = "/cache"
+
+	// 构建 secret 和 SSH 私钥挂载在 buildSecretsDir 下的 secret/<id> 和 ssh/<id>
+	buildSecretsVolume = "build-secrets"
+	buildSecretsDir    = "/run/build-secrets"
+	defaultSSHID       = "default"
+)
+
+// builderKind 为 Builder 创建的对象的 owner reference 使用的 kind
+var builderKind = builderv1.SchemeGroupVersion.WithKind("Builder")
+
+// jobExecutor 在 Job 中运行构建的执行器的公共部分
+// 构建容器在 termination message 中报告推送的镜像摘要
+type jobExecutor struct {
+	env executor.Env
+	// digest 从构建容器的 termination message 中取出镜像摘要
+	digest func(message string) string
+	// cacheStats 从构建容器的日志中统计缓存的使用情况
+	cacheStats func(log io.Reader) *executor.CacheStats
+}
+
+// buildJobName 返回 Builder 的构建 Job 的名称
+func buildJobName(builder *builderv1.Builder) string {
+	return shortName("build-" + builder.Name)
+}
+
+// dockerfileConfigMapName 返回保存 Builder 内联 Dockerfile 的 ConfigMap 的名称
+func dockerfileConfigMapName(builder *builderv1.Builder) string {
+	return shortName("dockerfile-" + builder.Name)
+}
+
+// nameHashLength shortName 在截断的名称后加上的哈希的长度
+const nameHashLength = 10
+
+// 辅助函数：将名称限制在 63 个字符以内, 过长时截断并加上完整名称的哈希, 使不同的名称截断后仍然不同
+// Builder 的名称最长 253 个字符, Job 的名称和标签的值都不能超过 63 个字符
+func shortName(name string) string {
+	if len(name) <= validation.DNS1123LabelMaxLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	prefix := strings.TrimRight(name[:validation.DNS1123LabelMaxLength-nameHashLength-1], "-.")
+	return prefix + "-" + hex.EncodeToString(sum[:])[:nameHashLength]
+}
+
+// start 创建构建 Job, 需要时先创建保存内联 Dockerfile 的 ConfigMap
+func (e *jobExecutor) start(ctx context.Context, build *executor.Build, job *batchv1.Job) error {
+	if e.needsDockerfileConfigMap(build) {
+		configMaps := e.env.KubeClient.CoreV1().ConfigMaps(e.env.Namespace)
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: objectMeta(build.Builder, dockerfileConfigMapName(build.Builder)),
+			Data:       map[string]string{defaultDockerFile: string(build.Dockerfile)},
+		}
+		_, err := configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := e.env.KubeClient.BatchV1().Jobs(e.env.Namespace).Create(ctx, job, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// status 根据 Job 和 Pod 的状态判断构建的状态
+func (e *jobExecutor) status(ctx context.Context, build *executor.Build) (*executor.Status, error) {
+	job, err := e.env.JobLister.Jobs(e.env.Namespace).Get(buildJobName(build.Builder))
+	if errors.IsNotFound(err) {
+		return &executor.Status{Phase: executor.NotStarted}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !metav1.IsControlledBy(job, build.Builder) {
+		return nil, fmt.Errorf("%w: job %s already exists and is not owned by the Builder", executor.ErrUnsupportedBuild, job.Name)
+	}
+
+	pods, err := e.pods(build)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case jobCondition(job, batchv1.JobComplete):
+		status := &executor.Status{Phase: executor.Succeeded, Cache: e.buildCacheStats(ctx, build)}
+		for _, pod := range pods {
+			if message := terminationMessage(pod); message != "" {
+				status.Digest = e.digest(message)
+				break
+			}
+		}
+		return status, nil
+	case jobCondition(job, batchv1.JobFailed):
+		return &executor.Status{Phase: executor.Failed, Message: fmt.Sprintf("build job %s failed", job.Name)}, nil
+	}
+	for _, pod := range pods {
+		if failure := podFailure(pod); failure != "" {
+			return &executor.Status{Phase: executor.Failed, Message: fmt.Sprintf("build job %s can't run: %s", job.Name, failure)}, nil
+		}
+	}
+	return &executor.Status{Phase: executor.Running}, nil
+}
+
+// logs 返回最近一个构建 Pod 中构建容器的日志
+func (e *jobExecutor) logs(ctx context.Context, build *executor.Build) (io.ReadCloser, error) {
+	pods, err := e.pods(build)
+	if err != nil {
+		return nil, err
+	}
+	var latest *corev1.Pod
+	for _, pod := range pods {
+		if latest == nil || latest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			latest = pod
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("build job %s has no pods", buildJobName(build.Builder))
+	}
+	return e.env.KubeClient.CoreV1().Pods(e.env.Namespace).GetLogs(latest.Name, &corev1.PodLogOptions{Container: buildContainerName}).Stream(ctx)
+}
+
+// buildCacheStats 从构建的日志中统计缓存的使用情况, 日志无法读取时返回 nil
+func (e *jobExecutor) buildCacheStats(ctx context.Context, build *executor.Build) *executor.CacheStats {
+	if e.cacheStats == nil {
+		return nil
+	}
+	logs, err := e.logs(ctx, build)
+	if err != nil {
+		return nil
+	}
+	defer logs.Close()
+	return e.cacheStats(logs)
+}
+
+// cancel 删除构建 Job 及其 Pod 和 ConfigMap
+func (e *jobExecutor) cancel(ctx context.Context, build *executor.Build) error {
+	propagation := metav1.DeletePropagationBackground
+	err := e.env.KubeClient.BatchV1().Jobs(e.env.Namespace).Delete(ctx, buildJobName(build.Builder), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	err = e.env.KubeClient.CoreV1().ConfigMaps(e.env.Namespace).Delete(ctx, dockerfileConfigMapName(build.Builder), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (e *jobExecutor) pods(build *executor.Build) ([]*corev1.Pod, error) {
+	return e.env.PodLister.Pods(e.env.Namespace).List(labels.SelectorFromSet(labels.Set{batchv1.JobNameLabel: buildJobName(build.Builder)}))
+}
+
+// needsDockerfileConfigMap 判断内联 Dockerfile 是否需要通过 ConfigMap 交给 Job
+// 有工作目录 PVC 时内联 Dockerfile 已经写入准备好的上下文
+func (e *jobExecutor) needsDockerfileConfigMap(build *executor.Build) bool {
+	return build.Dockerfile != nil && e.env.WorkspaceClaim == ""
+}
+
+// 远程上下文的下载器类型, 与 pkg/downloader/plugins 注册的类型一致
+const (
+	gitContextType   downloaderPlugin.PluginType = "git"
+	httpContextType  downloaderPlugin.PluginType = "http"
+	httpsContextType downloaderPlugin.PluginType = "https"
+)
+
+// remoteContextType 与控制器下载上下文时一样通过下载器注册表解析远程上下文的类型
+// 没有指定 type 时由 url 的 scheme 决定
+func remoteContextType(remote builderv1.RemoteContext) (downloaderPlugin.PluginType, error) {
+	d, err := downloader.Resolve(remote.Type, remote.ContentUrl)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", executor.ErrUnsupportedBuild, err)
+	}
+	return d.GetType(), nil
+}
+
+// contextVolumes 返回挂载构建上下文和内联 Dockerfile 的卷
+// local 为 false 时构建工具自己获取远程上下文, dir 和 name 为 Dockerfile 所在的目录和文件名
+func (e *jobExecutor) contextVolumes(build *executor.Build) (volumes []corev1.Volume, mounts []corev1.VolumeMount, local bool, dir string, name string) {
+	name = build.Builder.Spec.RemoteContext.DockerFileName
+	if name == "" {
+		name = defaultDockerFile
+	}
+	dir = contextDir
+
+	switch {
+	case e.env.WorkspaceClaim != "":
+		// 控制器准备好的上下文已经校验过, 并且包含内联 Dockerfile
+		volumes = append(volumes, corev1.Volume{
+			Name: contextVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: e.env.WorkspaceClaim},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      contextVolume,
+			MountPath: contextDir,
+			SubPath:   build.Builder.Name + "/" + executor.ContextDirName,
+			ReadOnly:  true,
+		})
+		return volumes, mounts, true, dir, name
+	case build.Dockerfile == nil:
+		return nil, nil, false, dir, name
+	}
+
+	dir, name = dockerfileDir, defaultDockerFile
+	volumes = append(volumes, corev1.Volume{
+		Name: dockerfileVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: dockerfileConfigMapName(build.Builder)},
+			},
+		},
+	})
+	mounts = append(mounts, corev1.VolumeMount{
+		Name:      dockerfileVolume,
+		MountPath: dockerfileDir,
+		ReadOnly:  true,
+	})
+	if build.Builder.Spec.RemoteContext.ContentUrl != "" {
+		return volumes, mounts, false, dir, name
+	}
+
+	// 只有内联 Dockerfile 时构建空目录
+	volumes = append(volumes, corev1.Volume{
+		Name:         contextVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	mounts = append(mounts, corev1.VolumeMount{
+		Name:      contextVolume,
+		MountPath: contextDir,
+	})
+	return volumes, mounts, true, dir, name
+}
+
+// outputMount 返回导出镜像的挂载, 与构建上下文使用同一个工作目录 PVC 的卷
+func (e *jobExecutor) outputMount(build *executor.Build) (corev1.VolumeMount, error) {
+	if e.env.WorkspaceClaim == "" {
+		return corev1.VolumeMount{}, fmt.Errorf("%w: exporting the image needs a workspace claim", executor.ErrUnsupportedBuild)
+	}
+	return corev1.VolumeMount{
+		Name:      contextVolume,
+		MountPath: outputDir,
+		SubPath:   build.Builder.Name + "/" + executor.ImageDirName,
+	}, nil
+}
+
+// cacheVolumes 返回挂载 Volume 缓存的卷, 没有缓存卷时构建无法使用 Volume 缓存
+func (e *jobExecutor) cacheVolumes(build *executor.Build) ([]corev1.Volume, []corev1.VolumeMount, error) {
+	if e.env.CacheClaim == "" {
+		return nil, nil, fmt.Errorf("%w: the Volume cache needs a cache claim", executor.ErrUnsupportedBuild)
+	}
+	return []corev1.Volume{{
+		Name: cacheVolume,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: e.env.CacheClaim},
+		},
+	}}, []corev1.VolumeMount{{
+		Name:      cacheVolume,
+		MountPath: cacheDir,
+		SubPath:   build.Builder.Name + "/" + build.Cache.Key,
+	}}, nil
+}
+
+// dockerConfig 返回挂载 RegisterSecret 中 .dockerconfigjson 的卷, 没有 Secret 时返回 nil
+func dockerConfig(builder *builderv1.Builder, mountPath string) ([]corev1.Volume, []corev1.VolumeMount) {
+	secret := builder.Spec.Image.RegisterSecret
+	if secret == "" {
+		return nil, nil
+	}
+	return []corev1.Volume{{
+		Name: dockerConfigVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secret,
+				Items: []corev1.KeyToPath{{
+					Key:  corev1.DockerConfigJsonKey,
+					Path: "config.json",
+				}},
+			},
+		},
+	}}, []corev1.VolumeMount{{
+		Name:      dockerConfigVolume,
+		MountPath: mountPath,
+		ReadOnly:  true,
+	}}
+}
+
+// keyValue 构建参数或标签, 以 key=value 的形式交给构建工具
+type keyValue struct {
+	key   string
+	value string
+}
+
+func (kv keyValue) String() string {
+	return kv.key + "=" + kv.value
+}
+
+// buildArgs 返回 spec.buildArgs 中的构建参数, value 可以直接放入容器参数
+// 来自 ConfigMap 或 Secret 的值通过返回的环境变量交给构建容器, 参数中只引用 $(BUILD_ARG_<序号>),
+// 这样值不会出现在 Job 中, ConfigMap 或 Secret 不存在时 Pod 无法启动, 构建失败
+func buildArgs(builder *builderv1.Builder) ([]keyValue, []corev1.EnvVar) {
+	var args []keyValue
+	var env []corev1.EnvVar
+	for i, arg := range builder.Spec.BuildArgs {
+		if arg.ValueFrom == nil {
+			args = append(args, keyValue{arg.Name, escapeExpansion(arg.Value)})
+			continue
+		}
+		name := fmt.Sprintf("BUILD_ARG_%d", i)
+		env = append(env, corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				ConfigMapKeyRef: arg.ValueFrom.ConfigMapKeyRef,
+				SecretKeyRef:    arg.ValueFrom.SecretKeyRef,
+			},
+		})
+		args = append(args, keyValue{arg.Name, "$(" + name + ")"})
+	}
+	return args, env
+}
+
+// imageLabels 返回 spec.labels 中的标签, 按名称排序以免每次生成不同的 Job
+func imageLabels(builder *builderv1.Builder) []keyValue {
+	labels := make([]keyValue, 0, len(builder.Spec.Labels))
+	for key, value := range builder.Spec.Labels {
+		labels = append(labels, keyValue{key, escapeExpansion(value)})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].key < labels[j].key })
+	return labels
+}
+
+// buildSecrets 返回挂载 spec.secrets 和 spec.ssh 的卷, 只挂载到构建容器中, 值不会出现在 Job 中
+// secrets 和 ssh 为 id 到容器中文件路径的对应关系, 没有构建 secret 时都为空
+func buildSecrets(builder *builderv1.Builder) (volumes []corev1.Volume, mounts []corev1.VolumeMount, secrets []keyValue, ssh []keyValue) {
+	var sources []corev1.VolumeProjection
+	for _, secret := range builder.Spec.Secrets {
+		path := "secret/" + secret.ID
+		sources = append(sources, corev1.VolumeProjection{Secret: &corev1.SecretProjection{
+			LocalObjectReference: secret.SecretKeyRef.LocalObjectReference,
+			Items:                []corev1.KeyToPath{{Key: secret.SecretKeyRef.Key, Path: path}},
+			Optional:             secret.SecretKeyRef.Optional,
+		}})
+		secrets = append(secrets, keyValue{secret.ID, buildSecretsDir + "/" + path})
+	}
+	if spec := builder.Spec.SSH; spec != nil {
+		id, key := spec.ID, spec.Key
+		if id == "" {
+			id = defaultSSHID
+		}
+		if key == "" {
+			key = corev1.SSHAuthPrivateKey
+		}
+		path := "ssh/" + id
+		sources = append(sources, corev1.VolumeProjection{Secret: &corev1.SecretProjection{
+			LocalObjectReference: corev1.LocalObjectReference{Name: spec.SecretName},
+			Items:                []corev1.KeyToPath{{Key: key, Path: path}},
+		}})
+		ssh = append(ssh, keyValue{id, buildSecretsDir + "/" + path})
+	}
+	if len(sources) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	mode := int32(0o400)
+	volumes = []corev1.Volume{{
+		Name: buildSecretsVolume,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{Sources: sources, DefaultMode: &mode},
+		},
+	}}
+	mounts = []corev1.VolumeMount{{
+		Name:      buildSecretsVolume,
+		MountPath: buildSecretsDir,
+		ReadOnly:  true,
+	}}
+	return volumes, mounts, secrets, ssh
+}
+
+// 辅助函数：Kubernetes 会展开容器参数中的 $(VAR), 用户给出的值中的 $ 需要写成 $$
+func escapeExpansion(value string) string {
+	return strings.ReplaceAll(value, "$", "$$")
+}
+
+// newJob 创建运行 container 的构建 Job, 失败后不重试
+// 构建有期限时 Job 和 Pod 的 activeDeadlineSeconds 为剩余的时间
+func newJob(build *executor.Build, container corev1.Container, volumes []corev1.Volume) *batchv1.Job {
+	container.Name = buildContainerName
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		ObjectMeta: objectMeta(build.Builder, buildJobName(build.Builder)),
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{executor.BuilderLabel: shortName(build.Builder.Name)},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{container},
+					Volumes:       volumes,
+				},
+			},
+		},
+	}
+	if !build.Deadline.IsZero() {
+		seconds := int64(math.Ceil(time.Until(build.Deadline).Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		job.Spec.ActiveDeadlineSeconds = &seconds
+		job.Spec.Template.Spec.ActiveDeadlineSeconds = &seconds
+	}
+	return job
+}
+
+// 辅助函数：为 Builder 创建的对象的 metadata
+func objectMeta(builder *builderv1.Builder, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            name,
+		Labels:          map[string]string{executor.BuilderLabel: shortName(builder.Name)},
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(builder, builderKind)},
+	}
+}
+
+// 辅助函数：取成功退出的构建容器的 termination message
+func terminationMessage(pod *corev1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != buildContainerName || status.State.Terminated == nil {
+			continue
+		}
+		if status.State.Terminated.ExitCode == 0 {
+			return strings.TrimSpace(status.State.Terminated.Message)
+		}
+	}
+	return ""
+}
+
+// 辅助函数：返回构建 Pod 无法启动的原因, 例如镜像无法拉取
+// 这样的 Pod 不会失败而是一直等待, Job 也会一直运行
+// 第一次拉取失败 (ErrImagePull) 可能只是网络问题, 缺少构建参数引用的 ConfigMap 或 Secret
+// (CreateContainerConfigError) 时按 BuildArg 的约定等待它们创建, 两者都交给构建超时处理
+func podFailure(pod *corev1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		switch status.State.Waiting.Reason {
+		case "ImagePullBackOff", "InvalidImageName":
+			return fmt.Sprintf("container %s: %s: %s", status.Name, status.State.Waiting.Reason, status.State.Waiting.Message)
+		}
+	}
+	return ""
+}
+
+func jobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package plugins
+
+import (
+	"builder/pkg/executor"
+	"context"
+	"strings"
+	"testing"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// startArgs 使用 factory 创建的执行器启动 builder 的构建, 返回构建 Job 中构建容器的参数
+func startArgs(t *testing.T, factory func(env executor.Env) executor.BuildExecutor, builder *builderv1.Builder) ([]string, error) {
+	t.Helper()
+	client := kubefake.NewSimpleClientset()
+	e := factory(executor.Env{KubeClient: client, Namespace: "builder"})
+	if err := e.Start(context.Background(), &executor.Build{Builder: builder, Destination: "registry.example.com/app:v1"}); err != nil {
+		return nil, err
+	}
+	job, err := client.BatchV1().Jobs("builder").Get(context.Background(), buildJobName(builder), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job.Spec.Template.Spec.Containers[0].Args, nil
+}
+
+func TestPodFailure(t *testing.T) {
+	tests := []struct {
+		reason   string
+		terminal bool
+	}{
+		{"ContainerCreating", false},
+		// 构建参数引用的 ConfigMap 或 Secret 还不存在
+		{"CreateContainerConfigError", false},
+		{"ErrImagePull", false},
+		{"ImagePullBackOff", true},
+		{"InvalidImageName", true},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  buildContainerName,
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: tt.reason}},
+		}}}}
+		if failure := podFailure(pod); (failure != "") != tt.terminal {
+			t.Errorf("%s: podFailure() = %q, want terminal %v", tt.reason, failure, tt.terminal)
+		}
+	}
+}
+
+func TestBuildObjectNames(t *testing.T) {
+	long := strings.Repeat("a", 60)
+	tests := []struct {
+		name    string
+		builder string
+		job     string
+	}{
+		{"short name", "app", "build-app"},
+		{"name at the limit", strings.Repeat("a", 57), "build-" + strings.Repeat("a", 57)},
+		{"long name", long + "-one", ""},
+		{"long name with the same prefix", long + "-two", ""},
+		{"dotted name cut at a dot", strings.Repeat("a", 45) + "." + long, ""},
+	}
+	jobs := map[string]string{}
+	for _, tt := range tests {
+		builder := &builderv1.Builder{ObjectMeta: metav1.ObjectMeta{Name: tt.builder}}
+		job := newJob(&executor.Build{Builder: builder}, corev1.Container{}, nil)
+		if tt.job != "" && job.Name != tt.job {
+			t.Errorf("%s: job %q, want %q", tt.name, job.Name, tt.job)
+		}
+		if errs := validation.IsDNS1123Label(job.Name); len(errs) > 0 {
+			t.Errorf("%s: job name %q: %v", tt.name, job.Name, errs)
+		}
+		if errs := validation.IsDNS1123Subdomain(dockerfileConfigMapName(builder)); len(errs) > 0 {
+			t.Errorf("%s: config map name %q: %v", tt.name, dockerfileConfigMapName(builder), errs)
+		}
+		for _, labels := range []map[string]string{job.Labels, job.Spec.Template.Labels} {
+			if errs := validation.IsValidLabelValue(labels[executor.BuilderLabel]); len(errs) > 0 {
+				t.Errorf("%s: label %q: %v", tt.name, labels[executor.BuilderLabel], errs)
+			}
+		}
+		if other, ok := jobs[job.Name]; ok {
+			t.Errorf("%s: job name %q is also used for %s", tt.name, job.Name, other)
+		}
+		jobs[job.Name] = tt.name
+	}
+}
@@ -0,0 +1,196 @@
+package plugins
+
+import (
+	"bufio"
+	"builder/pkg/executor"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// KanikoExecutor 在 Job 中使用 kaniko 构建并推送镜像, 导出时将镜像写入工作目录而不推送
+// kaniko 只能构建一个平台, 不是构建节点的平台时需要节点支持模拟
+// kaniko 将镜像摘要写入 termination message
+type KanikoExecutor struct {
+	jobExecutor
+}
+
+const kanikoType = "kaniko"
+
+func (e *KanikoExecutor) Start(ctx context.Context, build *executor.Build) error {
+	platforms := build.Builder.Spec.Platforms
+	if len(platforms) > 1 {
+		return fmt.Errorf("%w: kaniko builds a single platform, multi-platform images need the buildkit executor", executor.ErrUnsupportedBuild)
+	}
+	if len(build.Builder.Spec.Secrets) > 0 || build.Builder.Spec.SSH != nil {
+		// kaniko 不支持 RUN --mount, 构建 secret 只能通过 buildkit 使用
+		return fmt.Errorf("%w: kaniko can't mount build secrets or ssh keys, they need the buildkit executor", executor.ErrUnsupportedBuild)
+	}
+	volumes, mounts, local, dir, name := e.contextVolumes(build)
+	var contextArgs []string
+	switch {
+	case local:
+		contextArgs = []string{"--context=dir://" + contextDir}
+	case build.Builder.Spec.RemoteContext.ContentUrl == "":
+		// 没有远程上下文时只有内联 Dockerfile, 以它所在的目录作为上下文
+		contextArgs = []string{"--context=dir://" + dir}
+	default:
+		var err error
+		if contextArgs, err = kanikoContext(build.Builder); err != nil {
+			return err
+		}
+	}
+	secretVolumes, secretMounts := dockerConfig(build.Builder, "/kaniko/.docker")
+
+	container := corev1.Container{
+		Image: e.env.KanikoImage,
+		Args: append(contextArgs,
+			"--dockerfile="+dir+"/"+name,
+			"--destination="+build.Destination,
+			"--digest-file=/dev/termination-log",
+		),
+		VolumeMounts: append(mounts, secretMounts...),
+	}
+	args, env := buildArgs(build.Builder)
+	for _, arg := range args {
+		container.Args = append(container.Args, "--build-arg="+arg.String())
+	}
+	container.Env = env
+	if target := build.Builder.Spec.Target; target != "" {
+		container.Args = append(container.Args, "--target="+target)
+	}
+	for _, label := range imageLabels(build.Builder) {
+		container.Args = append(container.Args, "--label="+label.String())
+	}
+	if len(platforms) == 1 {
+		container.Args = append(container.Args, "--custom-platform="+platforms[0])
+	}
+	cacheArgs, err := kanikoCache(build)
+	if err != nil {
+		return err
+	}
+	container.Args = append(container.Args, cacheArgs...)
+	if build.Export {
+		output, err := e.outputMount(build)
+		if err != nil {
+			return err
+		}
+		container.Args = append(container.Args, "--no-push", "--oci-layout-path="+outputDir)
+		container.VolumeMounts = append(container.VolumeMounts, output)
+	}
+	return e.start(ctx, build, newJob(build, container, append(volumes, secretVolumes...)))
+}
+
+func (e *KanikoExecutor) Status(ctx context.Context, build *executor.Build) (*executor.Status, error) {
+	return e.status(ctx, build)
+}
+
+func (e *KanikoExecutor) Logs(ctx context.Context, build *executor.Build) (io.ReadCloser, error) {
+	return e.logs(ctx, build)
+}
+
+func (e *KanikoExecutor) Cancel(ctx context.Context, build *executor.Build) error {
+	return e.cancel(ctx, build)
+}
+
+func (e *KanikoExecutor) GetType() executor.ExecutorType {
+	return kanikoType
+}
+
+// gitCommit 匹配完整的 commit SHA, kaniko 只能检出完整的 SHA
+var gitCommit = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// kanikoContext 将远程上下文转换为 kaniko 的构建上下文参数
+// kaniko 直接支持 http(s) 压缩包和 s3, git 仓库需要使用 git:// 协议, 写法为 git://<repository>#<ref>
+// 其中 ref 只能是 refs/heads/<branch>、refs/tags/<tag> 或 commit SHA, 子目录通过 --context-sub-path 指定
+func kanikoContext(builder *builderv1.Builder) ([]string, error) {
+	remote := builder.Spec.RemoteContext
+	contextType, err := remoteContextType(remote)
+	if err != nil {
+		return nil, err
+	}
+	if contextType != gitContextType {
+		return []string{"--context=" + remote.ContentUrl}, nil
+	}
+
+	repository, fragment, _ := strings.Cut(remote.ContentUrl, "#")
+	ref, subdir, _ := strings.Cut(fragment, ":")
+	if i := strings.Index(repository, "://"); i >= 0 {
+		repository = repository[i+3:]
+	}
+	// 控制器解析出的 commit 与下载的上下文一致, 没有时分支名补全为 refs/heads/, tag 需要写成 refs/tags/<tag>
+	switch {
+	case builder.Status.Context != nil && gitCommit.MatchString(builder.Status.Context.Revision):
+		ref = builder.Status.Context.Revision
+	case ref != "" && !strings.HasPrefix(ref, "refs/") && !gitCommit.MatchString(ref):
+		ref = "refs/heads/" + ref
+	}
+	buildContext := "git://" + repository
+	if ref != "" {
+		buildContext += "#" + ref
+	}
+	args := []string{"--context=" + buildContext}
+	if subdir = strings.Trim(subdir, "/"); subdir != "" {
+		args = append(args, "--context-sub-path="+subdir)
+	}
+	return args, nil
+}
+
+// kanikoCache 返回使用层缓存的参数, kaniko 只能将层缓存在镜像仓库中, 导入和导出使用同一个仓库
+func kanikoCache(build *executor.Build) ([]string, error) {
+	cache := build.Cache
+	switch {
+	case build.Builder.Spec.NoCache:
+		// kaniko 只在 --cache=true 时使用缓存, 显式关闭以免构建工具的默认值改变
+		return []string{"--cache=false"}, nil
+	case cache == nil:
+		return nil, nil
+	case cache.Type == executor.CacheRegistry:
+		return []string{"--cache=true", "--cache-repo=" + repositoryOf(cache.To)}, nil
+	default:
+		return nil, fmt.Errorf("%w: kaniko can't use the %s cache, only Registry", executor.ErrUnsupportedBuild, cache.Type)
+	}
+}
+
+// 辅助函数：去掉镜像引用中的标签和摘要, kaniko 在缓存仓库中按步骤使用自己的标签
+func repositoryOf(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// 辅助函数：从 kaniko 的日志中统计使用缓存和重新执行的步骤
+func kanikoCacheStats(log io.Reader) *executor.CacheStats {
+	stats := &executor.CacheStats{}
+	scanner := bufio.NewScanner(log)
+	for scanner.Scan() {
+		switch line := scanner.Text(); {
+		case strings.Contains(line, "Using caching version of cmd"):
+			stats.Hits++
+		case strings.Contains(line, "No cached layer found for cmd"):
+			stats.Misses++
+		}
+	}
+	return stats
+}
+
+// 在 init 函数中注册 kaniko 执行器
+func init() {
+	executor.RegisterExecutor(kanikoType, func(env executor.Env) executor.BuildExecutor {
+		return &KanikoExecutor{jobExecutor{
+			env:        env,
+			digest:     strings.TrimSpace,
+			cacheStats: kanikoCacheStats,
+		}}
+	})
+}
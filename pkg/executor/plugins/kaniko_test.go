@@ -0,0 +1,70 @@
+package plugins
+
+import (
+	"builder/pkg/executor"
+	"errors"
+	"strings"
+	"testing"
+
+	builderv1 "builder/pkg/apis/builder/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestKanikoContext(t *testing.T) {
+	newKaniko := func(env executor.Env) executor.BuildExecutor {
+		return &KanikoExecutor{jobExecutor{env: env}}
+	}
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+	tests := []struct {
+		name        string
+		contextType string
+		url         string
+		revision    string
+		want        []string
+	}{
+		{"https tarball", "", "https://example.com/context.tar.gz", "", []string{"--context=https://example.com/context.tar.gz"}},
+		{"s3", "", "s3://bucket/context.tar.gz", "", []string{"--context=s3://bucket/context.tar.gz"}},
+		{"git url without ref", "", "git://example.com/app.git", "", []string{"--context=git://example.com/app.git"}},
+		{"branch", "", "git://example.com/app.git#main", "", []string{"--context=git://example.com/app.git#refs/heads/main"}},
+		{"branch of a git type", "git", "https://example.com/app.git#main", "", []string{"--context=git://example.com/app.git#refs/heads/main"}},
+		{"tag", "git", "https://example.com/app.git#refs/tags/v1", "", []string{"--context=git://example.com/app.git#refs/tags/v1"}},
+		{"commit", "", "git://example.com/app.git#" + commit, "", []string{"--context=git://example.com/app.git#" + commit}},
+		{"subdirectory", "", "git://example.com/app.git#main:/src/app/", "", []string{"--context=git://example.com/app.git#refs/heads/main", "--context-sub-path=src/app"}},
+		{"subdirectory without ref", "", "git://example.com/app.git#:src", "", []string{"--context=git://example.com/app.git", "--context-sub-path=src"}},
+		{"resolved commit", "git", "https://example.com/app.git#v1:src", commit, []string{"--context=git://example.com/app.git#" + commit, "--context-sub-path=src"}},
+		{"resolved etag", "", "https://example.com/context.tar.gz", `"etag"`, []string{"--context=https://example.com/context.tar.gz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &builderv1.Builder{
+				ObjectMeta: metav1.ObjectMeta{Name: "app"},
+				Spec:       builderv1.BuilderSpec{RemoteContext: builderv1.RemoteContext{Type: tt.contextType, ContentUrl: tt.url}},
+			}
+			if tt.revision != "" {
+				builder.Status.Context = &builderv1.ContextStatus{Revision: tt.revision}
+			}
+			args, err := startArgs(t, newKaniko, builder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, arg := range args {
+				if strings.HasPrefix(arg, "--context") {
+					got = append(got, arg)
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("context args %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	builder := &builderv1.Builder{
+		ObjectMeta: metav1.ObjectMeta{Name: "app"},
+		Spec:       builderv1.BuilderSpec{RemoteContext: builderv1.RemoteContext{ContentUrl: "ftp://example.com/context.tar.gz"}},
+	}
+	if _, err := startArgs(t, newKaniko, builder); !errors.Is(err, executor.ErrUnsupportedBuild) {
+		t.Errorf("Start() = %v for an unknown scheme, want %v", err, executor.ErrUnsupportedBuild)
+	}
+}
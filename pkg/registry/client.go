@@ -0,0 +1,429 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound 仓库中没有请求的 manifest 或 blob
+var ErrNotFound = errors.New("not found in registry")
+
+// Error registry API 返回的错误
+type Error struct {
+	StatusCode int
+	// Errors registry 返回的错误码和说明, 例如 UNAUTHORIZED
+	Errors []ErrorDetail
+}
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("registry returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	messages := make([]string, 0, len(e.Errors))
+	for _, detail := range e.Errors {
+		messages = append(messages, detail.Code+": "+detail.Message)
+	}
+	return fmt.Sprintf("registry returned %d: %s", e.StatusCode, strings.Join(messages, "; "))
+}
+
+// Options 创建 Client 的选项
+type Options struct {
+	// Keychain 提供登录仓库的认证信息, 为空时匿名访问
+	Keychain Keychain
+	// Insecure 中的仓库不校验 TLS 证书, 不支持 https 时使用 http
+	Insecure []string
+	// RootCAs 校验仓库证书使用的 CA, 为空时使用系统的 CA, 用于自签名证书的仓库
+	RootCAs *x509.CertPool
+	// Timeout 单个请求的超时时间, 为 0 时不限制
+	Timeout time.Duration
+	// Retries 网络错误、429 和 5xx 响应后的最大重试次数
+	Retries int
+	// Backoff 第一次重试前的等待时间, 之后每次翻倍, 为 0 时为 1 秒
+	Backoff time.Duration
+}
+
+// Client 访问 OCI distribution (docker registry v2) API 的客户端
+// 支持 basic 认证和 bearer token 认证, 令牌按仓库和权限范围缓存
+type Client struct {
+	keychain Keychain
+	insecure map[string]bool
+	secure   *http.Client
+	// skipVerify 访问 Insecure 中的仓库使用, 不校验证书
+	skipVerify *http.Client
+	retries    int
+	backoff    time.Duration
+
+	mu sync.Mutex
+	// authorizations 按仓库和权限范围缓存的 Authorization 请求头
+	authorizations map[string]string
+	// plainHTTP 只支持 http 的 Insecure 仓库
+	plainHTTP map[string]bool
+	// blobs 推送过的 blob 所在的镜像, key 为仓库地址/摘要, 用于跨镜像挂载
+	blobs map[string]string
+}
+
+// New 创建 Client
+func New(opts Options) *Client {
+	keychain := opts.Keychain
+	if keychain == nil {
+		keychain = Anonymous
+	}
+	insecure := make(map[string]bool, len(opts.Insecure))
+	for _, registry := range opts.Insecure {
+		insecure[normalizeHost(registry)] = true
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: opts.RootCAs}
+	skipVerifyTransport := http.DefaultTransport.(*http.Transport).Clone()
+	skipVerifyTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	return &Client{
+		keychain:       keychain,
+		insecure:       insecure,
+		secure:         &http.Client{Transport: transport, Timeout: opts.Timeout},
+		skipVerify:     &http.Client{Transport: skipVerifyTransport, Timeout: opts.Timeout},
+		retries:        opts.Retries,
+		backoff:        backoff,
+		authorizations: make(map[string]string),
+		plainHTTP:      make(map[string]bool),
+		blobs:          make(map[string]string),
+	}
+}
+
+// request 描述一次 registry API 请求, body 在认证后重发时会再次调用
+type request struct {
+	method string
+	// path 以 /v2/ 开头的路径, 或者上传时 registry 返回的完整地址
+	path   string
+	header http.Header
+	body   func() (io.ReadCloser, error)
+	length int64
+}
+
+// do 发送请求, 网络错误、429 和 5xx 响应按指数退避重试
+// scopes 为令牌需要的权限范围, 例如 repository:team/app:pull,push
+func (c *Client) do(ctx context.Context, ref Reference, scopes []string, req request) (*http.Response, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.authorizedDo(ctx, ref, scopes, req)
+		if attempt >= c.retries || ctx.Err() != nil || !retryable(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			drain(resp)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable 判断失败的请求是否值得重试
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var regErr *Error
+		if errors.As(err, &regErr) {
+			return retryableStatus(regErr.StatusCode)
+		}
+		return true
+	}
+	return retryableStatus(resp.StatusCode)
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= http.StatusInternalServerError
+}
+
+// IsPermanent 判断错误是否重试也无法解决, 例如认证失败、权限不足或 layout 无效
+func IsPermanent(err error) bool {
+	var regErr *Error
+	if errors.As(err, &regErr) {
+		return !retryableStatus(regErr.StatusCode)
+	}
+	return errors.Is(err, ErrInvalidLayout) || errors.Is(err, ErrNotFound)
+}
+
+// authorizedDo 发送请求, 收到 401 时按 WWW-Authenticate 获取令牌后重发一次
+func (c *Client) authorizedDo(ctx context.Context, ref Reference, scopes []string, req request) (*http.Response, error) {
+	key := ref.Registry + " " + strings.Join(scopes, " ")
+	resp, err := c.send(ctx, ref, req, c.authorization(key))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	drain(resp)
+	authorization, err := c.authorize(ctx, ref, scopes, challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.authorizations[key] = authorization
+	c.mu.Unlock()
+	return c.send(ctx, ref, req, authorization)
+}
+
+func (c *Client) authorization(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authorizations[key]
+}
+
+// send 发送一次请求, Insecure 中的仓库在 https 不可用时改用 http
+func (c *Client) send(ctx context.Context, ref Reference, req request, authorization string) (*http.Response, error) {
+	host := ref.apiHost()
+	insecure := c.insecure[normalizeHost(ref.Registry)]
+	client := c.secure
+	if insecure {
+		client = c.skipVerify
+	}
+
+	c.mu.Lock()
+	scheme := "https"
+	if c.plainHTTP[host] {
+		scheme = "http"
+	}
+	c.mu.Unlock()
+
+	resp, err := c.sendTo(ctx, client, scheme, host, req, authorization)
+	if err != nil && insecure && scheme == "https" {
+		if resp, httpErr := c.sendTo(ctx, client, "http", host, req, authorization); httpErr == nil {
+			c.mu.Lock()
+			c.plainHTTP[host] = true
+			c.mu.Unlock()
+			return resp, nil
+		}
+	}
+	return resp, err
+}
+
+func (c *Client) sendTo(ctx context.Context, client *http.Client, scheme, host string, req request, authorization string) (*http.Response, error) {
+	target := req.path
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = scheme + "://" + host + target
+	}
+
+	var body io.ReadCloser
+	if req.body != nil {
+		var err error
+		if body, err = req.body(); err != nil {
+			return nil, err
+		}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, body)
+	if err != nil {
+		if body != nil {
+			body.Close()
+		}
+		return nil, err
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	if req.body != nil {
+		httpReq.ContentLength = req.length
+	}
+	// 上传地址可能指向其他主机上的存储, registry 的凭证只发给 registry 自己
+	if authorization != "" && sameHost(httpReq.URL, host) {
+		httpReq.Header.Set("Authorization", authorization)
+	}
+	return client.Do(httpReq)
+}
+
+// sameHost 判断 target 是否指向 host, 省略的端口按 target 协议的默认端口比较
+func sameHost(target *url.URL, host string) bool {
+	hostname, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		hostname, port = h, p
+	}
+	defaultPort := "443"
+	if target.Scheme == "http" {
+		defaultPort = "80"
+	}
+	if port == "" {
+		port = defaultPort
+	}
+	targetPort := target.Port()
+	if targetPort == "" {
+		targetPort = defaultPort
+	}
+	return strings.EqualFold(target.Hostname(), strings.Trim(hostname, "[]")) && targetPort == port
+}
+
+// authorize 按 challenge 返回 Authorization 请求头
+func (c *Client) authorize(ctx context.Context, ref Reference, scopes []string, challenge string) (string, error) {
+	credentials := c.keychain.Resolve(ref.Registry)
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if credentials == nil || credentials.Username == "" {
+			return "", &Error{StatusCode: http.StatusUnauthorized, Errors: []ErrorDetail{{Code: "UNAUTHORIZED", Message: "no credentials for " + ref.Registry}}}
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		if credentials != nil && credentials.RegistryToken != "" {
+			return "Bearer " + credentials.RegistryToken, nil
+		}
+		token, err := c.fetchToken(ctx, ref, params, scopes, credentials)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	}
+	return "", fmt.Errorf("unsupported authentication challenge %q from %s", challenge, ref.Registry)
+}
+
+// fetchToken 从 challenge 中的 realm 获取访问令牌
+// 有 IdentityToken 时按 OAuth2 的 refresh_token 方式获取, 否则使用 basic 认证或匿名获取
+func (c *Client) fetchToken(ctx context.Context, ref Reference, params map[string]string, scopes []string, credentials *Credentials) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("authentication challenge of %s has no realm", ref.Registry)
+	}
+	client := c.secure
+	if c.insecure[normalizeHost(ref.Registry)] {
+		client = c.skipVerify
+	}
+
+	var req *http.Request
+	var err error
+	if credentials != nil && credentials.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {credentials.IdentityToken},
+			"service":       {params["service"]},
+			"client_id":     {"builder"},
+			"scope":         {strings.Join(scopes, " ")},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := url.Values{}
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		for _, scope := range scopes {
+			query.Add("scope", scope)
+		}
+		separator := "?"
+		if strings.Contains(realm, "?") {
+			separator = "&"
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm+separator+query.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		if credentials != nil && credentials.Username != "" {
+			req.SetBasicAuth(credentials.Username, credentials.Password)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch token from %s: %w", realm, err)
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response from %s: %w", realm, err)
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("token response from %s contains no token", realm)
+	}
+	return token.Token, nil
+}
+
+// parseChallenge 解析 WWW-Authenticate, 例如 Bearer realm="https://auth.example.com/token",service="registry"
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		rest = strings.TrimLeft(rest, ", ")
+		name, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[name] = value[1:]
+				break
+			}
+			params[name] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+			params[name] = strings.TrimSpace(value)
+		}
+	}
+	return scheme, params
+}
+
+// responseError 将失败的响应转换为错误, 404 返回 ErrNotFound
+func responseError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	regErr := &Error{StatusCode: resp.StatusCode}
+	var body struct {
+		Errors []ErrorDetail `json:"errors"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil {
+		regErr.Errors = body.Errors
+	}
+	return regErr
+}
+
+// drain 读完并关闭响应, 以便复用连接
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
+
+// pullScope 返回读取镜像需要的权限范围
+func pullScope(ref Reference) string {
+	return "repository:" + ref.Repository + ":pull"
+}
@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DockerConfigJSONKey kubernetes.io/dockerconfigjson 类型的 Secret 中保存配置的 key
+const DockerConfigJSONKey = ".dockerconfigjson"
+
+// Credentials 登录仓库使用的认证信息
+type Credentials struct {
+	Username string
+	Password string
+	// IdentityToken 用于换取访问令牌的 refresh token, 设置后忽略用户名和密码
+	IdentityToken string
+	// RegistryToken 直接作为 bearer token 使用
+	RegistryToken string
+}
+
+// Keychain 按仓库地址查找认证信息
+type Keychain interface {
+	// Resolve 返回仓库的认证信息, 没有时返回 nil
+	Resolve(registry string) *Credentials
+}
+
+// dockerConfig docker 的 config.json 中与认证有关的部分
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// dockerConfigKeychain 由 docker 配置文件得到的 Keychain
+type dockerConfigKeychain map[string]*Credentials
+
+// ParseDockerConfig 解析 docker 的 config.json, 即 dockerconfigjson Secret 的内容
+func ParseDockerConfig(data []byte) (Keychain, error) {
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid docker config: %w", err)
+	}
+
+	keychain := dockerConfigKeychain{}
+	for host, auth := range config.Auths {
+		credentials := &Credentials{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			IdentityToken: auth.IdentityToken,
+			RegistryToken: auth.RegistryToken,
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth of %s in docker config: %w", host, err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid auth of %s in docker config: missing colon", host)
+			}
+			credentials.Username, credentials.Password = username, password
+		}
+		keychain[normalizeHost(host)] = credentials
+	}
+	return keychain, nil
+}
+
+func (k dockerConfigKeychain) Resolve(registry string) *Credentials {
+	return k[normalizeHost(registry)]
+}
+
+// normalizeHost 去掉配置中常见的协议和路径, Docker Hub 的各种写法统一为 docker.io
+func normalizeHost(host string) string {
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	switch host {
+	case "index.docker.io", dockerHubAPI, "registry.hub.docker.com":
+		return DockerHub
+	}
+	return host
+}
+
+// anonymous 不提供任何认证信息的 Keychain
+type anonymous struct{}
+
+func (anonymous) Resolve(string) *Credentials { return nil }
+
+// Anonymous 匿名访问仓库
+var Anonymous Keychain = anonymous{}
@@ -0,0 +1,39 @@
+package registry
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseDockerConfig(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pa:ss"))
+	tests := []struct {
+		name     string
+		config   string
+		registry string
+		want     *Credentials
+		valid    bool
+	}{
+		{"auth field", `{"auths":{"r.example.com":{"auth":"` + auth + `"}}}`, "r.example.com", &Credentials{Username: "user", Password: "pa:ss"}, true},
+		{"username and password", `{"auths":{"https://r.example.com/v2/":{"username":"u","password":"p"}}}`, "r.example.com", &Credentials{Username: "u", Password: "p"}, true},
+		{"docker hub alias", `{"auths":{"https://index.docker.io/v1/":{"identitytoken":"t"}}}`, DockerHub, &Credentials{IdentityToken: "t"}, true},
+		{"other registry", `{"auths":{"r.example.com":{"username":"u"}}}`, "other.example.com", nil, true},
+		{"invalid auth", `{"auths":{"r.example.com":{"auth":"!"}}}`, "", nil, false},
+		{"auth without colon", `{"auths":{"r.example.com":{"auth":"` + base64.StdEncoding.EncodeToString([]byte("user")) + `"}}}`, "", nil, false},
+		{"invalid json", `{`, "", nil, false},
+	}
+	for _, tt := range tests {
+		keychain, err := ParseDockerConfig([]byte(tt.config))
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: ParseDockerConfig() = %v, want valid %v", tt.name, err, tt.valid)
+			continue
+		}
+		if !tt.valid {
+			continue
+		}
+		got := keychain.Resolve(tt.registry)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("%s: Resolve(%q) = %+v, want %+v", tt.name, tt.registry, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidLayout 目录不是可以推送的 OCI image layout
+var ErrInvalidLayout = errors.New("invalid OCI image layout")
+
+// Layout 磁盘上的 OCI image layout, kaniko 的 --oci-layout-path 和 buildkit 的 oci 输出都使用这种格式
+type Layout struct {
+	dir string
+	// Root layout 中唯一的镜像, 指向 manifest 或 index
+	Root Descriptor
+}
+
+// ReadLayout 读取 dir 中的 OCI image layout, layout 中必须正好有一个镜像
+func ReadLayout(dir string) (*Layout, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+	}
+	var index Manifest
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("%w: index.json: %v", ErrInvalidLayout, err)
+	}
+	if len(index.Manifests) != 1 {
+		return nil, fmt.Errorf("%w: index.json lists %d images, expected 1", ErrInvalidLayout, len(index.Manifests))
+	}
+	return &Layout{dir: dir, Root: index.Manifests[0]}, nil
+}
+
+// BlobPath 返回 blob 在 layout 中的路径
+func (l *Layout) BlobPath(digest string) (string, error) {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || hex == "" || strings.ContainsAny(hex, "/\\.") {
+		return "", fmt.Errorf("%w: invalid digest %q", ErrInvalidLayout, digest)
+	}
+	return filepath.Join(l.dir, "blobs", algorithm, hex), nil
+}
+
+// ReadManifest 读取 layout 中的 manifest 或 index 并校验摘要
+func (l *Layout) ReadManifest(descriptor Descriptor) (*Manifest, []byte, error) {
+	path, err := l.BlobPath(descriptor.Digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+	}
+	if digest := digestOf(raw); digest != descriptor.Digest {
+		return nil, nil, fmt.Errorf("%w: manifest %s has digest %s", ErrInvalidLayout, descriptor.Digest, digest)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(raw, manifest); err != nil {
+		return nil, nil, fmt.Errorf("%w: manifest %s: %v", ErrInvalidLayout, descriptor.Digest, err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = descriptor.MediaType
+	}
+	return manifest, raw, nil
+}
+
+// WriteTar 将 layout 中的镜像写为 tar 格式的 OCI image layout, 可以被 docker load 或 skopeo 读取
+// 只写入 Root 引用到的 blob, 目录中的其他内容被忽略
+func (l *Layout) WriteTar(w io.Writer) error {
+	index, err := os.ReadFile(filepath.Join(l.dir, "index.json"))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+	}
+	var blobs []Descriptor
+	if err := l.collectBlobs(l.Root, map[string]bool{}, &blobs); err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)},
+		{"index.json", index},
+	}
+	for _, file := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.data))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return err
+		}
+	}
+	for _, blob := range blobs {
+		if err := l.writeBlob(tw, blob); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// collectBlobs 按深度优先收集 descriptor 及其引用的所有 blob, seen 用于去重
+func (l *Layout) collectBlobs(descriptor Descriptor, seen map[string]bool, blobs *[]Descriptor) error {
+	if seen[descriptor.Digest] {
+		return nil
+	}
+	manifest, _, err := l.ReadManifest(descriptor)
+	if err != nil {
+		return err
+	}
+	seen[descriptor.Digest] = true
+	*blobs = append(*blobs, descriptor)
+
+	if manifest.IsIndex() {
+		for _, child := range manifest.Manifests {
+			if err := l.collectBlobs(child, seen, blobs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if manifest.Config == nil {
+		return fmt.Errorf("%w: manifest %s has no config", ErrInvalidLayout, descriptor.Digest)
+	}
+	for _, blob := range append([]Descriptor{*manifest.Config}, manifest.Layers...) {
+		if !seen[blob.Digest] {
+			seen[blob.Digest] = true
+			*blobs = append(*blobs, blob)
+		}
+	}
+	return nil
+}
+
+// writeBlob 将 blob 以其在 layout 中的相对路径写入 tw
+func (l *Layout) writeBlob(tw *tar.Writer, blob Descriptor) error {
+	path, err := l.BlobPath(blob.Digest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	name, err := filepath.Rel(l.dir, path)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: filepath.ToSlash(name), Mode: 0o644, Size: info.Size()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
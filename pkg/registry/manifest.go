@@ -0,0 +1,254 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// manifest 和 index 的媒体类型
+const (
+	MediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// manifestAccept 请求 manifest 时接受的媒体类型
+var manifestAccept = strings.Join([]string{MediaTypeOCIIndex, MediaTypeDockerList, MediaTypeOCIManifest, MediaTypeDockerManifest}, ", ")
+
+const (
+	// maxManifestSize manifest 大小的上限, 与 distribution 的限制相同
+	maxManifestSize = 4 << 20
+	// maxConfigSize 镜像配置大小的上限
+	maxConfigSize = 16 << 20
+)
+
+// Descriptor 指向 manifest 或 blob 的描述符
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *PlatformSpec     `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// PlatformSpec index 中描述符的平台
+type PlatformSpec struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p PlatformSpec) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Manifest 镜像 manifest 或 index, 两者的字段合并在一起
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        *Descriptor  `json:"config,omitempty"`
+	Layers        []Descriptor `json:"layers,omitempty"`
+	Manifests     []Descriptor `json:"manifests,omitempty"`
+}
+
+// IsIndex 判断是否为多平台镜像的 index
+func (m *Manifest) IsIndex() bool {
+	return m.MediaType == MediaTypeOCIIndex || m.MediaType == MediaTypeDockerList || (m.Config == nil && m.Manifests != nil)
+}
+
+// Platform 多平台镜像中的一个平台
+type Platform struct {
+	PlatformSpec
+	// Digest 该平台 manifest 的摘要
+	Digest string
+	// Size 该平台 manifest、配置和层的大小之和
+	Size int64
+	// Created 镜像配置中的创建时间
+	Created time.Time
+}
+
+// Image 仓库中的镜像
+type Image struct {
+	// Digest 标签指向的 manifest 或 index 的摘要
+	Digest    string
+	MediaType string
+	// Size 所有平台的 manifest、配置和层的大小之和, 多个平台共用的层重复计算
+	Size int64
+	// Platforms 镜像包含的平台, 单平台镜像只有一个
+	Platforms []Platform
+	// Created 各平台中最晚的创建时间
+	Created time.Time
+}
+
+// Architectures 返回各平台的名称, 例如 linux/amd64
+func (i *Image) Architectures() []string {
+	architectures := make([]string, 0, len(i.Platforms))
+	for _, platform := range i.Platforms {
+		architectures = append(architectures, platform.String())
+	}
+	return architectures
+}
+
+// imageConfig 镜像配置中用到的字段
+type imageConfig struct {
+	OS           string    `json:"os"`
+	Architecture string    `json:"architecture"`
+	Variant      string    `json:"variant,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// Inspect 在仓库中查找 ref 并返回镜像的信息, 镜像不存在时返回 ErrNotFound
+func (c *Client) Inspect(ctx context.Context, ref Reference) (*Image, error) {
+	manifest, raw, digest, err := c.GetManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	image := &Image{Digest: digest, MediaType: manifest.MediaType, Size: int64(len(raw))}
+
+	if !manifest.IsIndex() {
+		platform, err := c.inspectManifest(ctx, ref, manifest, raw, digest)
+		if err != nil {
+			return nil, err
+		}
+		image.Size = platform.Size
+		image.Platforms = []Platform{*platform}
+		image.Created = platform.Created
+		return image, nil
+	}
+
+	for _, descriptor := range manifest.Manifests {
+		if isAttestation(descriptor) {
+			continue
+		}
+		child, childRaw, _, err := c.GetManifest(ctx, ref.WithDigest(descriptor.Digest))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get manifest %s: %w", descriptor.Digest, err)
+		}
+		platform, err := c.inspectManifest(ctx, ref, child, childRaw, descriptor.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if descriptor.Platform != nil && descriptor.Platform.Architecture != "" {
+			platform.PlatformSpec = *descriptor.Platform
+		}
+		image.Size += platform.Size
+		image.Platforms = append(image.Platforms, *platform)
+		if platform.Created.After(image.Created) {
+			image.Created = platform.Created
+		}
+	}
+	return image, nil
+}
+
+// inspectManifest 读取单平台 manifest 的配置
+func (c *Client) inspectManifest(ctx context.Context, ref Reference, manifest *Manifest, raw []byte, digest string) (*Platform, error) {
+	if manifest.Config == nil {
+		return nil, fmt.Errorf("manifest %s has no config", digest)
+	}
+	platform := &Platform{Digest: digest, Size: int64(len(raw)) + manifest.Config.Size}
+	for _, layer := range manifest.Layers {
+		platform.Size += layer.Size
+	}
+
+	data, err := c.GetBlob(ctx, ref, manifest.Config.Digest, maxConfigSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config %s: %w", manifest.Config.Digest, err)
+	}
+	var config imageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", manifest.Config.Digest, err)
+	}
+	platform.PlatformSpec = PlatformSpec{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+	platform.Created = config.Created
+	return platform, nil
+}
+
+// isAttestation 判断 index 中的描述符是否为 buildkit 生成的证明而不是镜像
+func isAttestation(descriptor Descriptor) bool {
+	if descriptor.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+		return true
+	}
+	return descriptor.Platform != nil && descriptor.Platform.OS == "unknown" && descriptor.Platform.Architecture == "unknown"
+}
+
+// GetManifest 获取 ref 指向的 manifest 或 index, 返回解析后的内容、原始内容和摘要
+func (c *Client) GetManifest(ctx context.Context, ref Reference) (*Manifest, []byte, string, error) {
+	resp, err := c.do(ctx, ref, []string{pullScope(ref)}, request{
+		method: http.MethodGet,
+		path:   "/v2/" + ref.Repository + "/manifests/" + ref.Identifier(),
+		header: http.Header{"Accept": {manifestAccept}},
+	})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, "", responseError(resp)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if len(raw) > maxManifestSize {
+		return nil, nil, "", fmt.Errorf("manifest of %s is larger than %d bytes", ref, maxManifestSize)
+	}
+	digest := digestOf(raw)
+	if ref.Digest != "" && ref.Digest != digest {
+		return nil, nil, "", fmt.Errorf("manifest of %s has digest %s", ref, digest)
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(raw, manifest); err != nil {
+		return nil, nil, "", fmt.Errorf("invalid manifest of %s: %w", ref, err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = resp.Header.Get("Content-Type")
+	}
+	return manifest, raw, digest, nil
+}
+
+// GetBlob 读取仓库中不超过 limit 字节的 blob 并校验摘要
+func (c *Client) GetBlob(ctx context.Context, ref Reference, digest string, limit int64) ([]byte, error) {
+	resp, err := c.do(ctx, ref, []string{pullScope(ref)}, request{
+		method: http.MethodGet,
+		path:   "/v2/" + ref.Repository + "/blobs/" + digest,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("blob %s is larger than %d bytes", digest, limit)
+	}
+	if actual := digestOf(data); actual != digest {
+		return nil, fmt.Errorf("blob %s has digest %s", digest, actual)
+	}
+	return data, nil
+}
+
+// digestOf 返回内容的 sha256 摘要
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInspect(t *testing.T) {
+	registry := newFakeRegistry(t)
+	client := registry.client(nil)
+
+	tests := []struct {
+		name          string
+		tag           string
+		platforms     []string
+		architectures []string
+	}{
+		{"single platform", "single", []string{"linux/amd64"}, []string{"linux/amd64"}},
+		{"multi platform", "multi", []string{"linux/amd64", "linux/arm64", "linux/arm/v7"}, []string{"linux/amd64", "linux/arm64", "linux/arm/v7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout := writeLayout(t, tt.platforms...)
+			ref := registry.ref(t, "team/app:"+tt.tag)
+			if _, err := client.Push(context.Background(), ref, layout, PushOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			image, err := client.Inspect(context.Background(), ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image.Digest != layout.Root.Digest {
+				t.Errorf("digest %s, want %s", image.Digest, layout.Root.Digest)
+			}
+			if architectures := image.Architectures(); !reflect.DeepEqual(architectures, tt.architectures) {
+				t.Errorf("architectures %v, want %v", architectures, tt.architectures)
+			}
+			if created := time.Date(2024, 1, len(tt.platforms), 0, 0, 0, 0, time.UTC); !image.Created.Equal(created) {
+				t.Errorf("created %v, want the latest platform %v", image.Created, created)
+			}
+			// index 本身的大小也计算在内
+			var size int64
+			if len(tt.platforms) > 1 {
+				size = layout.Root.Size
+			}
+			for _, platform := range image.Platforms {
+				size += platform.Size
+			}
+			if image.Size != size || size == 0 {
+				t.Errorf("size %d, want %d", image.Size, size)
+			}
+
+			byDigest, err := client.Inspect(context.Background(), ref.WithDigest(image.Digest))
+			if err != nil || byDigest.Digest != image.Digest {
+				t.Errorf("inspect by digest = %v, %v", byDigest, err)
+			}
+		})
+	}
+}
+
+func TestInspectNotFound(t *testing.T) {
+	registry := newFakeRegistry(t)
+	_, err := registry.client(nil).Inspect(context.Background(), registry.ref(t, "team/missing:v1"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Inspect() = %v, want ErrNotFound", err)
+	}
+	if !IsPermanent(err) {
+		t.Error("a missing image is retried")
+	}
+}
+
+func TestIsAttestation(t *testing.T) {
+	tests := []struct {
+		descriptor Descriptor
+		want       bool
+	}{
+		{Descriptor{Platform: &PlatformSpec{OS: "linux", Architecture: "amd64"}}, false},
+		{Descriptor{Platform: &PlatformSpec{OS: "unknown", Architecture: "unknown"}}, true},
+		{Descriptor{Annotations: map[string]string{"vnd.docker.reference.type": "attestation-manifest"}}, true},
+		{Descriptor{}, false},
+	}
+	for i, tt := range tests {
+		if got := isAttestation(tt.descriptor); got != tt.want {
+			t.Errorf("%d: isAttestation() = %v, want %v", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// PushOptions 推送镜像的选项
+type PushOptions struct {
+	// MountFrom 同一仓库中可能已有相同 blob 的镜像名, 例如 team/base
+	// 仓库支持时 blob 直接从这些镜像挂载而不用再次上传
+	MountFrom []string
+}
+
+// Push 将 layout 中的镜像推送到 ref, 返回推送的 manifest 或 index 的摘要
+// 仓库中已有的 blob 不会再次上传, 本 Client 推送过的 blob 以及 MountFrom 中的 blob 尽量跨镜像挂载
+func (c *Client) Push(ctx context.Context, ref Reference, layout *Layout, opts PushOptions) (string, error) {
+	if err := c.pushDescriptor(ctx, ref, layout, layout.Root, ref.Identifier(), opts); err != nil {
+		return "", err
+	}
+	return layout.Root.Digest, nil
+}
+
+// pushDescriptor 推送 manifest 或 index 及其引用的所有内容, identifier 为推送使用的标签或摘要
+func (c *Client) pushDescriptor(ctx context.Context, ref Reference, layout *Layout, descriptor Descriptor, identifier string, opts PushOptions) error {
+	manifest, raw, err := layout.ReadManifest(descriptor)
+	if err != nil {
+		return err
+	}
+
+	if manifest.IsIndex() {
+		for _, child := range manifest.Manifests {
+			if err := c.pushDescriptor(ctx, ref, layout, child, child.Digest, opts); err != nil {
+				return err
+			}
+		}
+	} else {
+		if manifest.Config == nil {
+			return fmt.Errorf("%w: manifest %s has no config", ErrInvalidLayout, descriptor.Digest)
+		}
+		blobs := append([]Descriptor{*manifest.Config}, manifest.Layers...)
+		for _, blob := range blobs {
+			if err := c.pushBlob(ctx, ref, layout, blob, opts); err != nil {
+				return fmt.Errorf("failed to push blob %s: %w", blob.Digest, err)
+			}
+		}
+	}
+	return c.putManifest(ctx, ref, identifier, manifest.MediaType, raw, descriptor.Digest)
+}
+
+// pushBlob 上传 blob, 仓库中已有时跳过, 能挂载时从其他镜像挂载
+func (c *Client) pushBlob(ctx context.Context, ref Reference, layout *Layout, blob Descriptor, opts PushOptions) error {
+	exists, err := c.blobExists(ctx, ref, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		c.rememberBlob(ref, blob.Digest)
+		return nil
+	}
+
+	var location string
+	for _, from := range c.mountSources(ref, blob.Digest, opts.MountFrom) {
+		mounted, uploadLocation, err := c.mountBlob(ctx, ref, blob.Digest, from)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			c.rememberBlob(ref, blob.Digest)
+			return nil
+		}
+		// 挂载失败时仓库开始了一次普通上传
+		location = uploadLocation
+	}
+	if location == "" {
+		if location, err = c.startUpload(ctx, ref); err != nil {
+			return err
+		}
+	}
+
+	path, err := layout.BlobPath(blob.Digest)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+	}
+	target, err := withQuery(location, "digest", blob.Digest)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, ref, pushScopes(ref), request{
+		method: http.MethodPut,
+		path:   target,
+		header: http.Header{"Content-Type": {"application/octet-stream"}},
+		body: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+		length: info.Size(),
+	})
+	if err != nil {
+		return err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	c.rememberBlob(ref, blob.Digest)
+	return nil
+}
+
+func (c *Client) blobExists(ctx context.Context, ref Reference, digest string) (bool, error) {
+	resp, err := c.do(ctx, ref, pushScopes(ref), request{
+		method: http.MethodHead,
+		path:   "/v2/" + ref.Repository + "/blobs/" + digest,
+	})
+	if err != nil {
+		return false, err
+	}
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, responseError(resp)
+}
+
+// mountBlob 尝试从 from 挂载 blob, 未挂载时返回仓库开始的上传地址
+func (c *Client) mountBlob(ctx context.Context, ref Reference, digest, from string) (bool, string, error) {
+	query := url.Values{"mount": {digest}, "from": {from}}
+	scopes := append(pushScopes(ref), "repository:"+from+":pull")
+	resp, err := c.do(ctx, ref, scopes, request{
+		method: http.MethodPost,
+		path:   "/v2/" + ref.Repository + "/blobs/uploads/?" + query.Encode(),
+	})
+	if err != nil {
+		return false, "", err
+	}
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, "", nil
+	case http.StatusAccepted:
+		location, err := uploadLocation(resp)
+		return false, location, err
+	}
+	return false, "", responseError(resp)
+}
+
+// startUpload 开始上传 blob, 返回上传地址
+func (c *Client) startUpload(ctx context.Context, ref Reference) (string, error) {
+	resp, err := c.do(ctx, ref, pushScopes(ref), request{
+		method: http.MethodPost,
+		path:   "/v2/" + ref.Repository + "/blobs/uploads/",
+	})
+	if err != nil {
+		return "", err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusAccepted {
+		return "", responseError(resp)
+	}
+	return uploadLocation(resp)
+}
+
+// putManifest 上传 manifest 并确认仓库计算的摘要与本地相同
+func (c *Client) putManifest(ctx context.Context, ref Reference, identifier, mediaType string, raw []byte, digest string) error {
+	resp, err := c.do(ctx, ref, pushScopes(ref), request{
+		method: http.MethodPut,
+		path:   "/v2/" + ref.Repository + "/manifests/" + identifier,
+		header: http.Header{"Content-Type": {mediaType}},
+		body: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(raw)), nil
+		},
+		length: int64(len(raw)),
+	})
+	if err != nil {
+		return err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	if pushed := resp.Header.Get("Docker-Content-Digest"); pushed != "" && pushed != digest {
+		return fmt.Errorf("registry stored manifest %s as %s", digest, pushed)
+	}
+	return nil
+}
+
+// rememberBlob 记录 blob 所在的镜像, 之后推送到同一仓库的其他镜像时可以挂载
+func (c *Client) rememberBlob(ref Reference, digest string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blobs[ref.Registry+"/"+digest] = ref.Repository
+}
+
+// mountSources 返回可以挂载 blob 的镜像, 本 Client 见过的位置在前
+func (c *Client) mountSources(ref Reference, digest string, mountFrom []string) []string {
+	var sources []string
+	c.mu.Lock()
+	if repository, ok := c.blobs[ref.Registry+"/"+digest]; ok && repository != ref.Repository {
+		sources = append(sources, repository)
+	}
+	c.mu.Unlock()
+	for _, repository := range mountFrom {
+		if repository != ref.Repository && (len(sources) == 0 || sources[0] != repository) {
+			sources = append(sources, repository)
+		}
+	}
+	return sources
+}
+
+// uploadLocation 返回响应中的上传地址, 相对地址按请求地址解析
+func uploadLocation(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("registry returned no upload location")
+	}
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload location %q: %w", location, err)
+	}
+	return resp.Request.URL.ResolveReference(parsed).String(), nil
+}
+
+func withQuery(location, name, value string) (string, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+	query.Set(name, value)
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// pushScopes 返回推送镜像需要的权限范围
+func pushScopes(ref Reference) []string {
+	return []string{"repository:" + ref.Repository + ":pull,push"}
+}
@@ -0,0 +1,168 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+)
+
+func TestPush(t *testing.T) {
+	registry := newFakeRegistry(t)
+	client := registry.client(nil)
+	// 基础层、两个平台各自的配置和层
+	layout := writeLayout(t, "linux/amd64", "linux/arm64")
+
+	tests := []struct {
+		name    string
+		ref     string
+		uploads int
+		mounts  int
+	}{
+		{"first push uploads every blob once", "team/app:v1", 5, 0},
+		{"blobs already in the image are skipped", "team/app:v2", 5, 0},
+		{"blobs pushed to another image are mounted", "team/other:v1", 5, 5},
+	}
+	for _, tt := range tests {
+		ref := registry.ref(t, tt.ref)
+		digest, err := client.Push(context.Background(), ref, layout, PushOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if digest != layout.Root.Digest {
+			t.Errorf("%s: pushed %s, want %s", tt.name, digest, layout.Root.Digest)
+		}
+		if uploads, mounts := registry.counts(); uploads != tt.uploads || mounts != tt.mounts {
+			t.Errorf("%s: %d uploads and %d mounts, want %d and %d", tt.name, uploads, mounts, tt.uploads, tt.mounts)
+		}
+		image, err := client.Inspect(context.Background(), ref)
+		if err != nil || image.Digest != digest || len(image.Platforms) != 2 {
+			t.Errorf("%s: pushed image inspects as %+v, %v", tt.name, image, err)
+		}
+	}
+}
+
+func TestPushMountFrom(t *testing.T) {
+	registry := newFakeRegistry(t)
+	layout := writeLayout(t, "linux/amd64")
+	if _, err := registry.client(nil).Push(context.Background(), registry.ref(t, "team/base:v1"), layout, PushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新的 Client 不知道 blob 在哪, 只能从 MountFrom 挂载
+	tests := []struct {
+		name      string
+		ref       string
+		mountFrom []string
+		uploads   int
+		mounts    int
+	}{
+		{"without mount sources", "team/a:v1", nil, 6, 0},
+		{"mounted from the base image", "team/b:v1", []string{"team/missing", "team/base"}, 6, 3},
+	}
+	for _, tt := range tests {
+		if _, err := registry.client(nil).Push(context.Background(), registry.ref(t, tt.ref), layout, PushOptions{MountFrom: tt.mountFrom}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if uploads, mounts := registry.counts(); uploads != tt.uploads || mounts != tt.mounts {
+			t.Errorf("%s: %d uploads and %d mounts, want %d and %d", tt.name, uploads, mounts, tt.uploads, tt.mounts)
+		}
+	}
+}
+
+func TestPushAuthentication(t *testing.T) {
+	registry := newFakeRegistry(t)
+	registry.username, registry.password = "robot", "secret"
+	layout := writeLayout(t, "linux/amd64")
+
+	dockerConfig := func(username, password string) Keychain {
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		keychain, err := ParseDockerConfig([]byte(`{"auths":{"` + registry.host() + `":{"auth":"` + auth + `"}}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keychain
+	}
+	tests := []struct {
+		name     string
+		keychain Keychain
+		valid    bool
+	}{
+		{"docker config credentials", dockerConfig("robot", "secret"), true},
+		{"wrong password", dockerConfig("robot", "wrong"), false},
+		{"anonymous", nil, false},
+		{"registry token", dockerConfigKeychain{registry.host(): {RegistryToken: "token-robot"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := registry.client(tt.keychain).Push(context.Background(), registry.ref(t, "team/app:v1"), layout, PushOptions{})
+			if (err == nil) != tt.valid {
+				t.Fatalf("Push() = %v, want valid %v", err, tt.valid)
+			}
+			if err != nil && !IsPermanent(err) {
+				t.Errorf("authentication failure %v is retried", err)
+			}
+		})
+	}
+}
+
+func TestPushUploadLocation(t *testing.T) {
+	registry := newFakeRegistry(t)
+	registry.username, registry.password = "robot", "secret"
+	keychain := dockerConfigKeychain{registry.host(): {Username: "robot", Password: "secret"}}
+	// storage 接收 blob 上传, 不要求认证
+	storage := newFakeRegistry(t)
+
+	tests := []struct {
+		name       string
+		repository string
+		uploadURL  string
+		// registry 之外的服务收到的上传
+		storageUploads int
+	}{
+		{"relative location", "team/relative", "", 0},
+		{"absolute location on the registry", "team/absolute", registry.server.URL, 0},
+		{"location on another host", "team/storage", storage.server.URL, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry.uploadURL = tt.uploadURL
+			uploads, _ := storage.counts()
+			if _, err := registry.client(keychain).Push(context.Background(), registry.ref(t, tt.repository+":v1"), writeLayout(t, "linux/amd64"), PushOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if stored, _ := storage.counts(); stored-uploads != tt.storageUploads {
+				t.Errorf("%d uploads went to the other host, want %d", stored-uploads, tt.storageUploads)
+			}
+			// 令牌只发给 registry 自己
+			storage.mu.Lock()
+			defer storage.mu.Unlock()
+			if storage.authorized != 0 {
+				t.Errorf("the other host received the Authorization header %d times", storage.authorized)
+			}
+		})
+	}
+}
+
+func TestPushRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		valid    bool
+	}{
+		{"transient failures", 2, true},
+		{"retries run out", 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newFakeRegistry(t)
+			registry.failures = tt.failures
+			_, err := registry.client(nil).Push(context.Background(), registry.ref(t, "team/app:v1"), writeLayout(t, "linux/amd64"), PushOptions{})
+			if (err == nil) != tt.valid {
+				t.Fatalf("Push() = %v, want valid %v", err, tt.valid)
+			}
+			if err != nil && IsPermanent(err) {
+				t.Errorf("%v is not retried later", err)
+			}
+		})
+	}
+}
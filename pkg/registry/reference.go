@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DockerHub 没有写明仓库地址的镜像所在的仓库
+	DockerHub = "docker.io"
+	// dockerHubAPI Docker Hub 的 registry API 地址
+	dockerHubAPI = "registry-1.docker.io"
+)
+
+// Reference 解析后的镜像引用, 例如 registry.example.com/team/app:v1
+type Reference struct {
+	// Registry 仓库地址, 可以带端口, 例如 registry.example.com:5000
+	Registry string
+	// Repository 仓库中的镜像名, 例如 team/app
+	Repository string
+	// Tag 镜像标签, 同时有 Digest 时以 Digest 为准
+	Tag string
+	// Digest manifest 的摘要, 例如 sha256:...
+	Digest string
+}
+
+// ParseReference 解析镜像引用, 规则与 docker 相同
+// 没有仓库地址时为 Docker Hub, Docker Hub 上的单级镜像名属于 library, 既没有标签也没有摘要时为 latest
+func ParseReference(s string) (Reference, error) {
+	ref := Reference{}
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+		if !strings.HasPrefix(ref.Digest, "sha256:") || len(ref.Digest) != len("sha256:")+64 {
+			return Reference{}, fmt.Errorf("invalid digest in image reference %q", s)
+		}
+	}
+	// 标签在最后一个 / 之后, 端口中的 : 不是标签
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry, ref.Repository = parts[0], parts[1]
+	} else {
+		ref.Registry, ref.Repository = DockerHub, name
+	}
+	if ref.Registry == DockerHub && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+	if ref.Repository == "" || strings.ToLower(ref.Repository) != ref.Repository {
+		return Reference{}, fmt.Errorf("invalid repository in image reference %q", s)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+// Identifier 返回 manifest 请求中使用的摘要或标签
+func (r Reference) Identifier() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// Name 返回不带标签和摘要的镜像名
+func (r Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+func (r Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// WithDigest 返回指向 digest 的引用, 标签保留以便阅读
+func (r Reference) WithDigest(digest string) Reference {
+	r.Digest = digest
+	return r
+}
+
+// apiHost 返回 registry API 的地址
+func (r Reference) apiHost() string {
+	if r.Registry == DockerHub {
+		return dockerHubAPI
+	}
+	return r.Registry
+}
@@ -0,0 +1,54 @@
+package registry
+
+import "testing"
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		ref   string
+		want  Reference
+		valid bool
+	}{
+		{"busybox", Reference{Registry: DockerHub, Repository: "library/busybox", Tag: "latest"}, true},
+		{"team/app:v1", Reference{Registry: DockerHub, Repository: "team/app", Tag: "v1"}, true},
+		{"registry.example.com/team/app:v1", Reference{Registry: "registry.example.com", Repository: "team/app", Tag: "v1"}, true},
+		{"registry.example.com:5000/app", Reference{Registry: "registry.example.com:5000", Repository: "app", Tag: "latest"}, true},
+		{"localhost/app:dev", Reference{Registry: "localhost", Repository: "app", Tag: "dev"}, true},
+		{"registry.example.com/app@" + digest, Reference{Registry: "registry.example.com", Repository: "app", Digest: digest}, true},
+		{"registry.example.com/app:v1@" + digest, Reference{Registry: "registry.example.com", Repository: "app", Tag: "v1", Digest: digest}, true},
+		{"registry.example.com/app@sha256:abc", Reference{}, false},
+		{"registry.example.com/App:v1", Reference{}, false},
+		{"registry.example.com/", Reference{}, false},
+	}
+	for _, tt := range tests {
+		ref, err := ParseReference(tt.ref)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseReference(%q) = %v, want valid %v", tt.ref, err, tt.valid)
+			continue
+		}
+		if ref != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.ref, ref, tt.want)
+		}
+	}
+}
+
+func TestReferenceString(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		ref        Reference
+		string     string
+		identifier string
+	}{
+		{Reference{Registry: "r.example.com", Repository: "app", Tag: "v1"}, "r.example.com/app:v1", "v1"},
+		{Reference{Registry: "r.example.com", Repository: "app", Digest: digest}, "r.example.com/app@" + digest, digest},
+		{Reference{Registry: "r.example.com", Repository: "app", Tag: "v1"}.WithDigest(digest), "r.example.com/app:v1@" + digest, digest},
+	}
+	for _, tt := range tests {
+		if s := tt.ref.String(); s != tt.string {
+			t.Errorf("String() = %q, want %q", s, tt.string)
+		}
+		if identifier := tt.ref.Identifier(); identifier != tt.identifier {
+			t.Errorf("%s: Identifier() = %q, want %q", tt.string, identifier, tt.identifier)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package registry
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRegistry 内存中的 registry, 实现读取和推送镜像用到的 distribution API
+// username 不为空时要求 bearer 认证, 令牌通过 basic 认证从 /token 获取
+type fakeRegistry struct {
+	server   *httptest.Server
+	username string
+	password string
+
+	mu sync.Mutex
+	// blobs key 为 镜像名@摘要, 挂载时从其他镜像复制
+	blobs map[string][]byte
+	// manifests key 为 镜像名:标签 或 镜像名@摘要
+	manifests map[string]fakeManifest
+	// failures 之后的多少个请求返回 503
+	failures int
+	// uploadURL 不为空时上传地址指向这个地址, 例如另一个存储 blob 的服务
+	uploadURL string
+	uploads   int
+	mounts    int
+	requests  int
+	// authorized 带有 Authorization 请求头的请求数
+	authorized int
+}
+
+type fakeManifest struct {
+	mediaType string
+	data      []byte
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	t.Helper()
+	r := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string]fakeManifest{}}
+	r.server = httptest.NewServer(r)
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// host 返回 registry 的地址, 例如 127.0.0.1:34567
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+// client 返回访问 registry 的 Client, registry 只支持 http
+func (r *fakeRegistry) client(keychain Keychain) *Client {
+	return New(Options{Keychain: keychain, Insecure: []string{r.host()}, Timeout: 5 * time.Second, Retries: 2, Backoff: time.Millisecond})
+}
+
+func (r *fakeRegistry) ref(t *testing.T, name string) Reference {
+	t.Helper()
+	ref, err := ParseReference(r.host() + "/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	if req.Header.Get("Authorization") != "" {
+		r.authorized++
+	}
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if req.URL.Path == "/token" {
+		if username, password, ok := req.BasicAuth(); !ok || username != r.username || password != r.password {
+			r.error(w, http.StatusUnauthorized, "UNAUTHORIZED")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "token-" + r.username})
+		return
+	}
+	if r.username != "" && req.Header.Get("Authorization") != "Bearer token-"+r.username {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.server.URL+`/token",service="fake"`)
+		r.error(w, http.StatusUnauthorized, "UNAUTHORIZED")
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		repository, _, _ := strings.Cut(path, "/blobs/uploads/")
+		r.upload(w, req, repository)
+	case strings.Contains(path, "/blobs/"):
+		i := strings.LastIndex(path, "/blobs/")
+		data, ok := r.blobs[path[:i]+"@"+path[i+len("/blobs/"):]]
+		if !ok {
+			r.error(w, http.StatusNotFound, "BLOB_UNKNOWN")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if req.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		r.manifest(w, req, path[:i], path[i+len("/manifests/"):])
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (r *fakeRegistry) upload(w http.ResponseWriter, req *http.Request, repository string) {
+	query := req.URL.Query()
+	switch req.Method {
+	case http.MethodPost:
+		if digest, from := query.Get("mount"), query.Get("from"); digest != "" {
+			if data, ok := r.blobs[from+"@"+digest]; ok {
+				r.blobs[repository+"@"+digest] = data
+				r.mounts++
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		w.Header().Set("Location", r.uploadURL+"/v2/"+repository+"/blobs/uploads/"+strconv.Itoa(r.requests))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		data, _ := io.ReadAll(req.Body)
+		digest := query.Get("digest")
+		if digestOf(data) != digest {
+			r.error(w, http.StatusBadRequest, "DIGEST_INVALID")
+			return
+		}
+		r.blobs[repository+"@"+digest] = data
+		r.uploads++
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *fakeRegistry) manifest(w http.ResponseWriter, req *http.Request, repository, identifier string) {
+	separator := ":"
+	if strings.HasPrefix(identifier, "sha256:") {
+		separator = "@"
+	}
+	switch req.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(req.Body)
+		digest := digestOf(data)
+		manifest := fakeManifest{mediaType: req.Header.Get("Content-Type"), data: data}
+		r.manifests[repository+separator+identifier] = manifest
+		r.manifests[repository+"@"+digest] = manifest
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		manifest, ok := r.manifests[repository+separator+identifier]
+		if !ok {
+			r.error(w, http.StatusNotFound, "MANIFEST_UNKNOWN")
+			return
+		}
+		w.Header().Set("Content-Type", manifest.mediaType)
+		w.Header().Set("Docker-Content-Digest", digestOf(manifest.data))
+		w.Write(manifest.data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *fakeRegistry) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string][]ErrorDetail{"errors": {{Code: code, Message: strings.ToLower(code)}}})
+}
+
+// counts 返回上传和挂载的 blob 数量
+func (r *fakeRegistry) counts() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.uploads, r.mounts
+}
+
+// writeLayout 在临时目录中写入包含 platforms 的 OCI image layout, 例如 linux/arm/v7
+// 所有平台共用一个基础层, 第 i 个平台的创建时间为 2024-01-(i+1), 多个平台时 Root 为 index
+func writeLayout(t *testing.T, platforms ...string) *Layout {
+	t.Helper()
+	dir := t.TempDir()
+	writeBlob := func(mediaType string, data []byte) Descriptor {
+		digest := digestOf(data)
+		path := filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+	}
+	writeJSON := func(mediaType string, v interface{}) Descriptor {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writeBlob(mediaType, data)
+	}
+
+	base := writeBlob("application/vnd.oci.image.layer.v1.tar+gzip", []byte("base layer"))
+	var manifests []Descriptor
+	for i, platform := range platforms {
+		parts := strings.SplitN(platform, "/", 3)
+		spec := PlatformSpec{OS: parts[0], Architecture: parts[1]}
+		if len(parts) == 3 {
+			spec.Variant = parts[2]
+		}
+		config := writeJSON("application/vnd.oci.image.config.v1+json", imageConfig{
+			OS: spec.OS, Architecture: spec.Architecture, Variant: spec.Variant,
+			Created: time.Date(2024, 1, i+1, 0, 0, 0, 0, time.UTC),
+		})
+		layer := writeBlob("application/vnd.oci.image.layer.v1.tar+gzip", []byte("layer of "+platform))
+		manifest := writeJSON(MediaTypeOCIManifest, Manifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeOCIManifest,
+			Config:        &config,
+			Layers:        []Descriptor{base, layer},
+		})
+		manifest.Platform = &spec
+		manifests = append(manifests, manifest)
+	}
+
+	root := manifests[0]
+	root.Platform = nil
+	if len(manifests) > 1 {
+		root = writeJSON(MediaTypeOCIIndex, Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: manifests})
+	}
+	index, err := json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: []Descriptor{root}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), index, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	layout, err := ReadLayout(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layout
+}